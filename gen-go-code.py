@@ -406,7 +406,8 @@ def go_code_for_remote_command(name: str, cmd: RemoteCommand, template: str) ->
         JSON_DECLARATION_CODE='\n'.join(jd),
         JSON_INIT_CODE='\n'.join(jc), ARGSPEC=argspec,
         STRING_RESPONSE_IS_ERROR='true' if cmd.string_return_is_error else 'false',
-        STREAM_WANTED='true' if cmd.reads_streaming_data else 'false',
+        RESPONSE_STREAM_WANTED='true' if cmd.sends_streaming_data else 'false',
+        STREAM_WANTED='true' if cmd.reads_streaming_data or cmd.sends_streaming_data else 'false',
     )
     return ans
 # }}}
@@ -36,15 +36,22 @@ func init_caches() {
 	hash_cache = utils.NewLRUCache[string, string](sz)
 }
 
-func add_remote_dir(val string) {
-	x := filepath.Base(val)
-	idx := strings.LastIndex(x, "-")
-	if idx > -1 {
-		x = x[idx+1:]
-	} else {
-		x = ""
-	}
-	remote_dirs[val] = x
+// invalidate_caches_for_path must be called when the contents of the file at
+// path change
+func invalidate_caches_for_path(path string) {
+	size_cache.Delete(path)
+	mimetypes_cache.Delete(path)
+	data_cache.Delete(path)
+	is_text_cache.Delete(path)
+	lines_cache.Delete(path)
+	highlighted_lines_cache.Delete(path)
+	hash_cache.Delete(path)
+}
+
+// add_remote_dir records a temporary directory holding remote files, that is
+// deleted when the kitten exits, files in it are displayed as label:path
+func add_remote_dir(dir, label string) {
+	remote_dirs[dir] = label
 }
 
 func mimetype_for_path(path string) string {
@@ -206,6 +213,14 @@ func (self *Collection) finalize() {
 	})
 }
 
+// filtered returns a copy of the collection containing only the paths for
+// which keep returns true
+func (self *Collection) filtered(keep func(path string) bool) *Collection {
+	ans := *self
+	ans.all_paths = utils.Filter(self.all_paths, keep)
+	return &ans
+}
+
 func (self *Collection) Len() int { return len(self.all_paths) }
 
 func (self *Collection) Items() int { return len(self.all_paths) }
@@ -12,6 +12,8 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+
+	"kitty/tools/utils"
 )
 
 // A pair is a pair of values tracked for both the x and y side of a diff.
@@ -39,6 +41,10 @@ type pair struct{ x, y int }
 // The algorithm also guarantees to run in O(n log n) time
 // instead of the standard O(n²) time.
 //
+// If normalize is not nil, lines are compared after being transformed by it,
+// for example, to ignore whitespace. If ignore_blank_lines is true, chunks in
+// which all the inserted and removed lines are blank are omitted.
+//
 // Some systems call this approach a “patience diff,” named for
 // the “patience sorting” algorithm, itself named for a solitaire card game.
 // We avoid that name for two reasons. First, the name has been used
@@ -46,12 +52,17 @@ type pair struct{ x, y int }
 // Second, the name is frequently interpreted as meaning that you have
 // to wait longer (to be patient) for the diff, meaning that it is a slower algorithm,
 // when in fact the algorithm is faster than the standard one.
-func Diff(oldName, old, newName, new string, num_of_context_lines int) []byte {
+func Diff(oldName, old, newName, new string, num_of_context_lines int, normalize func(string) string, ignore_blank_lines bool) []byte {
 	if old == new {
 		return nil
 	}
 	x := lines(old)
 	y := lines(new)
+	// xk and yk are the lines used for comparisons
+	xk, yk := x, y
+	if normalize != nil {
+		xk, yk = utils.Map(normalize, x), utils.Map(normalize, y)
+	}
 
 	// Print diff header.
 	var out bytes.Buffer
@@ -71,7 +82,7 @@ func Diff(oldName, old, newName, new string, num_of_context_lines int) []byte {
 		count pair     // number of lines from each side in current chunk
 		ctext []string // lines for current chunk
 	)
-	for _, m := range tgs(x, y) {
+	for _, m := range tgs(xk, yk) {
 		if m.x < done.x {
 			// Already handled scanning forward from earlier match.
 			continue
@@ -82,12 +93,12 @@ func Diff(oldName, old, newName, new string, num_of_context_lines int) []byte {
 		// Note that on the first (or last) iteration we may (or definitey do)
 		// have an empty match: start.x==end.x and start.y==end.y.
 		start := m
-		for start.x > done.x && start.y > done.y && x[start.x-1] == y[start.y-1] {
+		for start.x > done.x && start.y > done.y && xk[start.x-1] == yk[start.y-1] {
 			start.x--
 			start.y--
 		}
 		end := m
-		for end.x < len(x) && end.y < len(y) && x[end.x] == y[end.y] {
+		for end.x < len(x) && end.y < len(y) && xk[end.x] == yk[end.y] {
 			end.x++
 			end.y++
 		}
@@ -139,9 +150,11 @@ func Diff(oldName, old, newName, new string, num_of_context_lines int) []byte {
 			if count.y > 0 {
 				chunk.y++
 			}
-			fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", chunk.x, count.x, chunk.y, count.y)
-			for _, s := range ctext {
-				out.WriteString(s)
+			if !ignore_blank_lines || !only_blank_lines_changed(ctext) {
+				fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", chunk.x, count.x, chunk.y, count.y)
+				for _, s := range ctext {
+					out.WriteString(s)
+				}
 			}
 			count.x = 0
 			count.y = 0
@@ -166,6 +179,15 @@ func Diff(oldName, old, newName, new string, num_of_context_lines int) []byte {
 	return out.Bytes()
 }
 
+func only_blank_lines_changed(ctext []string) bool {
+	for _, s := range ctext {
+		if s[0] != ' ' && strings.TrimSpace(s[1:]) != "" {
+			return false
+		}
+	}
+	return true
+}
+
 // lines returns the lines in the file x, including newlines.
 // If the file does not end in a newline, one is supplied
 // along with a warning about the missing newline.
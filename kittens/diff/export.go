@@ -0,0 +1,176 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+
+	"kitty/tools/tty"
+	"kitty/tools/tui/graphics"
+	"kitty/tools/tui/sgr"
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+const default_export_columns = 160
+
+func export_columns() int {
+	if ctty, err := tty.OpenControllingTerm(); err == nil {
+		sz, err := ctty.GetSize()
+		ctty.Close()
+		if err == nil && sz.Col > 0 {
+			return int(sz.Col)
+		}
+	}
+	return default_export_columns
+}
+
+func css_for_sgr(s *sgr.SGR) string {
+	ans := make([]string, 0, 4)
+	// numbered colors depend on the terminal palette, so they are ignored
+	color := func(name string, c *sgr.ColorVal) {
+		if c.Is_set && !c.Is_default && !c.Val.Is_numbered {
+			ans = append(ans, fmt.Sprintf("%s: #%02x%02x%02x", name, c.Val.Red, c.Val.Green, c.Val.Blue))
+		}
+	}
+	color("color", &s.Foreground)
+	color("background-color", &s.Background)
+	if s.Bold.Is_set && s.Bold.Val {
+		ans = append(ans, "font-weight: bold")
+	}
+	if s.Italic.Is_set && s.Italic.Val {
+		ans = append(ans, "font-style: italic")
+	}
+	if s.Underline_style.Is_set && s.Underline_style.Val != sgr.No_underline {
+		ans = append(ans, "text-decoration: underline")
+	} else if s.Strikethrough.Is_set && s.Strikethrough.Val {
+		ans = append(ans, "text-decoration: line-through")
+	}
+	return strings.Join(ans, "; ")
+}
+
+// ansi_to_html converts text containing SGR escape codes into HTML with the
+// formatting applied via inline styles
+func ansi_to_html(text string) string {
+	ans := strings.Builder{}
+	ans.Grow(2 * len(text))
+	var state sgr.SGR
+	open_css, in_span := "", false
+	ep := wcswidth.EscapeCodeParser{
+		HandleRune: func(ch rune) error {
+			if css := css_for_sgr(&state); css != open_css || !in_span {
+				if in_span {
+					ans.WriteString("</span>")
+					in_span = false
+				}
+				if open_css = css; css != "" {
+					ans.WriteString(`<span style="` + css + `">`)
+					in_span = true
+				}
+			}
+			ans.WriteString(html.EscapeString(string(ch)))
+			return nil
+		},
+		HandleCSI: func(csib []byte) error {
+			csi := utils.UnsafeBytesToString(csib)
+			if !strings.HasSuffix(csi, "m") {
+				return nil
+			}
+			if csi == "m" || csi == "0m" || strings.HasPrefix(csi, "0;") {
+				state = sgr.SGR{}
+			}
+			state.ApplySGR(sgr.SGRFromCSI(csi))
+			return nil
+		},
+	}
+	ep.ParseString(text)
+	if in_span {
+		ans.WriteString("</span>")
+	}
+	return ans.String()
+}
+
+func export_as_html(title string, lines []string) string {
+	ans := strings.Builder{}
+	ans.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	ans.WriteString("<title>" + html.EscapeString(title) + "</title>\n")
+	fmt.Fprintf(&ans, "<style>\nbody { margin: 0; color: %s; background-color: %s }\n", conf.Foreground.AsRGBSharp(), conf.Background.AsRGBSharp())
+	ans.WriteString("pre { margin: 0; font-family: monospace }\n</style>\n</head>\n<body>\n<pre>\n")
+	for _, line := range lines {
+		ans.WriteString(ansi_to_html(line))
+		ans.WriteString("\n")
+	}
+	ans.WriteString("</pre>\n</body>\n</html>\n")
+	return ans.String()
+}
+
+// export_diff renders the diff between left and right without any user
+// interaction and writes it in the specified format to the file specified by
+// --output-file or STDOUT
+func export_diff(left, right, title, format string) (err error) {
+	h := Handler{left: left, right: right, layout: opts.Layout}
+	if h.collection, err = create_collection(left, right); err != nil {
+		return err
+	}
+	context_count := opts.Context
+	if context_count < 0 {
+		context_count = int(conf.Num_context_lines)
+	}
+	if h.diff_map, err = diff(diff_jobs(h.collection), context_count); err != nil {
+		return err
+	}
+	h.calculate_statistics()
+	highlight_all(utils.Filter(h.collection.paths_to_highlight.AsSlice(), is_path_text))
+	image_collection = graphics.NewImageCollection()
+	h.collection.Apply(func(path, item_type, changed_path string) error {
+		for _, x := range []string{path, changed_path} {
+			if x != "" && is_image(x) {
+				image_collection.AddPaths(x)
+			}
+		}
+		return nil
+	})
+	image_collection.LoadAll()
+	image_display.mode = conf.Image_compare_mode
+	compare_all_images(h.collection)
+
+	columns := export_columns()
+	h.screen_size.columns = columns
+	logical_lines, err := render(h.collection, h.diff_map, h.screen_size, h.largest_line_number, graphics.Size{}, h.use_unified_layout())
+	if err != nil {
+		return err
+	}
+	lines := make([]string, 0, logical_lines.num_of_screen_lines())
+	for _, ll := range logical_lines.lines {
+		num := len(ll.screen_lines)
+		if ll.line_type == IMAGE_LINE {
+			// only the summary of an image diff is exported, not the images
+			num = ll.image_lines_offset
+		}
+		for i := 0; i < num; i++ {
+			left, right := ll.formatted_screen_line(i, logical_lines.margin_size, columns)
+			line := left + "\x1b[m"
+			if !ll.is_full_width {
+				line += right + "\x1b[m"
+			}
+			lines = append(lines, line)
+		}
+	}
+	var output string
+	switch format {
+	case "html":
+		output = export_as_html(title, lines)
+	default:
+		output = strings.Join(lines, "\n") + "\n"
+	}
+	if opts.OutputFile == "" {
+		_, err = os.Stdout.WriteString(output)
+		return err
+	}
+	return utils.AtomicUpdateFile(opts.OutputFile, utils.UnsafeStringToBytes(output), 0o644)
+}
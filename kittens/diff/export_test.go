@@ -0,0 +1,25 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestExportAsHTML(t *testing.T) {
+	for _, x := range []struct{ src, expected string }{
+		{"plain <text> & more", "plain &lt;text&gt; &amp; more"},
+		{"\x1b[1;38:2:255:0:16mbold\x1b[221;39m normal", `<span style="color: #ff0010; font-weight: bold">bold</span> normal`},
+		{"\x1b[48:2:1:2:3mbg\x1b[3mitalic\x1b[m", `<span style="background-color: #010203">bg</span><span style="background-color: #010203; font-style: italic">italic</span>`},
+		{"\x1b[31mnumbered\x1b[0;4mu\x1b[24m", `numbered<span style="text-decoration: underline">u</span>`},
+	} {
+		if diff := cmp.Diff(x.expected, ansi_to_html(x.src)); diff != "" {
+			t.Fatalf("Incorrect HTML for %#v:\n%s", x.src, diff)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"kitty/tools/tui/loop"
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+type file_tree_entry struct {
+	path, item_type string
+	name            string // the displayed name, with / as the separator
+	added, removed  int
+}
+
+func (self *file_tree_entry) status() string {
+	switch self.item_type {
+	case "add":
+		return "A"
+	case "removal":
+		return "D"
+	case "rename":
+		return "R"
+	}
+	return "M"
+}
+
+type file_tree_line struct {
+	depth int
+	text  string           // the name of the file or directory
+	entry *file_tree_entry // nil for directories
+}
+
+// The files reviewed in each pair of compared files/directories, mapped to
+// a hash of their contents when they were reviewed
+type reviewed_files struct {
+	Pairs map[string]map[string]string `json:"pairs"`
+}
+
+type FileTree struct {
+	visible    bool
+	filter     string
+	offset     int
+	review_key string
+	reviewed   *utils.CachedValues[*reviewed_files]
+	entries    []*file_tree_entry
+	by_path    map[string]*file_tree_entry
+}
+
+func new_file_tree(review_key string) *FileTree {
+	ans := FileTree{review_key: review_key, reviewed: utils.NewCachedValues("diff-reviewed", &reviewed_files{})}
+	if review_key != "" {
+		ans.reviewed.Load()
+	}
+	if ans.reviewed.Opts.Pairs == nil {
+		ans.reviewed.Opts.Pairs = make(map[string]map[string]string)
+	}
+	return &ans
+}
+
+func (self *FileTree) update(collection *Collection, diff_map map[string]*Patch) {
+	self.entries = self.entries[:0]
+	self.by_path = make(map[string]*file_tree_entry, collection.Len())
+	collection.Apply(func(path, item_type, changed_path string) error {
+		e := file_tree_entry{path: path, item_type: item_type, name: filepath.ToSlash(path_name_map[path])}
+		switch item_type {
+		case "diff":
+			if patch := diff_map[path]; patch != nil {
+				e.added, e.removed = patch.added_count, patch.removed_count
+			}
+		case "add", "removal":
+			if is_path_text(path) {
+				lines, _ := lines_for_path(path)
+				if item_type == "add" {
+					e.added = len(lines)
+				} else {
+					e.removed = len(lines)
+				}
+			}
+		case "rename":
+			e.name = filepath.ToSlash(path_name_map[changed_path])
+		}
+		self.entries = append(self.entries, &e)
+		self.by_path[path] = &e
+		return nil
+	})
+}
+
+// matches returns true if the file name or its last component matches the
+// glob pattern used to filter the files
+func (self *FileTree) matches(name string) bool {
+	if self.filter == "" {
+		return true
+	}
+	name = filepath.ToSlash(name)
+	for _, q := range []string{name, path.Base(name)} {
+		if matched, err := path.Match(self.filter, q); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *FileTree) visible_entries() []*file_tree_entry {
+	return utils.Filter(self.entries, func(e *file_tree_entry) bool { return self.matches(e.name) })
+}
+
+func contents_hash(collection *Collection, e *file_tree_entry) string {
+	ans := ""
+	for _, path := range []string{e.path, collection.changes[e.path]} {
+		if path != "" {
+			h, _ := hash_for_path(path)
+			ans += fmt.Sprintf("%x", h)
+		}
+	}
+	return ans
+}
+
+func (self *FileTree) is_reviewed(collection *Collection, e *file_tree_entry) bool {
+	h, found := self.reviewed.Opts.Pairs[self.review_key][e.name]
+	return found && h == contents_hash(collection, e)
+}
+
+func (self *FileTree) toggle_reviewed(collection *Collection, e *file_tree_entry) {
+	m := self.reviewed.Opts.Pairs[self.review_key]
+	if m == nil {
+		m = make(map[string]string)
+		self.reviewed.Opts.Pairs[self.review_key] = m
+	}
+	if self.is_reviewed(collection, e) {
+		delete(m, e.name)
+	} else {
+		m[e.name] = contents_hash(collection, e)
+	}
+	if self.review_key != "" {
+		self.reviewed.Save()
+	}
+}
+
+// lines returns the rows of the tree, with a row for every directory
+// followed by the rows for its contents
+func (self *FileTree) lines() (ans []file_tree_line) {
+	var prev []string
+	for _, e := range self.visible_entries() {
+		parts := strings.Split(e.name, "/")
+		dirs := parts[:len(parts)-1]
+		common := 0
+		for common < len(dirs) && common < len(prev) && dirs[common] == prev[common] {
+			common++
+		}
+		for i := common; i < len(dirs); i++ {
+			ans = append(ans, file_tree_line{depth: i, text: dirs[i] + "/"})
+		}
+		ans = append(ans, file_tree_line{depth: len(dirs), text: parts[len(parts)-1], entry: e})
+		prev = dirs
+	}
+	return
+}
+
+// render_line returns the formatted text for a row of the tree fitted into
+// width cells
+func (self *FileTree) render_line(collection *Collection, line file_tree_line, width int, is_current bool) string {
+	indent := strings.Repeat("  ", line.depth)
+	if line.entry == nil {
+		return statusline_format(place_in(sanitize(indent+line.text), width))
+	}
+	mark := " "
+	if self.is_reviewed(collection, line.entry) {
+		mark = "✓"
+	}
+	var counts, formatted_counts []string
+	if line.entry.added > 0 {
+		counts = append(counts, "+"+strconv.Itoa(line.entry.added))
+		formatted_counts = append(formatted_counts, added_count_format(counts[len(counts)-1]))
+	}
+	if line.entry.removed > 0 {
+		counts = append(counts, "-"+strconv.Itoa(line.entry.removed))
+		formatted_counts = append(formatted_counts, removed_count_format(counts[len(counts)-1]))
+	}
+	prefix := indent + mark + line.entry.status() + " "
+	suffix := ""
+	if len(counts) > 0 {
+		suffix = " " + strings.Join(counts, " ")
+	}
+	name := place_in(sanitize(line.text), utils.Max(0, width-wcswidth.Stringwidth(prefix)-len(suffix)))
+	if is_current {
+		return format_as_sgr.selection + place_in(prefix+name+suffix, width) + "\x1b[m"
+	}
+	if len(counts) > 0 {
+		suffix = " " + strings.Join(formatted_counts, " ")
+	}
+	return prefix + name + suffix
+}
+
+func (self *Handler) file_tree_width() int {
+	if self.file_tree == nil || !self.file_tree.visible {
+		return 0
+	}
+	// one extra column for the separator
+	return utils.Min(int(conf.File_tree_width), self.screen_size.columns/2) + 1
+}
+
+// diff_columns returns the number of columns available for the diff
+func (self *Handler) diff_columns() int {
+	return self.screen_size.columns - self.file_tree_width()
+}
+
+func (self *Handler) visible_collection() *Collection {
+	if self.file_tree.filter == "" {
+		return self.collection
+	}
+	return self.collection.filtered(func(path string) bool {
+		e := self.file_tree.by_path[path]
+		return e == nil || self.file_tree.matches(e.name)
+	})
+}
+
+// current_file_entry returns the file at the top of the screen
+func (self *Handler) current_file_entry() *file_tree_entry {
+	if self.logical_lines == nil {
+		return nil
+	}
+	for i := utils.Min(self.scroll_pos.logical_line, self.logical_lines.Len()-1); i >= 0; i-- {
+		if ll := self.logical_lines.At(i); ll.line_type == TITLE_LINE {
+			return self.file_tree.by_path[ll.left_reference.path]
+		}
+	}
+	return nil
+}
+
+func (self *Handler) draw_file_tree() {
+	width := self.file_tree_width() - 1
+	if width < 1 {
+		return
+	}
+	lines := self.file_tree.lines()
+	current := self.current_file_entry()
+	num_rows := self.screen_size.num_lines - 1 // the first row is the header
+	for i, line := range lines {
+		if line.entry != nil && line.entry == current {
+			if i < self.file_tree.offset {
+				self.file_tree.offset = i
+			} else if i >= self.file_tree.offset+num_rows {
+				self.file_tree.offset = i - num_rows + 1
+			}
+			break
+		}
+	}
+	self.file_tree.offset = utils.Max(0, utils.Min(self.file_tree.offset, len(lines)-num_rows))
+	num_reviewed := 0
+	entries := self.file_tree.visible_entries()
+	for _, e := range entries {
+		if self.file_tree.is_reviewed(self.collection, e) {
+			num_reviewed++
+		}
+	}
+	header := fmt.Sprintf("%d files, %d reviewed", len(entries), num_reviewed)
+	if self.file_tree.filter != "" {
+		header = fmt.Sprintf("%s: %s", self.file_tree.filter, header)
+	}
+	separator := statusline_format("│")
+	for y := 0; y < self.screen_size.num_lines; y++ {
+		self.lp.MoveCursorTo(self.diff_columns()+1, y+1)
+		text := ""
+		if y == 0 {
+			text = message_format(place_in(sanitize(header), width))
+		} else if idx := self.file_tree.offset + y - 1; idx < len(lines) {
+			text = self.file_tree.render_line(self.collection, lines[idx], width, lines[idx].entry != nil && lines[idx].entry == current)
+		}
+		self.lp.QueueWriteString(separator + text + "\x1b[m")
+	}
+}
+
+func (self *Handler) toggle_file_tree() error {
+	if self.logical_lines == nil {
+		return nil
+	}
+	self.file_tree.visible = !self.file_tree.visible
+	if self.diff_columns() < 8 {
+		self.file_tree.visible = false
+		self.lp.Beep()
+		return nil
+	}
+	return self.render_diff_keeping_position()
+}
+
+func (self *Handler) scroll_to_file(path string) bool {
+	for i := 0; i < self.logical_lines.Len(); i++ {
+		if ll := self.logical_lines.At(i); ll.line_type == TITLE_LINE && ll.left_reference.path == path {
+			self.scroll_pos = ScrollPos{i, 0}
+			if self.max_scroll_pos.Less(self.scroll_pos) {
+				self.scroll_pos = self.max_scroll_pos
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Handler) scroll_to_next_file(backwards bool) bool {
+	if backwards {
+		for i := self.scroll_pos.logical_line - 1; i >= 0; i-- {
+			if self.logical_lines.At(i).line_type == TITLE_LINE {
+				self.scroll_pos = ScrollPos{i, 0}
+				return true
+			}
+		}
+	} else {
+		for i := self.scroll_pos.logical_line + 1; i < self.logical_lines.Len(); i++ {
+			if self.logical_lines.At(i).line_type == TITLE_LINE {
+				self.scroll_pos = ScrollPos{i, 0}
+				if self.max_scroll_pos.Less(self.scroll_pos) {
+					self.scroll_pos = self.max_scroll_pos
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (self *Handler) toggle_reviewed() {
+	e := self.current_file_entry()
+	if e == nil {
+		self.lp.Beep()
+		return
+	}
+	self.file_tree.toggle_reviewed(self.collection, e)
+	self.draw_screen()
+}
+
+func (self *Handler) start_filter() {
+	if self.inputting_command {
+		self.lp.Beep()
+		return
+	}
+	self.inputting_command = true
+	self.inputting_filter = true
+	self.rl.SetPrompt("filter: ")
+	self.rl.SetText(self.file_tree.filter)
+	self.rl.MoveCursorToEnd()
+	self.draw_status_line()
+}
+
+func (self *Handler) set_filter(pat string) error {
+	if _, err := path.Match(pat, ""); err != nil {
+		self.statusline_message = fmt.Sprintf("Bad glob pattern: %s", err)
+		self.lp.Beep()
+		return nil
+	}
+	prev := self.file_tree.filter
+	self.file_tree.filter = pat
+	if len(self.file_tree.visible_entries()) == 0 {
+		self.file_tree.filter = prev
+		self.statusline_message = fmt.Sprintf("No files match: %s", pat)
+		self.lp.Beep()
+		return nil
+	}
+	self.clear_mouse_selection()
+	if err := self.render_diff(); err != nil {
+		return err
+	}
+	self.scroll_pos = ScrollPos{}
+	self.draw_screen()
+	return nil
+}
+
+func (self *Handler) handle_file_tree_click(ev *loop.MouseEvent) {
+	if ev.Cell.Y < 1 || ev.Cell.Y >= self.screen_size.num_lines {
+		return
+	}
+	lines := self.file_tree.lines()
+	if idx := self.file_tree.offset + ev.Cell.Y - 1; idx < len(lines) && lines[idx].entry != nil {
+		if self.scroll_to_file(lines[idx].entry.path) {
+			self.draw_screen()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestFileTree(t *testing.T) {
+	ft := FileTree{}
+	for _, name := range []string{"README", "docs/index.rst", "src/a.go", "src/sub/b.go", "src/sub/c.py", "tools/d.go"} {
+		ft.entries = append(ft.entries, &file_tree_entry{name: name})
+	}
+	tree := func() (ans []string) {
+		for _, line := range ft.lines() {
+			ans = append(ans, fmt.Sprintf("%d:%s", line.depth, line.text))
+		}
+		return
+	}
+	expected := []string{"0:README", "0:docs/", "1:index.rst", "0:src/", "1:a.go", "1:sub/", "2:b.go", "2:c.py", "0:tools/", "1:d.go"}
+	if diff := cmp.Diff(expected, tree()); diff != "" {
+		t.Fatalf("Incorrect tree:\n%s", diff)
+	}
+	ft.filter = "*.go"
+	expected = []string{"0:src/", "1:a.go", "1:sub/", "2:b.go", "0:tools/", "1:d.go"}
+	if diff := cmp.Diff(expected, tree()); diff != "" {
+		t.Fatalf("Incorrect tree for %#v:\n%s", ft.filter, diff)
+	}
+	ft.filter = "src/*"
+	expected = []string{"0:src/", "1:a.go"}
+	if diff := cmp.Diff(expected, tree()); diff != "" {
+		t.Fatalf("Incorrect tree for %#v:\n%s", ft.filter, diff)
+	}
+	for _, x := range []struct {
+		filter, name string
+		expected     bool
+	}{
+		{"", "x/y", true},
+		{"y", "x/y", true},
+		{"x/*", "x/y", true},
+		{"x", "x/y", false},
+		{"*.[ch]", "a/b.c", true},
+	} {
+		ft.filter = x.filter
+		if actual := ft.matches(x.name); actual != x.expected {
+			t.Fatalf("Matching %#v against %#v returned %v", x.filter, x.name, actual)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+func git(stdin io.Reader, args ...string) (string, error) {
+	c := exec.Command("git", args...)
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	c.Stdin, c.Stdout, c.Stderr = stdin, &stdout, &stderr
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("Running git %s failed with error: %w\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// make_temp_dir creates a directory to hold reconstructed files, it is
+// deleted when the kitten exits
+func make_temp_dir(label string) (string, error) {
+	tdir, err := os.MkdirTemp("", "kitty-diff-*")
+	if err != nil {
+		return "", err
+	}
+	add_remote_dir(tdir, label)
+	return tdir, nil
+}
+
+func write_file_in(dir, name string, data []byte) error {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("Refusing to create the file %#v outside the temporary directory", name)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// split_range splits revision ranges of the form A..B and A...B, returning
+// the revisions, with HEAD for empty ones
+func split_range(arg string) (left, right string, is_range, is_symmetric bool) {
+	left, right, is_range = strings.Cut(arg, "..")
+	if !is_range {
+		return arg, "", false, false
+	}
+	if strings.HasPrefix(right, ".") {
+		right, is_symmetric = right[1:], true
+	}
+	if left == "" {
+		left = "HEAD"
+	}
+	if right == "" {
+		right = "HEAD"
+	}
+	return
+}
+
+func is_git_revision(arg string) bool {
+	revs := []string{arg}
+	if left, right, is_range, _ := split_range(arg); is_range {
+		revs = []string{left, right}
+	}
+	for _, rev := range revs {
+		if _, err := git(nil, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// is_git_invocation returns true if the command line arguments are of the
+// form used by git diff: [revision [revision]] [-- paths...]. No arguments
+// compare the index to the working tree when run inside a git repository.
+func is_git_invocation(args []string) bool {
+	if slices.Contains(args, "--") {
+		return true
+	}
+	if len(args) > 2 {
+		return false
+	}
+	// avoid running git for arguments that are not revisions
+	for _, arg := range args {
+		if arg == "" || exists(arg) || is_remote_path(arg) {
+			return false
+		}
+	}
+	if out, err := git(nil, "rev-parse", "--is-inside-work-tree"); err != nil || strings.TrimSpace(out) != "true" {
+		return false
+	}
+	for _, arg := range args {
+		if !is_git_revision(arg) {
+			return false
+		}
+	}
+	return true
+}
+
+// parse_git_args returns the revisions to compare and the paths to restrict
+// the comparison to. An empty left revision means the index and an empty
+// right revision means the working tree, as for git diff. When staged is true
+// the left revision, HEAD by default, is compared to the index, as for git
+// diff --staged.
+func parse_git_args(args []string, staged bool) (left, right string, paths []string, err error) {
+	revs := args
+	if idx := slices.Index(args, "--"); idx > -1 {
+		revs, paths = args[:idx], args[idx+1:]
+	}
+	if staged {
+		switch len(revs) {
+		case 0:
+			left = "HEAD"
+		case 1:
+			left = revs[0]
+		default:
+			return "", "", nil, fmt.Errorf("Only a single git revision can be compared to the index, got: %s", strings.Join(revs, " "))
+		}
+		return
+	}
+	switch len(revs) {
+	case 0:
+	case 1:
+		l, r, is_range, is_symmetric := split_range(revs[0])
+		left, right = l, r
+		if is_symmetric {
+			base, err := git(nil, "merge-base", l, r)
+			if err != nil {
+				return "", "", nil, err
+			}
+			left = strings.TrimSpace(base)
+		} else if !is_range {
+			left, right = revs[0], ""
+		}
+	case 2:
+		left, right = revs[0], revs[1]
+	default:
+		return "", "", nil, fmt.Errorf("At most two git revisions can be compared, got: %s", strings.Join(revs, " "))
+	}
+	return
+}
+
+// A comparison between two git revisions, the index or the working tree
+type git_comparison struct {
+	top                 string // the top level directory of the repository
+	left, right         string // the revisions, empty for the index
+	working_tree        bool   // the right side is the working tree
+	left_dir, right_dir string // where the files being compared are written
+}
+
+// read returns the contents of the file with the specified path relative to
+// the top of the repository from one side of the comparison
+func (self *git_comparison) read(is_left bool, name string) ([]byte, error) {
+	rev := self.left
+	if !is_left {
+		if self.working_tree {
+			return os.ReadFile(filepath.Join(self.top, filepath.FromSlash(name)))
+		}
+		rev = self.right
+	}
+	data, err := git(nil, "-C", self.top, "show", "--no-textconv", rev+":"+name)
+	return []byte(data), err
+}
+
+type git_change struct {
+	status, name string
+}
+
+// parse_raw_git_diff returns the changed files in the output of git diff
+// --raw --no-renames -z. Submodules and unmerged files are skipped, as there
+// are no files to show for them.
+func parse_raw_git_diff(out string) (ans []git_change) {
+	const gitlink_mode = "160000"
+	fields := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	unmerged := make(map[string]bool)
+	for i := 0; i+1 < len(fields); i += 2 {
+		// :old_mode new_mode old_hash new_hash status
+		meta := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(meta) != 5 {
+			continue
+		}
+		name, status := fields[i+1], meta[4][:1]
+		switch {
+		case status == "U":
+			// unmerged files are also listed as modified
+			unmerged[name] = true
+		case meta[0] != gitlink_mode && meta[1] != gitlink_mode:
+			ans = append(ans, git_change{status, name})
+		}
+	}
+	return utils.Filter(ans, func(c git_change) bool { return !unmerged[c.name] })
+}
+
+// get_git_revisions writes the files that differ between the specified git
+// revisions into two temporary directories
+func get_git_revisions(args []string, staged bool) (ans *git_comparison, err error) {
+	left, right, paths, err := parse_git_args(args, staged)
+	if err != nil {
+		return
+	}
+	top, err := git(nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return
+	}
+	ans = &git_comparison{top: strings.TrimRight(top, "\n"), left: left, right: right, working_tree: right == "" && !staged}
+	cmd := []string{"diff", "--raw", "--no-renames", "-z"}
+	if staged {
+		cmd = append(cmd, "--cached")
+	}
+	for _, rev := range []string{left, right} {
+		if rev != "" {
+			cmd = append(cmd, rev)
+		}
+	}
+	cmd = append(cmd, "--")
+	out, err := git(nil, append(cmd, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	changes := parse_raw_git_diff(out)
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("There are no changes to show")
+	}
+	left_label, right_label := left, right
+	if left_label == "" {
+		left_label = "index"
+	}
+	if ans.working_tree {
+		right_label = "working tree"
+	} else if right_label == "" {
+		right_label = "index"
+	}
+	if ans.left_dir, err = make_temp_dir(left_label); err != nil {
+		return nil, err
+	}
+	if ans.right_dir, err = make_temp_dir(right_label); err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		status, name := c.status, c.name
+		if status != "A" {
+			data, err := ans.read(true, name)
+			if err == nil {
+				err = write_file_in(ans.left_dir, name, data)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if status != "D" {
+			data, err := ans.read(false, name)
+			if err == nil {
+				err = write_file_in(ans.right_dir, name, data)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
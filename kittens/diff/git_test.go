@@ -0,0 +1,35 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestParseRawGitDiff(t *testing.T) {
+	p := func(expected []git_change, lines ...string) {
+		actual := parse_raw_git_diff(strings.Join(lines, "\x00") + "\x00")
+		if diff := cmp.Diff(expected, actual, cmp.AllowUnexported(git_change{})); diff != "" {
+			t.Fatalf("Failed to parse %#v:\n%s", lines, diff)
+		}
+	}
+	p([]git_change{{"A", ".gitmodules"}, {"M", "f"}},
+		":000000 100644 0000000 1a780c1 A", ".gitmodules",
+		":100644 100644 d00491f 0000000 M", "f",
+		":000000 160000 0000000 0b759b6 A", "sm",
+	)
+	p([]git_change{{"D", "g"}},
+		":000000 100644 0000000 0000000 U", "f",
+		":100644 100644 00750ed 0000000 M", "f",
+		":100644 000000 00750ed 0000000 D", "g",
+	)
+	if ans := parse_raw_git_diff(""); len(ans) != 0 {
+		t.Fatalf("Changes found in empty output: %#v", ans)
+	}
+}
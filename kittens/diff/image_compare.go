@@ -0,0 +1,255 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"kitty/tools/utils"
+	"kitty/tools/utils/images"
+)
+
+var _ = fmt.Print
+
+var mask_color = color.NRGBA{R: 0xff, A: 0xff}
+
+// The pixels of a pair of changed images, on canvases of the same size
+type image_comparison struct {
+	left, right *image.NRGBA
+	percent     float64 // the percentage of pixels that differ
+}
+
+var image_comparisons = struct {
+	sync.Mutex
+	items      map[string]*image_comparison // keyed by the path of the left image
+	composites map[string]string            // the keys of the composites added to the image collection, mapped to the path of the left image
+}{items: make(map[string]*image_comparison), composites: make(map[string]string)}
+
+// How the right image of a pair of changed images is displayed, the blend is
+// the opacity of the right image in onion-skin mode and the position of the
+// divider in swipe mode, as a percentage
+var image_display = struct {
+	mode  Image_compare_mode_Choice_Type
+	blend int
+}{blend: 50}
+
+func comparison_for(left_path string) *image_comparison {
+	image_comparisons.Lock()
+	defer image_comparisons.Unlock()
+	return image_comparisons.items[left_path]
+}
+
+func to_canvas(data *images.ImageData, width, height int) *image.NRGBA {
+	ans := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if len(data.Frames) > 0 {
+		// only the first frame of animated images is compared
+		img := data.Frames[0].Img
+		draw.Draw(ans, image.Rect(0, 0, data.Frames[0].Width, data.Frames[0].Height), img, img.Bounds().Min, draw.Src)
+	}
+	return ans
+}
+
+// compare_images compares the images pixel by pixel, pixels outside the
+// smaller image count as different
+func compare_images(left, right *images.ImageData) *image_comparison {
+	width, height := utils.Max(left.Width, right.Width), utils.Max(left.Height, right.Height)
+	ans := image_comparison{left: to_canvas(left, width, height), right: to_canvas(right, width, height)}
+	if width == 0 || height == 0 {
+		return &ans
+	}
+	differing := make([]int, height)
+	ctx := images.Context{}
+	ctx.Parallel(0, height, func(nums <-chan int) {
+		for y := range nums {
+			a, b := ans.left.Pix[y*ans.left.Stride:], ans.right.Pix[y*ans.right.Stride:]
+			for x := 0; x < 4*width; x += 4 {
+				if a[x] != b[x] || a[x+1] != b[x+1] || a[x+2] != b[x+2] || a[x+3] != b[x+3] {
+					differing[y]++
+				}
+			}
+		}
+	})
+	total := 0
+	for _, n := range differing {
+		total += n
+	}
+	ans.percent = 100 * float64(total) / float64(width*height)
+	return &ans
+}
+
+// composite renders the comparison in the specified mode, in difference mode
+// the differing pixels are shown in red over a faded copy of the right image
+func (self *image_comparison) composite(mode Image_compare_mode_Choice_Type, blend int) *image.NRGBA {
+	b := self.right.Bounds()
+	ans := image.NewNRGBA(b)
+	split := b.Dx() * blend / 100
+	ctx := images.Context{}
+	ctx.Parallel(0, b.Dy(), func(nums <-chan int) {
+		for y := range nums {
+			for x := 0; x < b.Dx(); x++ {
+				l, r := self.left.NRGBAAt(x, y), self.right.NRGBAAt(x, y)
+				var c color.NRGBA
+				switch mode {
+				case Image_compare_mode_difference:
+					if l != r {
+						c = mask_color
+					} else {
+						gray := (299*uint32(r.R) + 587*uint32(r.G) + 114*uint32(r.B)) / 1000
+						v := uint8(255 - (255-gray)/4)
+						c = color.NRGBA{v, v, v, 0xff}
+					}
+				case Image_compare_mode_onion_skin:
+					mix := func(a, b uint8) uint8 { return uint8((int(a)*(100-blend) + int(b)*blend) / 100) }
+					c = color.NRGBA{mix(l.R, r.R), mix(l.G, r.G), mix(l.B, r.B), mix(l.A, r.A)}
+				case Image_compare_mode_swipe:
+					switch {
+					case x == split:
+						c = mask_color
+					case x < split:
+						c = l
+					default:
+						c = r
+					}
+				default:
+					c = r
+				}
+				ans.SetNRGBA(x, y, c)
+			}
+		}
+	})
+	return ans
+}
+
+func composite_key(left_path string, mode Image_compare_mode_Choice_Type, blend int) string {
+	if mode == Image_compare_mode_difference {
+		blend = 0
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d", left_path, mode, blend)
+}
+
+// right_image_key returns the key of the image to display in place of the
+// right image of a pair of changed images
+func right_image_key(left_path, right_path string) string {
+	if left_path == "" || image_display.mode == Image_compare_mode_side_by_side || comparison_for(left_path) == nil {
+		return right_path
+	}
+	return composite_key(left_path, image_display.mode, image_display.blend)
+}
+
+// compare_all_images compares all pairs of changed images in the collection,
+// the images must have been loaded already
+func compare_all_images(collection *Collection) {
+	collection.Apply(func(path, item_type, changed_path string) error {
+		if item_type != "diff" || !is_image(path) || !is_image(changed_path) {
+			return nil
+		}
+		left, right := image_collection.SourceImage(path), image_collection.SourceImage(changed_path)
+		if left != nil && right != nil {
+			c := compare_images(left, right)
+			image_comparisons.Lock()
+			image_comparisons.items[path] = c
+			image_comparisons.Unlock()
+		}
+		return nil
+	})
+}
+
+// add_composites adds the images needed to display all comparisons in the
+// current mode to the image collection
+func add_composites(mode Image_compare_mode_Choice_Type, blend int) {
+	if mode == Image_compare_mode_side_by_side {
+		return
+	}
+	image_comparisons.Lock()
+	defer image_comparisons.Unlock()
+	for path, c := range image_comparisons.items {
+		key := composite_key(path, mode, blend)
+		if image_collection.SourceImage(key) == nil {
+			img := c.composite(mode, blend)
+			b := img.Bounds()
+			image_collection.AddImage(key, &images.ImageData{
+				Width: b.Dx(), Height: b.Dy(), Format_uppercase: "PNG",
+				Frames: []*images.ImageFrame{{Img: img, Width: b.Dx(), Height: b.Dy()}},
+			})
+			image_comparisons.composites[key] = path
+		}
+	}
+}
+
+// remove_stale_composites removes the composites not needed to display the
+// comparisons in the current mode from the image collection, as they are full
+// size images and there is one for every mode and blend used. Must be called
+// in the main thread.
+func (self *Handler) remove_stale_composites() {
+	image_comparisons.Lock()
+	var stale []string
+	for key, path := range image_comparisons.composites {
+		if image_display.mode == Image_compare_mode_side_by_side || key != composite_key(path, image_display.mode, image_display.blend) {
+			stale = append(stale, key)
+			delete(image_comparisons.composites, key)
+		}
+	}
+	image_comparisons.Unlock()
+	if len(stale) > 0 {
+		image_collection.RemoveImages(self.lp, stale...)
+	}
+}
+
+func (self *Handler) update_image_composites() {
+	mode, blend, page_size := image_display.mode, image_display.blend, self.images_resized_to
+	go func() {
+		add_composites(mode, blend)
+		if page_size.Width > 0 && page_size.Height > 0 {
+			image_collection.ResizeForPageSize(page_size.Width, page_size.Height)
+		}
+		r := AsyncResult{rtype: IMAGE_RESIZE, page_size: page_size}
+		self.async_results <- r
+		self.lp.WakeupMainThread()
+	}()
+}
+
+func (self *Handler) cycle_image_compare_mode() {
+	if self.image_count == 0 {
+		self.lp.Beep()
+		return
+	}
+	image_display.mode = (image_display.mode + 1) % (Image_compare_mode_swipe + 1)
+	self.update_image_composites()
+}
+
+func (self *Handler) adjust_image_blend(delta int) {
+	if image_display.mode != Image_compare_mode_onion_skin && image_display.mode != Image_compare_mode_swipe {
+		self.lp.Beep()
+		return
+	}
+	blend := utils.Max(0, utils.Min(image_display.blend+delta, 100))
+	if blend == image_display.blend {
+		self.lp.Beep()
+		return
+	}
+	image_display.blend = blend
+	self.update_image_composites()
+}
+
+// image_display_description describes how a pair of changed images is being
+// displayed, for the line above the images
+func image_display_description(left_path string) string {
+	c := comparison_for(left_path)
+	if c == nil {
+		return ""
+	}
+	ans := fmt.Sprintf("Differs: %.2f%%", c.percent)
+	switch image_display.mode {
+	case Image_compare_mode_difference:
+		ans += " Showing: difference"
+	case Image_compare_mode_onion_skin:
+		ans += fmt.Sprintf(" Showing: onion skin %d%%", image_display.blend)
+	case Image_compare_mode_swipe:
+		ans += fmt.Sprintf(" Showing: swipe %d%%", image_display.blend)
+	}
+	return ans
+}
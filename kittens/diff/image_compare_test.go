@@ -0,0 +1,86 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"kitty/tools/tui/graphics"
+	"kitty/tools/utils/images"
+)
+
+var _ = fmt.Print
+
+func TestImageComparison(t *testing.T) {
+	white, black := color.NRGBA{0xff, 0xff, 0xff, 0xff}, color.NRGBA{0, 0, 0, 0xff}
+	make_image := func(width, height int, black_pixels ...image.Point) *images.ImageData {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetNRGBA(x, y, white)
+			}
+		}
+		for _, p := range black_pixels {
+			img.SetNRGBA(p.X, p.Y, black)
+		}
+		return &images.ImageData{Width: width, Height: height, Frames: []*images.ImageFrame{{Img: img, Width: width, Height: height}}}
+	}
+	for _, x := range []struct {
+		left, right *images.ImageData
+		percent     float64
+	}{
+		{make_image(4, 4), make_image(4, 4), 0},
+		{make_image(4, 4), make_image(4, 4, image.Point{1, 1}, image.Point{2, 3}), 12.5},
+		{make_image(4, 4), make_image(4, 2), 50},
+	} {
+		c := compare_images(x.left, x.right)
+		if c.percent != x.percent {
+			t.Fatalf("Incorrect difference: %v != %v", c.percent, x.percent)
+		}
+	}
+	c := compare_images(make_image(4, 1, image.Point{0, 0}), make_image(4, 1, image.Point{3, 0}))
+	mask := c.composite(Image_compare_mode_difference, 0)
+	for x, expected := range []bool{true, false, false, true} {
+		if actual := mask.NRGBAAt(x, 0) == mask_color; actual != expected {
+			t.Fatalf("Incorrect difference mask at pixel %d: %v", x, actual)
+		}
+	}
+	swipe := c.composite(Image_compare_mode_swipe, 50)
+	for x, expected := range []color.NRGBA{black, white, mask_color, black} {
+		if actual := swipe.NRGBAAt(x, 0); actual != expected {
+			t.Fatalf("Incorrect swipe pixel %d: %v != %v", x, actual, expected)
+		}
+	}
+	onion := c.composite(Image_compare_mode_onion_skin, 50)
+	if actual := onion.NRGBAAt(0, 0); actual != (color.NRGBA{0x7f, 0x7f, 0x7f, 0xff}) {
+		t.Fatalf("Incorrect onion skin pixel: %v", actual)
+	}
+
+	// only the composites for the current mode and blend are kept
+	orig_collection, orig_display := image_collection, image_display
+	image_collection = graphics.NewImageCollection()
+	image_comparisons.items["a"] = c
+	defer func() {
+		image_collection, image_display = orig_collection, orig_display
+		image_comparisons.Lock()
+		delete(image_comparisons.items, "a")
+		image_comparisons.Unlock()
+	}()
+	image_display.mode = Image_compare_mode_onion_skin
+	for _, blend := range []int{50, 60} {
+		add_composites(image_display.mode, blend)
+		image_display.blend = blend
+	}
+	(&Handler{}).remove_stale_composites()
+	if image_collection.SourceImage(composite_key("a", image_display.mode, 50)) != nil || image_collection.SourceImage(composite_key("a", image_display.mode, 60)) == nil {
+		t.Fatalf("Stale composite not removed or current composite removed")
+	}
+	image_display.mode = Image_compare_mode_side_by_side
+	(&Handler{}).remove_stale_composites()
+	if image_collection.SourceImage(composite_key("a", Image_compare_mode_onion_skin, 60)) != nil {
+		t.Fatalf("Composite not removed in side-by-side mode")
+	}
+}
@@ -3,20 +3,14 @@
 package diff
 
 import (
-	"archive/tar"
-	"bytes"
 	"fmt"
-	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
-	"kitty/kittens/ssh"
 	"kitty/tools/cli"
 	"kitty/tools/config"
 	"kitty/tools/tui/loop"
-	"kitty/tools/utils"
 )
 
 var _ = fmt.Print
@@ -48,64 +42,12 @@ func exists(path string) bool {
 	return err == nil
 }
 
-func get_ssh_file(hostname, rpath string) (string, error) {
-	tdir, err := os.MkdirTemp("", "*-"+hostname)
-	if err != nil {
-		return "", err
-	}
-	add_remote_dir(tdir)
-	is_abs := strings.HasPrefix(rpath, "/")
-	for strings.HasPrefix(rpath, "/") {
-		rpath = rpath[1:]
-	}
-	cmd := []string{ssh.SSHExe(), hostname, "tar", "-c", "-f", "-"}
-	if is_abs {
-		cmd = append(cmd, "-C", "/")
-	}
-	cmd = append(cmd, rpath)
-	c := exec.Command(cmd[0], cmd[1:]...)
-	c.Stdin, c.Stderr = os.Stdin, os.Stderr
-	stdout, err := c.Output()
-	if err != nil {
-		return "", fmt.Errorf("Failed to ssh into remote host %s to get file %s with error: %w", hostname, rpath, err)
-	}
-	tf := tar.NewReader(bytes.NewReader(stdout))
-	count, err := utils.ExtractAllFromTar(tf, tdir)
-	if err != nil {
-		return "", fmt.Errorf("Failed to untar data from remote host %s to get file %s with error: %w", hostname, rpath, err)
-	}
-	ans := filepath.Join(tdir, rpath)
-	if count == 1 {
-		filepath.WalkDir(tdir, func(path string, d fs.DirEntry, err error) error {
-			if !d.IsDir() {
-				ans = path
-				return fs.SkipAll
-			}
-			return nil
-		})
-	}
-	return ans, nil
-}
-
-func get_remote_file(path string) (string, error) {
-	if strings.HasPrefix(path, "ssh:") {
-		parts := strings.SplitN(path, ":", 3)
-		if len(parts) == 3 {
-			return get_ssh_file(parts[1], parts[2])
-		}
-	}
-	return path, nil
-}
-
 func main(_ *cli.Command, opts_ *Options, args []string) (rc int, err error) {
 	opts = opts_
 	conf, err = load_config(opts)
 	if err != nil {
 		return 1, err
 	}
-	if len(args) != 2 {
-		return 1, fmt.Errorf("You must specify exactly two files/directories to compare")
-	}
 	if err = set_diff_command(conf.Diff_cmd); err != nil {
 		return 1, err
 	}
@@ -116,13 +58,64 @@ func main(_ *cli.Command, opts_ *Options, args []string) (rc int, err error) {
 			os.RemoveAll(tdir)
 		}
 	}()
-	left, err := get_remote_file(args[0])
-	if err != nil {
-		return 1, err
-	}
-	right, err := get_remote_file(args[1])
-	if err != nil {
-		return 1, err
+	var left, right, title string
+	// identifies the compared items when remembering which files were reviewed
+	var review_key string
+	var merge *Merge
+	var git_diff *git_comparison
+	if opts.Merge && opts.Output != "interactive" {
+		return 1, fmt.Errorf("The --output option cannot be used with --merge")
+	}
+	switch {
+	case opts.Merge:
+		if len(args) != 3 {
+			return 1, fmt.Errorf("You must specify exactly three files to merge: BASE LOCAL REMOTE")
+		}
+		if opts.MergedFile == "" {
+			return 1, fmt.Errorf("You must specify the file to write the merged result to with --merged-file")
+		}
+		title = fmt.Sprintf("Merging %s and %s", args[1], args[2])
+		paths := make([]string, len(args))
+		for i, x := range args {
+			if paths[i], err = filepath.Abs(x); err != nil {
+				return 1, err
+			}
+		}
+		if merge, err = create_merge(paths[0], paths[1], paths[2], opts.MergedFile); err != nil {
+			return 1, err
+		}
+		left, right = args[1], args[2]
+	case len(args) == 1 && args[0] == "-":
+		title = "Patch from STDIN"
+		if left, right, err = get_patch_from_stdin(); err != nil {
+			return 1, err
+		}
+	case opts.Staged || is_git_invocation(args):
+		title = "git diff " + strings.Join(args, " ")
+		if opts.Staged {
+			title = "git diff --staged " + strings.Join(args, " ")
+		}
+		if git_diff, err = get_git_revisions(args, opts.Staged); err != nil {
+			return 1, err
+		}
+		left, right = git_diff.left_dir, git_diff.right_dir
+		review_key = git_diff.top + "\x00" + title
+	case len(args) != 2:
+		return 1, fmt.Errorf("You must specify exactly two files/directories to compare")
+	default:
+		title = fmt.Sprintf("%s vs. %s", args[0], args[1])
+		review_key = args[0] + "\x00" + args[1]
+		if a, err := filepath.Abs(args[0]); err == nil && exists(a) {
+			if b, err := filepath.Abs(args[1]); err == nil && exists(b) {
+				review_key = a + "\x00" + b
+			}
+		}
+		if left, err = get_remote_file(args[0]); err != nil {
+			return 1, err
+		}
+		if right, err = get_remote_file(args[1]); err != nil {
+			return 1, err
+		}
 	}
 	if isdir(left) != isdir(right) {
 		return 1, fmt.Errorf("The items to be diffed should both be either directories or files. Comparing a directory to a file is not valid.'")
@@ -133,17 +126,23 @@ func main(_ *cli.Command, opts_ *Options, args []string) (rc int, err error) {
 	if !exists(right) {
 		return 1, fmt.Errorf("%s does not exist", right)
 	}
+	if opts.Output != "interactive" {
+		if err = export_diff(left, right, title, opts.Output); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
 	lp, err = loop.New()
 	loop.MouseTrackingMode(lp, loop.BUTTONS_AND_DRAG_MOUSE_TRACKING)
 	if err != nil {
 		return 1, err
 	}
-	h := Handler{left: left, right: right, lp: lp}
+	h := Handler{left: left, right: right, lp: lp, merge: merge, git: git_diff, scrolled_to_hunk: -1, file_tree: new_file_tree(review_key)}
 	lp.OnInitialize = func() (string, error) {
 		lp.SetCursorVisible(false)
 		lp.SetCursorShape(loop.BAR_CURSOR, true)
 		lp.AllowLineWrapping(false)
-		lp.SetWindowTitle(fmt.Sprintf("%s vs. %s", left, right))
+		lp.SetWindowTitle(title)
 		h.initialize()
 		return "", nil
 	}
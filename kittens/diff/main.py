@@ -49,10 +49,57 @@ def main(args: List[str]) -> None:
 '''
     )
 
+opt('ignore_whitespace', 'none', choices=('none', 'eol', 'change', 'all'),
+    long_text='''
+Ignore changes in whitespace when comparing lines. :code:`eol` ignores
+whitespace at the end of lines, :code:`change` also ignores changes in the
+amount of whitespace and :code:`all` ignores all whitespace. Applies to the
+builtin differ and is translated to the corresponding flags for the :code:`git`
+and :code:`diff` commands. Custom diff commands are not changed. Versions of
+:code:`diff` other than GNU diff 3.4 and newer cannot ignore only trailing
+whitespace, so with them :code:`eol` is treated as :code:`change`.
+'''
+    )
+
+opt('ignore_blank_lines', 'no', option_type='to_bool',
+    long_text='''
+Ignore changes whose lines are all blank. Applies to the builtin differ and the
+:code:`git` and :code:`diff` commands.
+'''
+    )
+
+opt('detect_moves', 'no', option_type='to_bool',
+    long_text='''
+Detect blocks of lines that were moved, even between files, and show them using
+the :opt:`moved_from_bg <kitten-diff.moved_from_bg>` and :opt:`moved_to_bg
+<kitten-diff.moved_to_bg>` colors instead of as removed and added lines.
+Indentation is ignored when detecting moved lines.
+'''
+    )
+
+opt('image_compare_mode', 'side-by-side', choices=('side-by-side', 'difference', 'onion-skin', 'swipe'),
+    long_text='''
+How to show changed images. :code:`side-by-side` shows the old and new images
+next to each other. The other modes replace the new image with a comparison of
+the two: :code:`difference` shows the pixels that differ in red, over a faded
+copy of the new image, :code:`onion-skin` shows the new image blended over the
+old one and :code:`swipe` shows the old image to the left of a divider and the
+new one to its right. The percentage of pixels that differ is always shown.
+'''
+    )
+
 opt('replace_tab_by', '\\x20\\x20\\x20\\x20', option_type='python_string',
     long_text='The string to replace tabs with. Default is to use four spaces.'
     )
 
+opt('unified_layout_below', '100', option_type='positive_int',
+    long_text='''
+When the layout is :code:`auto`, show a unified diff, with the removed lines
+above the added lines in a single column, in windows narrower than this number
+of columns, and a side-by-side diff otherwise. See :option:`kitten diff --layout`.
+'''
+    )
+
 opt('+ignore_name', '', ctype='string',
     add_to_default=False,
     long_text='''
@@ -66,6 +113,32 @@ def main(args: List[str]) -> None:
 ''',
     )
 
+opt('+remote_source', '', ctype='string',
+    add_to_default=False,
+    long_text='''
+A command used to fetch remote files to diff, specified as :italic:`name
+command`. Files are then specified as :italic:`name:host:path`. The command can
+use the placeholders: :code:`{host}`, :code:`{path}`, :code:`{dir}` and
+:code:`{name}`, for the host, the remote path and its parent directory and name.
+The command must write a tar archive containing :code:`{name}` relative to
+:code:`{dir}` to STDOUT. Alternately, it can use the :code:`{dest}` placeholder,
+a local directory into which it must copy the remote path. Can be specified
+multiple times to define multiple commands, they override the builtin
+:code:`ssh`, :code:`docker`, :code:`kubectl` and :code:`sftp` commands of the
+same name. For example::
+
+    remote_source podman podman exec -i {host} tar -c -f - -C {dir} {name}
+    remote_source s3 aws s3 cp s3://{host}/{path} {dest}/{name}
+''',
+    )
+
+opt('file_tree_width', '30', option_type='positive_int',
+    long_text='''
+The width, in columns, of the sidebar showing the tree of changed files. The
+sidebar is never wider than half the window.
+'''
+    )
+
 egr()  # }}}
 
 # colors {{{
@@ -133,6 +206,24 @@ def main(args: List[str]) -> None:
     option_type='to_color',
     )
 
+opt('moved_from_bg', '#f5f0ff',
+    option_type='to_color',
+    long_text='Backgrounds for lines that were moved elsewhere, when :opt:`kitten-diff.detect_moves` is enabled'
+    )
+
+opt('moved_from_margin_bg', '#e6dcff',
+    option_type='to_color',
+    )
+
+opt('moved_to_bg', '#e8f6ff',
+    option_type='to_color',
+    long_text='Backgrounds for lines that were moved from elsewhere'
+    )
+
+opt('moved_to_margin_bg', '#cde9ff',
+    option_type='to_color',
+    )
+
 opt('filler_bg', '#fafbfc',
     option_type='to_color',
     long_text='Filler (empty) line background'
@@ -267,6 +358,70 @@ def main(args: List[str]) -> None:
     'search_backward_simple b start_search substring backward',
     )
 
+map('Toggle between the unified and side-by-side layouts',
+    'toggle_layout u toggle_layout',
+    )
+
+map('Show/hide the sidebar with the tree of changed files',
+    'toggle_file_tree t toggle_file_tree',
+    )
+
+map('Scroll to next file',
+    'next_file ] scroll_to next-file',
+    )
+
+map('Scroll to previous file',
+    'prev_file [ scroll_to prev-file',
+    )
+
+map('Show only the files matching a glob pattern',
+    'filter_files shift+f filter_files',
+    )
+
+map('Mark the current file as reviewed or not reviewed',
+    'toggle_reviewed v toggle_reviewed',
+    )
+
+map('Cycle through the modes for comparing changed images',
+    'cycle_image_compare_mode i cycle_image_compare_mode',
+    )
+
+map('Decrease the opacity of the new image or move the divider left when comparing images',
+    'decrease_image_blend left adjust_image_blend -10',
+    )
+
+map('Increase the opacity of the new image or move the divider right when comparing images',
+    'increase_image_blend right adjust_image_blend 10',
+    )
+
+map('Use the LOCAL version of the current change when merging',
+    'merge_use_local l resolve_conflict local',
+    )
+
+map('Use the REMOTE version of the current change when merging',
+    'merge_use_remote r resolve_conflict remote',
+    )
+
+map('Use both versions of the current change when merging',
+    'merge_use_both shift+b resolve_conflict both',
+    )
+
+map('Save the merged file and quit',
+    'save_merge w save_merge',
+    )
+
+map('Stage the current hunk in git',
+    'stage_hunk s apply_hunk stage',
+    )
+
+map('Unstage the current hunk in git',
+    'unstage_hunk shift+s apply_hunk unstage',
+    )
+
+map('Revert the current hunk in the git working tree, discarding the changes, after asking for confirmation',
+    'revert_hunk shift+x apply_hunk revert',
+    )
+
 map('Copy selection to clipboard', 'copy_to_clipboard y copy_to_clipboard')
 map('Copy selection to clipboard or exit if no selection is present', 'copy_to_clipboard_or_exit ctrl+c copy_to_clipboard_or_exit')
 
@@ -280,6 +435,50 @@ def main(args: List[str]) -> None:
 number set in :file:`diff.conf`.
 
 
+--layout
+choices=auto,side-by-side,unified
+default=auto
+How to lay out the diff. :code:`side-by-side` shows the old and new versions of
+files next to each other. :code:`unified` shows the removed lines above the
+added lines in a single column, which is easier to read in narrow windows.
+:code:`auto` uses the unified layout when the window is narrower than
+:opt:`unified_layout_below <kitten-diff.unified_layout_below>` columns.
+
+
+--staged
+type=bool-set
+Compare the git index to the specified git revision, HEAD by default, like
+:code:`git diff --staged`. Hunks can then be unstaged from the index.
+
+
+--merge
+type=bool-set
+Merge two files instead of showing a diff. Three files must be specified,
+:italic:`BASE LOCAL REMOTE`, where BASE is the common ancestor of the two files
+LOCAL and REMOTE being merged. The changes between LOCAL and REMOTE are shown
+one at a time and can be resolved by picking either or both versions. The
+result is written to the file specified by :option:`--merged-file`. Suitable for
+use as a git mergetool.
+
+
+--merged-file
+Where to write the merged file when using :option:`--merge`.
+
+
+--output
+choices=interactive,html,ansi
+default=interactive
+Instead of showing the diff interactively, write it as a self-contained
+:code:`html` page or as :code:`ansi` text with formatting escape codes, to the
+file specified by :option:`--output-file` or STDOUT. Useful for attaching diffs
+to emails and CI artifacts. The diff is laid out to fit the width of the
+terminal, or 160 columns if there is no terminal.
+
+
+--output-file
+Where to write the diff when using :option:`--output`, STDOUT if not specified.
+
+
 --config
 type=list
 completion=type:file ext:conf group:"Config files" kwds:none,NONE
@@ -292,8 +491,15 @@ def main(args: List[str]) -> None:
 Syntax: :italic:`name=value`. For example: :italic:`-o background=gray`
 
 '''.format, config_help=CONFIG_HELP.format(conf_name='diff', appname=appname))
-help_text = 'Show a side-by-side diff of the specified files/directories. You can also use :italic:`ssh:hostname:remote-file-path` to diff remote files.'
-usage = 'file_or_directory_left file_or_directory_right'
+help_text = '''\
+Show a side-by-side diff of the specified files/directories. You can also use :italic:`ssh:hostname:remote-file-path`, :italic:`docker:container:remote-file-path`, :italic:`kubectl:pod:remote-file-path` or :italic:`sftp:hostname:remote-file-path` to diff remote files. More ways to get remote files can be defined with :opt:`kitten-diff.remote_source`.
+
+Use :code:`-` instead of the files to show a patch read from STDIN, for example: :code:`git log -p | kitten diff -`. \
+Instead of files, you can also specify git revisions and paths the way :program:`git diff` accepts them, for example: \
+:code:`kitten diff HEAD~3..HEAD -- some/path`. With no arguments, the changes in the git working tree that \
+have not been staged are shown. Individual hunks can be staged, unstaged or reverted with the keyboard shortcuts.
+'''
+usage = 'file_or_directory_left file_or_directory_right | - | [git_revision [git_revision]] [-- paths...]'
 
 
 
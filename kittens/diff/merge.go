@@ -0,0 +1,430 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+type line_range struct{ start, end int }
+
+func (self line_range) Len() int { return self.end - self.start }
+
+type merge_region_type int
+
+const (
+	UNCHANGED_REGION merge_region_type = iota
+	LOCAL_CHANGE_REGION
+	REMOTE_CHANGE_REGION
+	CONFLICT_REGION
+)
+
+type merge_resolution int
+
+const (
+	UNRESOLVED merge_resolution = iota
+	USE_LOCAL
+	USE_REMOTE
+	USE_BOTH
+)
+
+// A region of the three files being merged, changed regions are shown as
+// hunks and can be resolved in favor of either or both sides
+type merge_region struct {
+	rtype               merge_region_type
+	base, local, remote line_range
+	resolution          merge_resolution
+	conflict_num        int // one based
+}
+
+func (self *merge_region) title(num_of_conflicts int) string {
+	var ans string
+	switch self.rtype {
+	case CONFLICT_REGION:
+		ans = fmt.Sprintf("Conflict %d of %d", self.conflict_num, num_of_conflicts)
+	case LOCAL_CHANGE_REGION:
+		ans = "Changed only in LOCAL"
+	case REMOTE_CHANGE_REGION:
+		ans = "Changed only in REMOTE"
+	}
+	switch self.resolution {
+	case UNRESOLVED:
+		return ans + ", unresolved"
+	case USE_LOCAL:
+		return ans + ", using LOCAL"
+	case USE_REMOTE:
+		return ans + ", using REMOTE"
+	}
+	return ans + ", using both"
+}
+
+type Merge struct {
+	base, local, remote, output string
+	base_lines                  []string
+	local_lines, remote_lines   []string
+	regions                     []*merge_region
+	num_of_conflicts            int
+}
+
+// lines_with_endings splits text into lines, keeping the line endings, so that
+// the merged result can be written out exactly
+func lines_with_endings(text string) []string {
+	ans := strings.SplitAfter(text, "\n")
+	if ans[len(ans)-1] == "" {
+		ans = ans[:len(ans)-1]
+	}
+	return ans
+}
+
+type merge_edit struct{ base, other line_range }
+
+func merge_edits(base, other []string) (ans []merge_edit) {
+	differing_ranges(base, other, func(x_start, x_end, y_start, y_end int) {
+		ans = append(ans, merge_edit{line_range{x_start, x_end}, line_range{y_start, y_end}})
+	})
+	return
+}
+
+func lines_equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// merge_regions performs a three way merge of local and remote, with base
+// being their common ancestor. Changes from the two sides that overlap or are
+// adjacent to each other in base are conflicts, unless they are identical.
+func merge_regions(base, local, remote []string) (ans []*merge_region) {
+	local_edits, remote_edits := merge_edits(base, local), merge_edits(base, remote)
+	pos, local_delta, remote_delta := 0, 0, 0
+	add_region := func(r *merge_region) {
+		if len(ans) > 0 && r.rtype == UNCHANGED_REGION && ans[len(ans)-1].rtype == UNCHANGED_REGION {
+			// merge adjacent unchanged regions so that changed regions are
+			// always separated by exactly one unchanged region
+			prev := ans[len(ans)-1]
+			prev.base.end, prev.local.end, prev.remote.end = r.base.end, r.local.end, r.remote.end
+		} else {
+			ans = append(ans, r)
+		}
+	}
+	add_unchanged := func(end int) {
+		if end > pos {
+			add_region(&merge_region{
+				base: line_range{pos, end}, local: line_range{pos + local_delta, end + local_delta}, remote: line_range{pos + remote_delta, end + remote_delta}})
+		}
+	}
+	// the range of other corresponding to base[start:end], given the edits
+	// overlapping that range
+	other_range := func(start, end, delta int, edits []merge_edit) line_range {
+		if len(edits) == 0 {
+			return line_range{start + delta, end + delta}
+		}
+		first, last := edits[0], edits[len(edits)-1]
+		return line_range{first.other.start - (first.base.start - start), last.other.end + (end - last.base.end)}
+	}
+	i, j := 0, 0
+	for i < len(local_edits) || j < len(remote_edits) {
+		start := len(base)
+		if i < len(local_edits) {
+			start = local_edits[i].base.start
+		}
+		if j < len(remote_edits) {
+			start = utils.Min(start, remote_edits[j].base.start)
+		}
+		end, li, rj := start, i, j
+		for {
+			if li < len(local_edits) && local_edits[li].base.start <= end {
+				end = utils.Max(end, local_edits[li].base.end)
+				li++
+			} else if rj < len(remote_edits) && remote_edits[rj].base.start <= end {
+				end = utils.Max(end, remote_edits[rj].base.end)
+				rj++
+			} else {
+				break
+			}
+		}
+		add_unchanged(start)
+		r := &merge_region{
+			base:   line_range{start, end},
+			local:  other_range(start, end, local_delta, local_edits[i:li]),
+			remote: other_range(start, end, remote_delta, remote_edits[j:rj]),
+		}
+		switch {
+		case li == i:
+			r.rtype, r.resolution = REMOTE_CHANGE_REGION, USE_REMOTE
+		case rj == j:
+			r.rtype, r.resolution = LOCAL_CHANGE_REGION, USE_LOCAL
+		case lines_equal(local[r.local.start:r.local.end], remote[r.remote.start:r.remote.end]):
+			r.rtype = UNCHANGED_REGION
+		default:
+			r.rtype = CONFLICT_REGION
+		}
+		add_region(r)
+		pos, local_delta, remote_delta = end, r.local.end-end, r.remote.end-end
+		i, j = li, rj
+	}
+	add_unchanged(len(base))
+	return
+}
+
+func create_merge(base, local, remote, output string) (ans *Merge, err error) {
+	ans = &Merge{base: base, local: local, remote: remote, output: output}
+	for _, x := range []struct {
+		path  string
+		lines *[]string
+	}{{base, &ans.base_lines}, {local, &ans.local_lines}, {remote, &ans.remote_lines}} {
+		if !is_path_text(x.path) {
+			return nil, fmt.Errorf("Only text files can be merged, %s is not a text file", x.path)
+		}
+		data, err := data_for_path(x.path)
+		if err != nil {
+			return nil, err
+		}
+		*x.lines = lines_with_endings(data)
+	}
+	ans.regions = merge_regions(ans.base_lines, ans.local_lines, ans.remote_lines)
+	for _, r := range ans.regions {
+		if r.rtype == CONFLICT_REGION {
+			ans.num_of_conflicts++
+			r.conflict_num = ans.num_of_conflicts
+		}
+	}
+	return
+}
+
+func (self *Merge) changed_regions() []*merge_region {
+	return utils.Filter(self.regions, func(r *merge_region) bool { return r.rtype != UNCHANGED_REGION })
+}
+
+func (self *Merge) num_of_resolved_conflicts() (ans int) {
+	for _, r := range self.regions {
+		if r.rtype == CONFLICT_REGION && r.resolution != UNRESOLVED {
+			ans++
+		}
+	}
+	return
+}
+
+// as_patch returns the differences between local and remote as a patch with
+// one hunk per changed region, titled with how the region is resolved
+func (self *Merge) as_patch(context_count int) (ans *Patch, err error) {
+	left_lines, err := lines_for_path(self.local)
+	if err != nil {
+		return
+	}
+	right_lines, err := lines_for_path(self.remote)
+	if err != nil {
+		return
+	}
+	ans = &Patch{all_hunks: make([]*Hunk, 0, len(self.regions))}
+	var current_hunk *Hunk
+	context_lines := func(count int) {
+		for i := 0; i < count; i++ {
+			current_hunk.context_line()
+			current_hunk.left_count++
+			current_hunk.right_count++
+		}
+	}
+	for i, r := range self.regions {
+		if r.rtype == UNCHANGED_REGION {
+			continue
+		}
+		before := 0
+		if i > 0 && self.regions[i-1].rtype == UNCHANGED_REGION {
+			prev := self.regions[i-1]
+			available := prev.local.Len()
+			if i > 1 {
+				// some of the unchanged lines are the context after the
+				// previous hunk
+				available -= utils.Min(context_count, available)
+			}
+			before = utils.Min(context_count, available)
+		}
+		current_hunk = &Hunk{
+			title: r.title(self.num_of_conflicts), left_start: r.local.start - before, right_start: r.remote.start - before}
+		ans.all_hunks = append(ans.all_hunks, current_hunk)
+		context_lines(before)
+		for l := 0; l < r.local.Len(); l++ {
+			current_hunk.remove_line()
+		}
+		for l := 0; l < r.remote.Len(); l++ {
+			current_hunk.add_line()
+		}
+		current_hunk.left_count += r.local.Len()
+		current_hunk.right_count += r.remote.Len()
+		if i+1 < len(self.regions) && self.regions[i+1].rtype == UNCHANGED_REGION {
+			context_lines(utils.Min(context_count, self.regions[i+1].local.Len()))
+		}
+	}
+	for _, h := range ans.all_hunks {
+		if err = h.finalize(left_lines, right_lines); err != nil {
+			return nil, err
+		}
+		h.largest_line_number = utils.Max(h.left_start+h.left_count, h.right_start+h.right_count)
+		ans.largest_line_number = utils.Max(ans.largest_line_number, h.largest_line_number)
+		ans.added_count += h.added_count
+		ans.removed_count += h.removed_count
+	}
+	return
+}
+
+// result returns the merged text, with conflict markers for unresolved
+// conflicts
+func (self *Merge) result() string {
+	ans := strings.Builder{}
+	write := func(lines []string, r line_range) {
+		for _, line := range lines[r.start:r.end] {
+			ans.WriteString(line)
+		}
+	}
+	marker := func(m string) {
+		if s := ans.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			ans.WriteString("\n")
+		}
+		ans.WriteString(m + "\n")
+	}
+	for _, r := range self.regions {
+		switch r.resolution {
+		case USE_LOCAL:
+			write(self.local_lines, r.local)
+		case USE_REMOTE:
+			write(self.remote_lines, r.remote)
+		case USE_BOTH:
+			write(self.local_lines, r.local)
+			if r.local.Len() > 0 && r.remote.Len() > 0 && !strings.HasSuffix(self.local_lines[r.local.end-1], "\n") {
+				ans.WriteString("\n")
+			}
+			write(self.remote_lines, r.remote)
+		default:
+			if r.rtype == UNCHANGED_REGION {
+				write(self.local_lines, r.local)
+				continue
+			}
+			marker("<<<<<<< LOCAL")
+			write(self.local_lines, r.local)
+			marker("||||||| BASE")
+			write(self.base_lines, r.base)
+			marker("=======")
+			write(self.remote_lines, r.remote)
+			marker(">>>>>>> REMOTE")
+		}
+	}
+	return ans.String()
+}
+
+func (self *Merge) write() error {
+	mode := os.FileMode(0o644)
+	if s, err := os.Stat(self.local); err == nil {
+		mode = s.Mode().Perm()
+	}
+	return utils.AtomicUpdateFile(self.output, utils.UnsafeStringToBytes(self.result()), mode)
+}
+
+// current_hunk returns the index of the hunk at the top of the screen
+func (self *Handler) current_hunk() (ans int) {
+	if self.scrolled_to_hunk > -1 && self.scroll_pos == self.scrolled_to_hunk_pos {
+		// the hunk may not be at the top of the screen if it is near the end
+		return self.scrolled_to_hunk
+	}
+	ans = -1
+	for i := 0; i <= self.scroll_pos.logical_line && i < self.logical_lines.Len(); i++ {
+		if self.logical_lines.At(i).line_type == HUNK_TITLE_LINE {
+			ans++
+		}
+	}
+	return utils.Max(0, ans)
+}
+
+func (self *Handler) scroll_to_hunk(idx int) {
+	self.scrolled_to_hunk = idx
+	for i := 0; i < self.logical_lines.Len(); i++ {
+		if self.logical_lines.At(i).line_type == HUNK_TITLE_LINE {
+			if idx == 0 {
+				self.scroll_pos = ScrollPos{i, 0}
+				if self.max_scroll_pos.Less(self.scroll_pos) {
+					self.scroll_pos = self.max_scroll_pos
+				}
+				self.scrolled_to_hunk_pos = self.scroll_pos
+				return
+			}
+			idx--
+		}
+	}
+}
+
+func (self *Handler) resolve_conflict(how string) error {
+	if self.merge == nil || self.logical_lines == nil || self.diff_map == nil {
+		self.lp.Beep()
+		return nil
+	}
+	regions := self.merge.changed_regions()
+	idx := self.current_hunk()
+	self.scrolled_to_hunk = -1
+	if idx >= len(regions) {
+		self.lp.Beep()
+		return nil
+	}
+	switch how {
+	case "local":
+		regions[idx].resolution = USE_LOCAL
+	case "remote":
+		regions[idx].resolution = USE_REMOTE
+	case "both":
+		regions[idx].resolution = USE_BOTH
+	default:
+		return fmt.Errorf("Unknown conflict resolution: %#v", how)
+	}
+	patch, err := self.merge.as_patch(self.current_context_count)
+	if err != nil {
+		return err
+	}
+	self.diff_map[self.merge.local] = patch
+	self.clear_mouse_selection()
+	if err = self.render_diff(); err != nil {
+		return err
+	}
+	next := -1
+	for i := idx + 1; i < len(regions); i++ {
+		if regions[i].resolution == UNRESOLVED {
+			next = i
+			break
+		}
+	}
+	if next > -1 {
+		self.scroll_to_hunk(next)
+	} else if self.merge.num_of_resolved_conflicts() == self.merge.num_of_conflicts {
+		self.statusline_message = "All conflicts resolved, save the merged file to finish"
+	}
+	self.draw_screen()
+	return nil
+}
+
+func (self *Handler) save_merge() {
+	if self.merge == nil {
+		self.lp.Beep()
+		return
+	}
+	if err := self.merge.write(); err != nil {
+		self.statusline_message = fmt.Sprintf("Failed to save the merged file with error: %s", err)
+		self.draw_status_line()
+		return
+	}
+	if self.merge.num_of_resolved_conflicts() == self.merge.num_of_conflicts {
+		self.lp.Quit(0)
+	} else {
+		// the unresolved conflicts are saved with conflict markers
+		self.lp.Quit(1)
+	}
+}
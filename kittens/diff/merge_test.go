@@ -0,0 +1,73 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestThreeWayMerge(t *testing.T) {
+	lines := func(x string) []string { return lines_with_endings(strings.ReplaceAll(x, " ", "\n")) }
+	type region struct {
+		Type                merge_region_type
+		Base, Local, Remote [2]int
+	}
+	for _, x := range []struct {
+		base, local, remote string
+		regions             []region
+		merged              string
+	}{
+		{"a b c d e ", "a B c d e ", "a b c D e ",
+			[]region{{UNCHANGED_REGION, [2]int{0, 1}, [2]int{0, 1}, [2]int{0, 1}},
+				{LOCAL_CHANGE_REGION, [2]int{1, 2}, [2]int{1, 2}, [2]int{1, 2}},
+				{UNCHANGED_REGION, [2]int{2, 3}, [2]int{2, 3}, [2]int{2, 3}},
+				{REMOTE_CHANGE_REGION, [2]int{3, 4}, [2]int{3, 4}, [2]int{3, 4}},
+				{UNCHANGED_REGION, [2]int{4, 5}, [2]int{4, 5}, [2]int{4, 5}}},
+			"a B c D e "},
+		{"a b c ", "a X c ", "a Y Z c ",
+			[]region{{UNCHANGED_REGION, [2]int{0, 1}, [2]int{0, 1}, [2]int{0, 1}},
+				{CONFLICT_REGION, [2]int{1, 2}, [2]int{1, 2}, [2]int{1, 3}},
+				{UNCHANGED_REGION, [2]int{2, 3}, [2]int{2, 3}, [2]int{3, 4}}},
+			"a <<<<<<<.LOCAL X |||||||.BASE b ======= Y Z >>>>>>>.REMOTE c "},
+		{"a b c ", "a X c ", "a X c ",
+			[]region{{UNCHANGED_REGION, [2]int{0, 3}, [2]int{0, 3}, [2]int{0, 3}}},
+			"a X c "},
+		{"a b c ", "a b c new ", "a c ",
+			[]region{{UNCHANGED_REGION, [2]int{0, 1}, [2]int{0, 1}, [2]int{0, 1}},
+				{REMOTE_CHANGE_REGION, [2]int{1, 2}, [2]int{1, 2}, [2]int{1, 1}},
+				{UNCHANGED_REGION, [2]int{2, 3}, [2]int{2, 3}, [2]int{1, 2}},
+				{LOCAL_CHANGE_REGION, [2]int{3, 3}, [2]int{3, 4}, [2]int{2, 2}}},
+			"a c new "},
+	} {
+		m := Merge{base_lines: lines(x.base), local_lines: lines(x.local), remote_lines: lines(x.remote)}
+		m.regions = merge_regions(m.base_lines, m.local_lines, m.remote_lines)
+		actual := make([]region, len(m.regions))
+		for i, r := range m.regions {
+			actual[i] = region{r.rtype, [2]int{r.base.start, r.base.end}, [2]int{r.local.start, r.local.end}, [2]int{r.remote.start, r.remote.end}}
+		}
+		if diff := cmp.Diff(x.regions, actual); diff != "" {
+			t.Fatalf("Incorrect merge of %#v %#v %#v:\n%s", x.base, x.local, x.remote, diff)
+		}
+		merged := strings.ReplaceAll(strings.ReplaceAll(m.result(), " ", "."), "\n", " ")
+		if diff := cmp.Diff(x.merged, merged); diff != "" {
+			t.Fatalf("Incorrect merge result for %#v %#v %#v:\n%s", x.base, x.local, x.remote, diff)
+		}
+	}
+	m := Merge{base_lines: lines("a b c "), local_lines: lines("a X c "), remote_lines: lines("a Y c ")}
+	m.regions = merge_regions(m.base_lines, m.local_lines, m.remote_lines)
+	for _, x := range []struct {
+		resolution merge_resolution
+		expected   string
+	}{{USE_LOCAL, "a\nX\nc\n"}, {USE_REMOTE, "a\nY\nc\n"}, {USE_BOTH, "a\nX\nY\nc\n"}} {
+		m.regions[1].resolution = x.resolution
+		if diff := cmp.Diff(x.expected, m.result()); diff != "" {
+			t.Fatalf("Incorrect merge result for resolution %d:\n%s", x.resolution, diff)
+		}
+	}
+}
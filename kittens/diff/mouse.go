@@ -77,7 +77,7 @@ func (self *line_pos) LessThan(other tui.LinePos) bool {
 func (self *Handler) line_pos_from_pos(x int, pos ScrollPos) *line_pos {
 	ans := line_pos{min_x: self.logical_lines.margin_size, y: pos}
 	available_cols := self.logical_lines.columns / 2
-	if x >= available_cols {
+	if x >= available_cols && !self.logical_lines.unified {
 		ans.min_x += available_cols
 		ans.max_x = utils.Max(ans.min_x, ans.min_x+self.logical_lines.ScreenLineAt(pos).right.wcswidth()-1)
 	} else {
@@ -88,6 +88,9 @@ func (self *Handler) line_pos_from_pos(x int, pos ScrollPos) *line_pos {
 
 func (self *Handler) start_mouse_selection(ev *loop.MouseEvent) {
 	available_cols := self.logical_lines.columns / 2
+	if self.logical_lines.unified {
+		available_cols = self.logical_lines.columns
+	}
 	if ev.Cell.Y >= self.screen_size.num_lines || ev.Cell.X < self.logical_lines.margin_size || (ev.Cell.X >= available_cols && ev.Cell.X < available_cols+self.logical_lines.margin_size) {
 		return
 	}
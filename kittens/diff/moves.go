@@ -0,0 +1,134 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var _ = fmt.Print
+
+// Blocks of moved lines with fewer alphanumeric characters than this are not
+// marked as moved, as they are likely to be the same only by coincidence,
+// this is the same threshold as used by git diff --color-moved
+const MIN_MOVED_BLOCK_ALNUM = 20
+
+type patch_with_lines struct {
+	patch                   *Patch
+	left_lines, right_lines []string
+}
+
+func count_alnum(text string) (ans int) {
+	for _, ch := range text {
+		if unicode.IsLetter(ch) || unicode.IsDigit(ch) {
+			ans++
+		}
+	}
+	return
+}
+
+// unmark_short_blocks removes the blocks of consecutive moved lines in
+// lines[start:start+count] that are too short to be considered moved
+func unmark_short_blocks(moved map[int]bool, lines []string, start, count int) {
+	for i := start; i < start+count; {
+		if !moved[i] {
+			i++
+			continue
+		}
+		j, alnum := i, 0
+		for ; j < start+count && moved[j]; j++ {
+			alnum += count_alnum(lines[j])
+		}
+		if alnum < MIN_MOVED_BLOCK_ALNUM {
+			for k := i; k < j; k++ {
+				delete(moved, k)
+			}
+		}
+		i = j
+	}
+}
+
+// detect_moves marks the removed lines that were added elsewhere and the
+// added lines that were removed elsewhere, in any of the patches. Indentation
+// is ignored, as is whitespace that normalize removes, if it is not nil.
+func detect_moves(patches []patch_with_lines, normalize func(string) string) {
+	key := func(text string) string {
+		if normalize != nil {
+			text = normalize(text)
+		}
+		return strings.TrimSpace(text)
+	}
+	changed_lines := func(f func(p *patch_with_lines, c *Chunk, is_left bool, line int, text string)) {
+		for i := range patches {
+			p := &patches[i]
+			if p.patch == nil {
+				continue
+			}
+			for _, h := range p.patch.all_hunks {
+				for _, c := range h.chunks {
+					if c.is_context {
+						continue
+					}
+					for l := c.left_start; l < c.left_start+c.left_count; l++ {
+						f(p, c, true, l, p.left_lines[l])
+					}
+					for l := c.right_start; l < c.right_start+c.right_count; l++ {
+						f(p, c, false, l, p.right_lines[l])
+					}
+				}
+			}
+		}
+	}
+	// the chunks each removed or added line occurs in
+	removed, added := make(map[string][]*Chunk), make(map[string][]*Chunk)
+	changed_lines(func(p *patch_with_lines, c *Chunk, is_left bool, line int, text string) {
+		if k := key(text); k != "" {
+			if is_left {
+				removed[k] = append(removed[k], c)
+			} else {
+				added[k] = append(added[k], c)
+			}
+		}
+	})
+	// A line that is removed and added in the same chunk has been modified
+	// in place, for example, re-indented, rather than moved
+	elsewhere := func(chunks []*Chunk, c *Chunk) bool {
+		for _, x := range chunks {
+			if x != c {
+				return true
+			}
+		}
+		return false
+	}
+	changed_lines(func(p *patch_with_lines, c *Chunk, is_left bool, line int, text string) {
+		k := key(text)
+		if is_left {
+			if elsewhere(added[k], c) {
+				if p.patch.moved_left == nil {
+					p.patch.moved_left = make(map[int]bool)
+				}
+				p.patch.moved_left[line] = true
+			}
+		} else if elsewhere(removed[k], c) {
+			if p.patch.moved_right == nil {
+				p.patch.moved_right = make(map[int]bool)
+			}
+			p.patch.moved_right[line] = true
+		}
+	})
+	for _, p := range patches {
+		if p.patch == nil {
+			continue
+		}
+		for _, h := range p.patch.all_hunks {
+			for _, c := range h.chunks {
+				if !c.is_context {
+					unmark_short_blocks(p.patch.moved_left, p.left_lines, c.left_start, c.left_count)
+					unmark_short_blocks(p.patch.moved_right, p.right_lines, c.right_start, c.right_count)
+				}
+			}
+		}
+	}
+}
@@ -11,8 +11,13 @@ import (
 	"kitty/tools/utils/shlex"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slices"
 )
 
 var _ = fmt.Print
@@ -60,13 +65,114 @@ func set_diff_command(q string) error {
 	return nil
 }
 
-type Center struct{ offset, left_size, right_size int }
+// normalizer returns a function that transforms lines so that lines that
+// differ only in the ignored whitespace are equal, or nil if no whitespace is
+// ignored
+func normalizer(ignore_whitespace Ignore_whitespace_Choice_Type) func(string) string {
+	switch ignore_whitespace {
+	case Ignore_whitespace_eol:
+		return func(x string) string { return strings.TrimRightFunc(x, unicode.IsSpace) }
+	case Ignore_whitespace_change:
+		return func(x string) string {
+			x = strings.TrimRightFunc(x, unicode.IsSpace)
+			ans := strings.Builder{}
+			ans.Grow(len(x))
+			in_space := false
+			for _, ch := range x {
+				if unicode.IsSpace(ch) {
+					if !in_space {
+						ans.WriteByte(' ')
+					}
+					in_space = true
+				} else {
+					ans.WriteRune(ch)
+					in_space = false
+				}
+			}
+			return ans.String()
+		}
+	case Ignore_whitespace_all:
+		return func(x string) string {
+			return strings.Map(func(ch rune) rune {
+				if unicode.IsSpace(ch) {
+					return -1
+				}
+				return ch
+			}, x)
+		}
+	}
+	return nil
+}
+
+// differ_flags returns the flags that make the git and diff commands ignore
+// the same things as the builtin differ, custom commands are left alone
+func differ_flags(cmd []string) (ans []string) {
+	if len(cmd) == 0 {
+		return
+	}
+	is_git := filepath.Base(cmd[0]) == "git"
+	if !is_git && filepath.Base(cmd[0]) != "diff" {
+		return
+	}
+	flag := func(git_flag, diff_flag string) {
+		if is_git {
+			ans = append(ans, git_flag)
+		} else {
+			ans = append(ans, diff_flag)
+		}
+	}
+	// the short flags for diff are understood by the BSD and busybox diffs as well
+	switch conf.Ignore_whitespace {
+	case Ignore_whitespace_eol:
+		if is_git || diff_has_ignore_trailing_space() {
+			flag("--ignore-space-at-eol", "--ignore-trailing-space")
+		} else {
+			// ignores more than just trailing whitespace, but is the closest
+			// available
+			ans = append(ans, "-b")
+		}
+	case Ignore_whitespace_change:
+		flag("--ignore-space-change", "-b")
+	case Ignore_whitespace_all:
+		flag("--ignore-all-space", "-w")
+	}
+	if conf.Ignore_blank_lines {
+		flag("--ignore-blank-lines", "-B")
+	}
+	return
+}
+
+// diff_has_ignore_trailing_space returns true if the diff command is GNU diff
+// 3.4 or newer, the first version with --ignore-trailing-space
+var diff_has_ignore_trailing_space = utils.Once(func() bool {
+	out, err := exec.Command(DiffExe(), "--version").Output()
+	if err != nil {
+		return false
+	}
+	return is_gnu_diff_with_ignore_trailing_space(utils.UnsafeBytesToString(out))
+})
+
+func is_gnu_diff_with_ignore_trailing_space(version_text string) bool {
+	m := regexp.MustCompile(`\(GNU diffutils\) (\d+)\.(\d+)`).FindStringSubmatch(version_text)
+	if m == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return major > 3 || (major == 3 && minor >= 4)
+}
+
+// A range of bytes in a line
+type Segment struct{ offset, size int }
+
+// The segments that differ between a pair of changed lines
+type LineChanges struct{ left, right []Segment }
 
 type Chunk struct {
 	is_context              bool
 	left_start, right_start int
 	left_count, right_count int
-	centers                 []Center
+	changes                 []LineChanges
 }
 
 func (self *Chunk) add_line() {
@@ -82,25 +188,99 @@ func (self *Chunk) context_line() {
 	self.right_count++
 }
 
-func changed_center(left, right string) (ans Center) {
-	if len(left) > 0 && len(right) > 0 {
-		ll, rl := len(left), len(right)
-		ml := utils.Min(ll, rl)
-		for ; ans.offset < ml && left[ans.offset] == right[ans.offset]; ans.offset++ {
+type char_class int
+
+const (
+	WORD_CHAR char_class = iota
+	SPACE_CHAR
+	OTHER_CHAR
+)
+
+func class_of(ch rune) char_class {
+	switch {
+	case ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch):
+		return WORD_CHAR
+	case unicode.IsSpace(ch):
+		return SPACE_CHAR
+	}
+	return OTHER_CHAR
+}
+
+// split_words splits text into runs of word characters, runs of whitespace
+// and individual other characters, such as punctuation
+func split_words(text string) (ans []string) {
+	for len(text) > 0 {
+		ch, end := utf8.DecodeRuneInString(text)
+		if class := class_of(ch); class != OTHER_CHAR {
+			for end < len(text) {
+				ch, sz := utf8.DecodeRuneInString(text[end:])
+				if class_of(ch) != class {
+					break
+				}
+				end += sz
+			}
+		}
+		ans = append(ans, text[:end])
+		text = text[end:]
+	}
+	return
+}
+
+// differing_ranges calls f with each pair of ranges x[x_start:x_end] and
+// y[y_start:y_end] that differ, using the same anchored diff algorithm as Diff
+func differing_ranges(x, y []string, f func(x_start, x_end, y_start, y_end int)) {
+	var done pair
+	for _, m := range tgs(x, y) {
+		if m.x < done.x {
+			continue
+		}
+		start := m
+		for start.x > done.x && start.y > done.y && x[start.x-1] == y[start.y-1] {
+			start.x--
+			start.y--
+		}
+		end := m
+		for end.x < len(x) && end.y < len(y) && x[end.x] == y[end.y] {
+			end.x++
+			end.y++
+		}
+		if start.x > done.x || start.y > done.y {
+			f(done.x, start.x, done.y, start.y)
+		}
+		done = end
+	}
+}
+
+// changed_segments finds the words that differ between a pair of changed
+// lines, using the same anchored diff algorithm as Diff, on words instead of
+// lines
+func changed_segments(left, right string) (ans LineChanges) {
+	x, y := split_words(left), split_words(right)
+	offsets := func(words []string) []int {
+		ans := make([]int, len(words)+1)
+		for i, w := range words {
+			ans[i+1] = ans[i] + len(w)
 		}
-		suffix_count := 0
-		for ; suffix_count < ml && left[ll-1-suffix_count] == right[rl-1-suffix_count]; suffix_count++ {
+		return ans
+	}
+	xo, yo := offsets(x), offsets(y)
+	add := func(segments []Segment, offsets []int, start, end int) []Segment {
+		if end > start {
+			segments = append(segments, Segment{offsets[start], offsets[end] - offsets[start]})
 		}
-		ans.left_size = ll - suffix_count - ans.offset
-		ans.right_size = rl - suffix_count - ans.offset
+		return segments
 	}
+	differing_ranges(x, y, func(x_start, x_end, y_start, y_end int) {
+		ans.left = add(ans.left, xo, x_start, x_end)
+		ans.right = add(ans.right, yo, y_start, y_end)
+	})
 	return
 }
 
 func (self *Chunk) finalize(left_lines, right_lines []string) {
 	if !self.is_context && self.left_count == self.right_count {
 		for i := 0; i < self.left_count; i++ {
-			self.centers = append(self.centers, changed_center(left_lines[self.left_start+i], right_lines[self.right_start+i]))
+			self.changes = append(self.changes, changed_segments(left_lines[self.left_start+i], right_lines[self.right_start+i]))
 		}
 	}
 }
@@ -181,6 +361,8 @@ func (self *Hunk) finalize(left_lines, right_lines []string) error {
 type Patch struct {
 	all_hunks                                       []*Hunk
 	largest_line_number, added_count, removed_count int
+	// the removed and added lines (0 based) that were moved elsewhere
+	moved_left, moved_right map[int]bool
 }
 
 func (self *Patch) Len() int { return len(self.all_hunks) }
@@ -299,7 +481,7 @@ func run_diff(file1, file2 string, num_of_context_lines int) (ok, is_different b
 		if err != nil {
 			return false, false, "", err
 		}
-		patchb := Diff(path1, data1, path2, data2, num_of_context_lines)
+		patchb := Diff(path1, data1, path2, data2, num_of_context_lines, normalizer(conf.Ignore_whitespace), conf.Ignore_blank_lines)
 		if patchb == nil {
 			return true, false, "", nil
 		}
@@ -309,6 +491,13 @@ func run_diff(file1, file2 string, num_of_context_lines int) (ok, is_different b
 		cmd := utils.Map(func(x string) string {
 			return strings.ReplaceAll(x, "_CONTEXT_", context)
 		}, diff_cmd)
+		if flags := differ_flags(cmd); len(flags) > 0 {
+			idx := slices.Index(cmd, "--")
+			if idx < 0 {
+				idx = len(cmd)
+			}
+			cmd = slices.Insert(cmd, idx, flags...)
+		}
 
 		cmd = append(cmd, path1, path2)
 		c := exec.Command(cmd[0], cmd[1:]...)
@@ -348,6 +537,19 @@ func do_diff(file1, file2 string, context_count int) (ans *Patch, err error) {
 
 type diff_job struct{ file1, file2 string }
 
+func diff_jobs(collection *Collection) []diff_job {
+	jobs := make([]diff_job, 0, 32)
+	collection.Apply(func(path, typ, changed_path string) error {
+		if typ == "diff" {
+			if is_path_text(path) && is_path_text(changed_path) {
+				jobs = append(jobs, diff_job{path, changed_path})
+			}
+		}
+		return nil
+	})
+	return jobs
+}
+
 func diff(jobs []diff_job, context_count int) (ans map[string]*Patch, err error) {
 	ans = make(map[string]*Patch)
 	ctx := images.Context{}
@@ -372,5 +574,19 @@ func diff(jobs []diff_job, context_count int) (ans map[string]*Patch, err error)
 		}
 		ans[r.file1] = r.patch
 	}
+	if conf.Detect_moves {
+		patches := make([]patch_with_lines, 0, len(jobs))
+		for _, job := range jobs {
+			p := patch_with_lines{patch: ans[job.file1]}
+			if p.left_lines, err = lines_for_path(job.file1); err != nil {
+				return nil, err
+			}
+			if p.right_lines, err = lines_for_path(job.file2); err != nil {
+				return nil, err
+			}
+			patches = append(patches, p)
+		}
+		detect_moves(patches, normalizer(conf.Ignore_whitespace))
+	}
 	return ans, nil
 }
@@ -0,0 +1,290 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// The contents of one side of a file as far as they can be known from a
+// patch, lines not in any hunk are left empty
+type reconstructed_file struct {
+	lines             []string
+	no_newline_at_end bool
+}
+
+func (self *reconstructed_file) set_line(n int, text string) {
+	for len(self.lines) <= n {
+		self.lines = append(self.lines, "")
+	}
+	self.lines[n] = text
+}
+
+func (self *reconstructed_file) contents() []byte {
+	ans := strings.Join(self.lines, "\n")
+	if len(self.lines) > 0 && !self.no_newline_at_end {
+		ans += "\n"
+	}
+	return []byte(ans)
+}
+
+type patched_file struct {
+	left_name, right_name string // empty if the file does not exist on that side
+	left_blob, right_blob string // from the git index line, if any
+	left, right           reconstructed_file
+	prefix                string // the commit this change is from, if any
+	has_hunks, is_binary  bool
+	has_old_file_line     bool
+}
+
+func is_null_blob(x string) bool {
+	return strings.Trim(x, "0") == ""
+}
+
+// parse_patch_name parses the file name from the ---/+++ lines and the git
+// extended headers, returning an empty string for /dev/null
+func parse_patch_name(x string, strip_prefix bool) string {
+	if strings.HasPrefix(x, `"`) {
+		if q, err := strconv.QuotedPrefix(x); err == nil {
+			x, _ = strconv.Unquote(q)
+		}
+	} else if before, _, found := strings.Cut(x, "\t"); found {
+		x = before
+	}
+	x = strings.TrimRight(x, " ")
+	if x == "/dev/null" {
+		return ""
+	}
+	if strip_prefix {
+		if _, after, found := strings.Cut(x, "/"); found {
+			x = after
+		}
+	}
+	return x
+}
+
+func is_commit_line(line string) bool {
+	fields := strings.Fields(line)
+	if !strings.HasPrefix(line, "commit ") || len(fields) < 2 || len(fields[1]) < 7 {
+		return false
+	}
+	for _, ch := range fields[1] {
+		if !strings.ContainsRune("0123456789abcdef", ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// parse_patch_files parses the per-file changes from a patch in unified diff
+// format, such as the output of diff -u, git diff or git log -p
+func parse_patch_files(raw string) (ans []*patched_file) {
+	var current *patched_file
+	var last_line_type byte
+	prefix := ""
+	left_line, right_line, left_remaining, right_remaining := 0, 0, 0, 0
+	start_file := func() {
+		current = &patched_file{prefix: prefix}
+		ans = append(ans, current)
+	}
+	splitlines_like_git(raw, false, func(line string) {
+		if strings.HasPrefix(line, `\`) {
+			// No newline at end of file, applies to the previous line
+			if current != nil {
+				if last_line_type != '+' {
+					current.left.no_newline_at_end = true
+				}
+				if last_line_type != '-' {
+					current.right.no_newline_at_end = true
+				}
+			}
+			return
+		}
+		if left_remaining > 0 || right_remaining > 0 {
+			if line == "" {
+				// some tools strip the trailing space from empty context lines
+				line = " "
+			}
+			last_line_type = line[0]
+			switch line[0] {
+			case ' ':
+				current.left.set_line(left_line, line[1:])
+				current.right.set_line(right_line, line[1:])
+				left_line++
+				right_line++
+				left_remaining--
+				right_remaining--
+			case '-':
+				current.left.set_line(left_line, line[1:])
+				left_line++
+				left_remaining--
+			case '+':
+				current.right.set_line(right_line, line[1:])
+				right_line++
+				right_remaining--
+			default:
+				// a malformed hunk, ignore the rest of it
+				left_remaining, right_remaining = 0, 0
+			}
+			return
+		}
+		switch {
+		case is_commit_line(line):
+			prefix = strings.Fields(line)[1][:7]
+			current = nil
+		case strings.HasPrefix(line, "diff --git "):
+			start_file()
+			names := line[len("diff --git "):]
+			if strings.HasPrefix(names, "a/") {
+				if l, r, found := strings.Cut(names, " b/"); found {
+					current.left_name, current.right_name = l[2:], r
+				}
+			}
+		case strings.HasPrefix(line, "--- "):
+			if current == nil || current.has_hunks || current.is_binary || current.has_old_file_line {
+				start_file()
+			}
+			current.left_name = parse_patch_name(line[4:], true)
+			current.has_old_file_line = true
+		case current == nil:
+		case strings.HasPrefix(line, "+++ "):
+			current.right_name = parse_patch_name(line[4:], true)
+		case strings.HasPrefix(line, "@@ "):
+			h := parse_hunk_header(line)
+			left_line, right_line = utils.Max(0, h.left_start), utils.Max(0, h.right_start)
+			left_remaining, right_remaining = h.left_count, h.right_count
+			current.has_hunks = true
+		case current.has_hunks:
+		case strings.HasPrefix(line, "index "):
+			if blobs, _, _ := strings.Cut(line[len("index "):], " "); blobs != "" {
+				current.left_blob, current.right_blob, _ = strings.Cut(blobs, "..")
+			}
+		case strings.HasPrefix(line, "new file mode"):
+			current.left_name = ""
+		case strings.HasPrefix(line, "deleted file mode"):
+			current.right_name = ""
+		case strings.HasPrefix(line, "rename from "):
+			current.left_name = parse_patch_name(line[len("rename from "):], false)
+		case strings.HasPrefix(line, "rename to "):
+			current.right_name = parse_patch_name(line[len("rename to "):], false)
+		case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+			current.is_binary = true
+		}
+	})
+	return
+}
+
+// read_blobs reads the contents of the specified git blobs from the git
+// repository in the current directory, if any, ignoring missing blobs
+func read_blobs(hashes []string) map[string]string {
+	ans := make(map[string]string, len(hashes))
+	if len(hashes) == 0 {
+		return ans
+	}
+	out, err := git(strings.NewReader(strings.Join(hashes, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return ans
+	}
+	for _, hash := range hashes {
+		header, rest, _ := strings.Cut(out, "\n")
+		fields := strings.Fields(header)
+		out = rest
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size+1 > len(out) {
+			break
+		}
+		ans[hash] = out[:size]
+		out = out[size+1:]
+	}
+	return ans
+}
+
+// write_patched_files writes the contents of both sides of the files in the
+// patch into the specified directories. The full contents are used when they
+// are available from the git repository in the current directory, otherwise
+// they are reconstructed from the hunks.
+func write_patched_files(files []*patched_file, left_dir, right_dir string) (err error) {
+	hashes := make([]string, 0, 2*len(files))
+	for _, f := range files {
+		for _, h := range []string{f.left_blob, f.right_blob} {
+			if h != "" && !is_null_blob(h) {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	blobs := read_blobs(hashes)
+	contents := func(blob string, r *reconstructed_file, reconstructed bool) []byte {
+		if reconstructed {
+			return r.contents()
+		}
+		return []byte(blobs[blob])
+	}
+	seen := utils.NewSet[string](len(files))
+	for _, f := range files {
+		_, has_left := blobs[f.left_blob]
+		_, has_right := blobs[f.right_blob]
+		use_blobs := f.left_blob != "" && (has_left || f.left_name == "") && (has_right || f.right_name == "")
+		if f.is_binary && !use_blobs {
+			continue
+		}
+		left_name, right_name := f.right_name, f.right_name
+		if f.right_name == "" {
+			left_name = f.left_name
+		} else if !f.has_hunks && f.left_name != "" {
+			// a pure rename
+			left_name = f.left_name
+		}
+		left_name, right_name = path.Join(f.prefix, left_name), path.Join(f.prefix, right_name)
+		for n, l, r := 2, left_name, right_name; seen.Has(left_name) || seen.Has(right_name); n++ {
+			// the same file changed more than once in the patch
+			left_name, right_name = path.Join(strconv.Itoa(n), l), path.Join(strconv.Itoa(n), r)
+		}
+		seen.Add(left_name)
+		seen.Add(right_name)
+		if f.left_name != "" {
+			if err = write_file_in(left_dir, left_name, contents(f.left_blob, &f.left, !use_blobs)); err != nil {
+				return err
+			}
+		}
+		if f.right_name != "" {
+			if err = write_file_in(right_dir, right_name, contents(f.right_blob, &f.right, !use_blobs)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// get_patch_from_stdin reads a patch from STDIN and writes the contents of
+// both sides of the changed files into two temporary directories
+func get_patch_from_stdin() (left_dir, right_dir string, err error) {
+	raw, err := io.ReadAll(bufio.NewReader(os.Stdin))
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to read patch from STDIN with error: %w", err)
+	}
+	files := parse_patch_files(utils.UnsafeBytesToString(raw))
+	if len(files) == 0 {
+		return "", "", fmt.Errorf("No patch found on STDIN")
+	}
+	if left_dir, err = make_temp_dir("a"); err != nil {
+		return
+	}
+	if right_dir, err = make_temp_dir("b"); err != nil {
+		return
+	}
+	err = write_patched_files(files, left_dir, right_dir)
+	return
+}
@@ -0,0 +1,113 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestPatchFromStdin(t *testing.T) {
+	raw := `commit 0123456789abcdef0123456789abcdef01234567
+Author: Someone <someone@example.com>
+
+    commit message
+    --- not a file
+
+diff --git a/changed.txt b/changed.txt
+index 1111111..2222222 100644
+--- a/changed.txt
++++ b/changed.txt
+@@ -2,3 +2,3 @@ title
+ two
+-three
++THREE
+ four
+@@ -9,2 +9,3 @@
+ nine
+ ten
++eleven
+\ No newline at end of file
+diff --git a/added.txt b/added.txt
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/added.txt
+@@ -0,0 +1 @@
++new
+diff --git a/old.txt b/new.txt
+similarity index 100%
+rename from old.txt
+rename to new.txt
+diff --git a/image.png b/image.png
+index 4444444..5555555 100644
+Binary files a/image.png and b/image.png differ
+--- plain/one.txt	2023-01-01 00:00:00
++++ plain/one.txt	2023-01-02 00:00:00
+@@ -1 +1 @@
+-x
++y
+`
+	files := parse_patch_files(raw)
+	type file struct {
+		Left, Right, Left_blob, Right_blob, Prefix string
+		Left_lines, Right_lines                    []string
+		Has_hunks, Is_binary                       bool
+	}
+	actual := make([]file, len(files))
+	for i, f := range files {
+		actual[i] = file{f.left_name, f.right_name, f.left_blob, f.right_blob, f.prefix, f.left.lines, f.right.lines, f.has_hunks, f.is_binary}
+	}
+	expected := []file{
+		{"changed.txt", "changed.txt", "1111111", "2222222", "0123456",
+			[]string{"", "two", "three", "four", "", "", "", "", "nine", "ten"},
+			[]string{"", "two", "THREE", "four", "", "", "", "", "nine", "ten", "eleven"}, true, false},
+		{"", "added.txt", "0000000", "3333333", "0123456", nil, []string{"new"}, true, false},
+		{"old.txt", "new.txt", "", "", "0123456", nil, nil, false, false},
+		{"image.png", "image.png", "4444444", "5555555", "0123456", nil, nil, false, true},
+		{"one.txt", "one.txt", "", "", "0123456", []string{"x"}, []string{"y"}, true, false},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("Patch parsed incorrectly:\n%s", diff)
+	}
+	if files[0].left.no_newline_at_end || !files[0].right.no_newline_at_end {
+		t.Fatalf("No newline at end of file not detected")
+	}
+
+	tdir := t.TempDir()
+	left, right := filepath.Join(tdir, "left"), filepath.Join(tdir, "right")
+	// run outside any git repository so that the blobs are not found
+	cwd, _ := os.Getwd()
+	os.Chdir(tdir)
+	defer os.Chdir(cwd)
+	if err := write_patched_files(files, left, right); err != nil {
+		t.Fatal(err)
+	}
+	read := func(dir, name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, "0123456", name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+	for _, x := range []struct{ dir, name, expected string }{
+		{left, "changed.txt", "\ntwo\nthree\nfour\n\n\n\n\nnine\nten\n"},
+		{right, "changed.txt", "\ntwo\nTHREE\nfour\n\n\n\n\nnine\nten\neleven"},
+		{left, "added.txt", "<missing>"},
+		{right, "added.txt", "new\n"},
+		{left, "old.txt", ""},
+		{right, "new.txt", ""},
+		{left, "image.png", "<missing>"},
+		{right, "one.txt", "y\n"},
+	} {
+		if diff := cmp.Diff(x.expected, read(x.dir, x.name)); diff != "" {
+			t.Fatalf("Unexpected contents for %s in %s:\n%s", x.name, x.dir, diff)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestChangedSegments(t *testing.T) {
+	text := func(line string, segments []Segment) (ans []string) {
+		for _, s := range segments {
+			ans = append(ans, line[s.offset:s.offset+s.size])
+		}
+		return
+	}
+	for _, x := range []struct {
+		left, right                 string
+		left_changed, right_changed []string
+	}{
+		{"same", "same", nil, nil},
+		{"foo(a, b)", "bar(a, c)", []string{"foo", "b"}, []string{"bar", "c"}},
+		{"x := old_name + other_name", "x := new_name + other_name2", []string{"old_name", "other_name"}, []string{"new_name", "other_name2"}},
+		{"a b", "a b c", nil, []string{" c"}},
+		{"première ligne", "deuxième ligne", []string{"première"}, []string{"deuxième"}},
+		{"", "added", nil, []string{"added"}},
+	} {
+		c := changed_segments(x.left, x.right)
+		if diff := cmp.Diff(x.left_changed, text(x.left, c.left)); diff != "" {
+			t.Fatalf("Unexpected changes on the left for %#v -> %#v:\n%s", x.left, x.right, diff)
+		}
+		if diff := cmp.Diff(x.right_changed, text(x.right, c.right)); diff != "" {
+			t.Fatalf("Unexpected changes on the right for %#v -> %#v:\n%s", x.left, x.right, diff)
+		}
+	}
+}
+
+func TestDiffIgnoringWhitespace(t *testing.T) {
+	hunks := func(left, right string, ignore_whitespace Ignore_whitespace_Choice_Type, ignore_blank_lines bool) (ans []string) {
+		raw := Diff("a", left, "b", right, 0, normalizer(ignore_whitespace), ignore_blank_lines)
+		p, err := parse_patch(string(raw), strings.Split(left, "\n"), strings.Split(right, "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range p.all_hunks {
+			ans = append(ans, fmt.Sprintf("-%d,%d +%d,%d", h.left_start+1, h.left_count, h.right_start+1, h.right_count))
+		}
+		return
+	}
+	for _, x := range []struct {
+		left, right        string
+		ignore_whitespace  Ignore_whitespace_Choice_Type
+		ignore_blank_lines bool
+		expected           []string
+	}{
+		{"a\nb  \nc\n", "a\nb\nc\n", Ignore_whitespace_none, false, []string{"-2,1 +2,1"}},
+		{"a\nb  \nc\n", "a\nb\nc\n", Ignore_whitespace_eol, false, nil},
+		{"a\nb  c\nd\n", "a\nb c\nd\n", Ignore_whitespace_eol, false, []string{"-2,1 +2,1"}},
+		{"a\nb  c\nd\n", "a\nb c\nd\n", Ignore_whitespace_change, false, nil},
+		{"a\nbc\nd\n", "a\nb c\nd\n", Ignore_whitespace_change, false, []string{"-2,1 +2,1"}},
+		{"a\nbc\nd\n", "a\nb c\nd\n", Ignore_whitespace_all, false, nil},
+		{"a\nb\nc\n", "a\n\nb\nc\n", Ignore_whitespace_none, true, nil},
+		{"a\nb\nc\n", "a\n\nb\nC\n", Ignore_whitespace_none, true, []string{"-3,1 +4,1"}},
+	} {
+		if diff := cmp.Diff(x.expected, hunks(x.left, x.right, x.ignore_whitespace, x.ignore_blank_lines)); diff != "" {
+			t.Fatalf("Incorrect hunks for %#v -> %#v with whitespace: %s blank lines: %v\n%s", x.left, x.right, x.ignore_whitespace, x.ignore_blank_lines, diff)
+		}
+	}
+}
+
+func TestDetectMoves(t *testing.T) {
+	// the re-indented block is moved, the line x is moved too but is too short to count
+	left := []string{"x", "k1", "func one() {", "    return first_long_value", "}", "k2", "k3", "k4", "end"}
+	right := []string{"k1", "k2", "x", "k3", "k4", "func one() {", "return first_long_value", "}", "end"}
+	raw := Diff("a", strings.Join(left, "\n")+"\n", "b", strings.Join(right, "\n")+"\n", 0, nil, false)
+	p, err := parse_patch(string(raw), left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detect_moves([]patch_with_lines{{p, left, right}}, nil)
+	if diff := cmp.Diff(map[int]bool{2: true, 3: true, 4: true}, p.moved_left); diff != "" {
+		t.Fatalf("Incorrect moved lines on the left:\n%s", diff)
+	}
+	if diff := cmp.Diff(map[int]bool{5: true, 6: true, 7: true}, p.moved_right); diff != "" {
+		t.Fatalf("Incorrect moved lines on the right:\n%s", diff)
+	}
+}
+
+func TestDifferFlags(t *testing.T) {
+	orig_conf := conf
+	conf = NewConfig()
+	defer func() { conf = orig_conf }()
+	conf.Ignore_whitespace, conf.Ignore_blank_lines = Ignore_whitespace_change, true
+	if diff := cmp.Diff([]string{"--ignore-space-change", "--ignore-blank-lines"}, differ_flags([]string{"git", "diff"})); diff != "" {
+		t.Fatalf("Incorrect flags for git:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"-b", "-B"}, differ_flags([]string{"diff", "-p"})); diff != "" {
+		t.Fatalf("Incorrect flags for diff:\n%s", diff)
+	}
+	if flags := differ_flags([]string{"mydiff"}); len(flags) > 0 {
+		t.Fatalf("Flags added to a custom diff command: %#v", flags)
+	}
+	for text, expected := range map[string]bool{
+		"diff (GNU diffutils) 3.8\nCopyright": true,
+		"diff (GNU diffutils) 3.4":            true,
+		"diff (GNU diffutils) 3.3":            false,
+		"diff (GNU diffutils) 2.8.1":          false,
+		"Apple diff (based on FreeBSD diff)":  false,
+	} {
+		if actual := is_gnu_diff_with_ignore_trailing_space(text); actual != expected {
+			t.Fatalf("Incorrect support for --ignore-trailing-space detected in %#v: %v", text, actual)
+		}
+	}
+}
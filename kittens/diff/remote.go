@@ -0,0 +1,166 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"kitty/kittens/ssh"
+	"kitty/tools/utils"
+	"kitty/tools/utils/shlex"
+)
+
+var _ = fmt.Print
+
+// The commands used to fetch remote files, keyed by the prefix used to
+// specify them, as in prefix:host:path. The placeholders in the commands are:
+// {host} the host, container, pod, etc., {path} the remote path, {dir} and
+// {name} the parent directory and name of the remote path and {dest} a local
+// directory. Commands that use {dest} must copy the remote path into it, all
+// other commands must write a tar archive of {name} relative to {dir} to
+// STDOUT.
+var builtin_remote_sources = map[string]string{
+	"docker":  "docker cp {host}:{path} -",
+	"kubectl": "kubectl exec -i {host} -- tar -c -f - -C {dir} {name}",
+	"sftp":    "sftp -q -r {host}:{path} {dest}",
+}
+
+// fetched_remote_paths caches the local copies of remote paths, so that each
+// is fetched only once
+var fetched_remote_paths = map[string]string{}
+
+func remote_source_command(scheme string) (cmd []string, err error) {
+	// sources defined in diff.conf override the builtin ones
+	var templates []string
+	if conf != nil {
+		templates = conf.Remote_source
+	}
+	for i := len(templates) - 1; i >= 0; i-- {
+		name, template, _ := strings.Cut(strings.TrimSpace(templates[i]), " ")
+		if name == scheme {
+			if cmd, err = shlex.Split(strings.TrimSpace(template)); err == nil && len(cmd) == 0 {
+				err = fmt.Errorf("The command for the remote source %s is empty", scheme)
+			}
+			return
+		}
+	}
+	if scheme == "ssh" {
+		return []string{ssh.SSHExe(), "{host}", "tar", "-c", "-f", "-", "-C", "{dir}", "{name}"}, nil
+	}
+	if template, found := builtin_remote_sources[scheme]; found {
+		return shlex.Split(template)
+	}
+	return nil, nil
+}
+
+// parse_remote_path splits a path of the form scheme:host:path, returning
+// nil cmd if it is not a remote path
+func parse_remote_path(spec string) (cmd []string, scheme, host, rpath string, err error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return
+	}
+	if cmd, err = remote_source_command(parts[0]); err != nil || cmd == nil {
+		return
+	}
+	return cmd, parts[0], parts[1], parts[2], nil
+}
+
+func is_remote_path(spec string) bool {
+	if exists(spec) {
+		return false
+	}
+	cmd, _, _, _, err := parse_remote_path(spec)
+	return cmd != nil || err != nil
+}
+
+func expand_remote_command(cmd []string, replacements map[string]string) []string {
+	return utils.Map(func(x string) string {
+		for k, v := range replacements {
+			x = strings.ReplaceAll(x, k, v)
+		}
+		return x
+	}, cmd)
+}
+
+// fetch_remote_path copies the remote path into a temporary directory,
+// returning the path of the local copy
+func fetch_remote_path(cmd []string, scheme, host, rpath string) (string, error) {
+	tdir, err := os.MkdirTemp("", "kitty-diff-remote-*")
+	if err != nil {
+		return "", err
+	}
+	label := scheme + ":" + host
+	if scheme == "ssh" {
+		label = host
+	}
+	add_remote_dir(tdir, label)
+	rpath = path.Clean(rpath)
+	dir, name := path.Split(rpath)
+	dir = path.Clean(dir)
+	if dir == "" {
+		dir = "."
+	}
+	// the local copy mirrors the remote path, except for the leading /, so
+	// that the names shown are host:rpath
+	local_dir := filepath.Join(tdir, filepath.FromSlash(strings.TrimLeft(dir, "/")))
+	if err = os.MkdirAll(local_dir, 0o700); err != nil {
+		return "", err
+	}
+	downloads := false
+	for _, x := range cmd {
+		if strings.Contains(x, "{dest}") {
+			downloads = true
+			break
+		}
+	}
+	cmd = expand_remote_command(cmd, map[string]string{"{host}": host, "{path}": rpath, "{dir}": dir, "{name}": name, "{dest}": local_dir})
+	c := exec.Command(cmd[0], cmd[1:]...)
+	// allow the command to ask for passwords, etc.
+	c.Stdin, c.Stderr = os.Stdin, os.Stderr
+	if downloads {
+		err = c.Run()
+	} else {
+		var stdout []byte
+		if stdout, err = c.Output(); err == nil {
+			if _, err = utils.ExtractAllFromTar(tar.NewReader(bytes.NewReader(stdout)), local_dir); err != nil {
+				return "", fmt.Errorf("Failed to untar data from %s:%s to get %s with error: %w", scheme, host, rpath, err)
+			}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to get %s from %s:%s by running %s with error: %w", rpath, scheme, host, strings.Join(cmd, " "), err)
+	}
+	ans := filepath.Join(local_dir, name)
+	if !exists(ans) {
+		return "", fmt.Errorf("Getting %s from %s:%s did not create the file %s", rpath, scheme, host, ans)
+	}
+	return ans, nil
+}
+
+// get_remote_file returns the path to a local copy of a remote path, or the
+// path itself if it is not a remote path
+func get_remote_file(spec string) (string, error) {
+	if exists(spec) {
+		return spec, nil
+	}
+	cmd, scheme, host, rpath, err := parse_remote_path(spec)
+	if err != nil || cmd == nil {
+		return spec, err
+	}
+	if ans, found := fetched_remote_paths[spec]; found {
+		return ans, nil
+	}
+	ans, err := fetch_remote_path(cmd, scheme, host, rpath)
+	if err == nil {
+		fetched_remote_paths[spec] = ans
+	}
+	return ans, err
+}
@@ -0,0 +1,77 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestRemoteSources(t *testing.T) {
+	orig_conf := conf
+	conf = NewConfig()
+	init_caches()
+	defer func() {
+		for tdir := range remote_dirs {
+			os.RemoveAll(tdir)
+		}
+		conf = orig_conf
+		fetched_remote_paths = map[string]string{}
+	}()
+	conf.Remote_source = []string{"tarred tar -c -f - -C {dir} {name}", "copied cp -r {path} {dest}", "docker echo {host} {path}"}
+
+	for spec, expected := range map[string][]string{
+		"docker:c:/a/b":     {"echo", "{host}", "{path}"},
+		"kubectl:pod:x:y":   {"kubectl", "exec", "-i", "{host}", "--", "tar", "-c", "-f", "-", "-C", "{dir}", "{name}"},
+		"ssh:host:/path":    {"", "{host}", "tar", "-c", "-f", "-", "-C", "{dir}", "{name}"},
+		"unknown:host:path": nil,
+		"docker:c":          nil,
+		"docker::path":      nil,
+	} {
+		cmd, _, _, _, err := parse_remote_path(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cmd) > 0 && len(expected) > 0 && expected[0] == "" {
+			expected[0] = cmd[0]
+		}
+		if diff := cmp.Diff(expected, cmd); diff != "" {
+			t.Fatalf("Incorrect command for %s:\n%s", spec, diff)
+		}
+	}
+
+	tdir := t.TempDir()
+	src := filepath.Join(tdir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0o700)
+	os.WriteFile(filepath.Join(src, "sub", "f"), []byte("xyz"), 0o600)
+	schemes := []string{"copied"}
+	if _, err := exec.LookPath("tar"); err == nil {
+		schemes = append(schemes, "tarred")
+	}
+	for _, scheme := range schemes {
+		spec := scheme + ":host:" + src
+		local, err := get_remote_file(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(filepath.Join(local, "sub", "f")); err != nil || string(data) != "xyz" {
+			t.Fatalf("Fetching %s failed: %#v %v", spec, string(data), err)
+		}
+		if again, _ := get_remote_file(spec); again != local {
+			t.Fatalf("Fetching %s was not cached", spec)
+		}
+		if name := resolve_remote_name(filepath.Join(local, "sub", "f"), ""); name != scheme+":host:"+filepath.Join(src, "sub", "f")[1:] {
+			t.Fatalf("Incorrect name for fetched file: %s", name)
+		}
+	}
+	if _, err := get_remote_file("copied:host:" + filepath.Join(tdir, "missing")); err == nil {
+		t.Fatalf("Fetching a missing file did not fail")
+	}
+}
@@ -39,6 +39,7 @@ type HalfScreenLine struct {
 	marked_up_margin_text string
 	marked_up_text        string
 	is_filler             bool
+	is_moved              bool // a removed or added line that was moved elsewhere
 	cached_wcswidth       int
 }
 
@@ -58,6 +59,7 @@ type LogicalLine struct {
 	screen_lines                    []*ScreenLine
 	is_full_width                   bool
 	is_change_start                 bool
+	is_addition                     bool // full width change lines are shown as removals unless this is set
 	left_reference, right_reference Reference
 	left_image, right_image         struct {
 		key   string
@@ -66,10 +68,9 @@ type LogicalLine struct {
 	image_lines_offset int
 }
 
-func (self *LogicalLine) render_screen_line(n int, lp *loop.Loop, margin_size, columns int) {
-	if n >= len(self.screen_lines) || n < 0 {
-		return
-	}
+// formatted_screen_line returns the nth screen line of this line as the
+// formatted text for its left and right halves
+func (self *LogicalLine) formatted_screen_line(n int, margin_size, columns int) (left, right string) {
 	sl := self.screen_lines[n]
 	available_cols := columns/2 - margin_size
 	if self.is_full_width {
@@ -83,8 +84,9 @@ func (self *LogicalLine) render_screen_line(n int, lp *loop.Loop, margin_size, c
 	} else {
 		switch self.line_type {
 		case CHANGE_LINE, IMAGE_LINE:
-			left_margin = format_as_sgr.removed_margin + left_margin
-			left_text = format_as_sgr.removed + left_text
+			margin_fmt, text_fmt := change_formats(self.is_addition, sl.left.is_moved)
+			left_margin = margin_fmt + left_margin
+			left_text = text_fmt + left_text
 		case HUNK_TITLE_LINE:
 			left_margin = format_as_sgr.hunk_margin + left_margin
 			left_text = format_as_sgr.hunk + left_text
@@ -93,8 +95,7 @@ func (self *LogicalLine) render_screen_line(n int, lp *loop.Loop, margin_size, c
 			left_margin = format_as_sgr.margin + left_margin
 		}
 	}
-	lp.QueueWriteString(left_margin + "\x1b[m")
-	lp.QueueWriteString(left_text)
+	left = left_margin + "\x1b[m" + left_text
 	if self.is_full_width {
 		return
 	}
@@ -106,8 +107,9 @@ func (self *LogicalLine) render_screen_line(n int, lp *loop.Loop, margin_size, c
 	} else {
 		switch self.line_type {
 		case CHANGE_LINE, IMAGE_LINE:
-			right_margin = format_as_sgr.added_margin + right_margin
-			right_text = format_as_sgr.added + right_text
+			margin_fmt, text_fmt := change_formats(true, sl.right.is_moved)
+			right_margin = margin_fmt + right_margin
+			right_text = text_fmt + right_text
 		case HUNK_TITLE_LINE:
 			right_margin = format_as_sgr.hunk_margin + right_margin
 			right_text = format_as_sgr.hunk + right_text
@@ -116,10 +118,34 @@ func (self *LogicalLine) render_screen_line(n int, lp *loop.Loop, margin_size, c
 			right_margin = format_as_sgr.margin + right_margin
 		}
 	}
+	right = right_margin + "\x1b[m" + right_text
+	return
+}
+
+func change_formats(is_addition, is_moved bool) (margin, text string) {
+	switch {
+	case is_addition && is_moved:
+		return format_as_sgr.moved_to_margin, format_as_sgr.moved_to
+	case is_addition:
+		return format_as_sgr.added_margin, format_as_sgr.added
+	case is_moved:
+		return format_as_sgr.moved_from_margin, format_as_sgr.moved_from
+	}
+	return format_as_sgr.removed_margin, format_as_sgr.removed
+}
+
+func (self *LogicalLine) render_screen_line(n int, lp *loop.Loop, margin_size, columns int) {
+	if n >= len(self.screen_lines) || n < 0 {
+		return
+	}
+	left, right := self.formatted_screen_line(n, margin_size, columns)
+	lp.QueueWriteString(left)
+	if self.is_full_width {
+		return
+	}
 	lp.QueueWriteString("\x1b[m\r")
-	lp.MoveCursorHorizontally(available_cols + margin_size)
-	lp.QueueWriteString(right_margin + "\x1b[m")
-	lp.QueueWriteString(right_text)
+	lp.MoveCursorHorizontally(columns / 2)
+	lp.QueueWriteString(right)
 }
 
 func (self *LogicalLine) IncrementScrollPosBy(pos *ScrollPos, amt int) (delta int) {
@@ -156,6 +182,7 @@ func place_in(text string, sz int) string {
 
 var format_as_sgr struct {
 	title, margin, added, removed, added_margin, removed_margin, filler, margin_filler, hunk_margin, hunk, selection, search string
+	moved_from, moved_from_margin, moved_to, moved_to_margin                                                                 string
 }
 
 var statusline_format, added_count_format, removed_count_format, message_format, selection_format func(...any) string
@@ -177,6 +204,10 @@ func create_formatters() {
 	format_as_sgr.added_margin = only_open(fmt.Sprintf("fg=%s bg=%s", conf.Margin_fg.AsRGBSharp(), conf.Added_margin_bg.AsRGBSharp()))
 	format_as_sgr.removed = only_open("bg=" + conf.Removed_bg.AsRGBSharp())
 	format_as_sgr.removed_margin = only_open(fmt.Sprintf("fg=%s bg=%s", conf.Margin_fg.AsRGBSharp(), conf.Removed_margin_bg.AsRGBSharp()))
+	format_as_sgr.moved_from = only_open("bg=" + conf.Moved_from_bg.AsRGBSharp())
+	format_as_sgr.moved_from_margin = only_open(fmt.Sprintf("fg=%s bg=%s", conf.Margin_fg.AsRGBSharp(), conf.Moved_from_margin_bg.AsRGBSharp()))
+	format_as_sgr.moved_to = only_open("bg=" + conf.Moved_to_bg.AsRGBSharp())
+	format_as_sgr.moved_to_margin = only_open(fmt.Sprintf("fg=%s bg=%s", conf.Margin_fg.AsRGBSharp(), conf.Moved_to_margin_bg.AsRGBSharp()))
 	format_as_sgr.title = only_open(fmt.Sprintf("fg=%s bg=%s bold", conf.Title_fg.AsRGBSharp(), conf.Title_bg.AsRGBSharp()))
 	format_as_sgr.margin = only_open(fmt.Sprintf("fg=%s bg=%s", conf.Margin_fg.AsRGBSharp(), conf.Margin_bg.AsRGBSharp()))
 	format_as_sgr.hunk = only_open(fmt.Sprintf("fg=%s bg=%s", conf.Margin_fg.AsRGBSharp(), conf.Hunk_bg.AsRGBSharp()))
@@ -193,7 +224,7 @@ func create_formatters() {
 	}
 }
 
-func center_span(ltype string, offset, size int) *sgr.Span {
+func changed_span(ltype string, offset, size int) *sgr.Span {
 	ans := sgr.NewSpan(offset, size)
 	switch ltype {
 	case "add":
@@ -233,6 +264,7 @@ func title_lines(left_path, right_path string, columns, margin_size int, ans []*
 type LogicalLines struct {
 	lines                []*LogicalLine
 	margin_size, columns int
+	unified              bool
 }
 
 func (self *LogicalLines) At(i int) *LogicalLine { return self.lines[i] }
@@ -342,6 +374,11 @@ func image_lines(left_path, right_path string, screen_size screen_size, margin_s
 		if res.Width > -1 {
 			text = fmt.Sprintf("Dimensions: %dx%d %s", res.Width, res.Height, text)
 		}
+		if path == right_path && left_path != "" {
+			if desc := image_display_description(left_path); desc != "" {
+				text += " " + desc
+			}
+		}
 		return text, nil
 	})
 
@@ -368,9 +405,13 @@ func image_lines(left_path, right_path string, screen_size screen_size, margin_s
 	if ll.left_image.count = len(left_lines); ll.left_image.count > 0 {
 		ll.left_image.key = left_path
 	}
-	right_lines := do_side(right_path)
+	right_key := ""
+	if right_path != "" {
+		right_key = right_image_key(left_path, right_path)
+	}
+	right_lines := do_side(right_key)
 	if ll.right_image.count = len(right_lines); ll.right_image.count > 0 {
-		ll.right_image.key = right_path
+		ll.right_image.key = right_key
 	}
 	for i := 0; i < utils.Max(len(left_lines), len(right_lines)); i++ {
 		sl := ScreenLine{}
@@ -462,8 +503,10 @@ func binary_lines(left_path, right_path string, columns, margin_size int, ans []
 type DiffData struct {
 	left_path, right_path       string
 	available_cols, margin_size int
+	unified                     bool
 
 	left_lines, right_lines []string
+	moved_left, moved_right map[int]bool
 }
 
 func hunk_title(hunk *Hunk) string {
@@ -498,19 +541,32 @@ func splitlines(text string, width int) []string {
 	return style.WrapTextAsLines(text, width, style.WrapOptions{})
 }
 
-func render_half_line(line_number int, line, ltype string, available_cols int, center Center, ans []HalfScreenLine) []HalfScreenLine {
-	size := center.left_size
-	if ltype != "remove" {
-		size = center.right_size
-	}
-	if size > 0 {
-		span := center_span(ltype, center.offset, size)
-		line = sgr.InsertFormatting(line, span)
+func render_half_line(margin, line, ltype string, available_cols int, changes []Segment, ans []HalfScreenLine) []HalfScreenLine {
+	if len(changes) > 0 {
+		spans := make([]*sgr.Span, len(changes))
+		for i, c := range changes {
+			spans[i] = changed_span(ltype, c.offset, c.size)
+		}
+		line = sgr.InsertFormatting(line, spans...)
 	}
-	lnum := strconv.Itoa(line_number + 1)
 	for _, sc := range splitlines(line, available_cols) {
-		ans = append(ans, HalfScreenLine{marked_up_margin_text: lnum, marked_up_text: sc})
-		lnum = ""
+		ans = append(ans, HalfScreenLine{marked_up_margin_text: margin, marked_up_text: sc})
+		margin = ""
+	}
+	return ans
+}
+
+// render_changed_half_line renders a removed or added line, lines that were
+// moved elsewhere are marked as such instead of having their changed words
+// highlighted
+func render_changed_half_line(margin, line, ltype string, available_cols int, changes []Segment, is_moved bool, ans []HalfScreenLine) []HalfScreenLine {
+	if !is_moved {
+		return render_half_line(margin, line, ltype, available_cols, changes, ans)
+	}
+	n := len(ans)
+	ans = render_half_line(margin, line, ltype, available_cols, nil, ans)
+	for i := n; i < len(ans); i++ {
+		ans[i].is_moved = true
 	}
 	return ans
 }
@@ -520,20 +576,20 @@ func lines_for_diff_chunk(data *DiffData, hunk_num int, chunk *Chunk, chunk_num
 	ll, rl := make([]HalfScreenLine, 0, 32), make([]HalfScreenLine, 0, 32)
 	for i := 0; i < utils.Max(chunk.left_count, chunk.right_count); i++ {
 		ll, rl = ll[:0], rl[:0]
-		var center Center
+		var changes LineChanges
 		left_lnum, right_lnum := 0, 0
-		if i < len(chunk.centers) {
-			center = chunk.centers[i]
+		if i < len(chunk.changes) {
+			changes = chunk.changes[i]
 		}
 		if i < chunk.left_count {
 			left_lnum = chunk.left_start + i
-			ll = render_half_line(left_lnum, data.left_lines[left_lnum], "remove", data.available_cols, center, ll)
+			ll = render_changed_half_line(strconv.Itoa(left_lnum+1), data.left_lines[left_lnum], "remove", data.available_cols, changes.left, data.moved_left[left_lnum], ll)
 			left_lnum++
 		}
 
 		if i < chunk.right_count {
 			right_lnum = chunk.right_start + i
-			rl = render_half_line(right_lnum, data.right_lines[right_lnum], "add", data.available_cols, center, rl)
+			rl = render_changed_half_line(strconv.Itoa(right_lnum+1), data.right_lines[right_lnum], "add", data.available_cols, changes.right, data.moved_right[right_lnum], rl)
 			right_lnum++
 		}
 
@@ -554,7 +610,7 @@ func lines_for_diff_chunk(data *DiffData, hunk_num int, chunk *Chunk, chunk_num
 		logline := LogicalLine{
 			line_type: CHANGE_LINE, is_change_start: i == 0,
 			left_reference:  Reference{path: data.left_path, linenum: left_lnum},
-			right_reference: Reference{path: data.left_path, linenum: right_lnum},
+			right_reference: Reference{path: data.right_path, linenum: right_lnum},
 		}
 		for l := 0; l < len(ll); l++ {
 			logline.screen_lines = append(logline.screen_lines, &ScreenLine{left: ll[l], right: rl[l]})
@@ -564,7 +620,7 @@ func lines_for_diff_chunk(data *DiffData, hunk_num int, chunk *Chunk, chunk_num
 	return ans
 }
 
-func lines_for_diff(left_path string, right_path string, patch *Patch, columns, margin_size int, ans []*LogicalLine) (result []*LogicalLine, err error) {
+func lines_for_diff(left_path string, right_path string, patch *Patch, columns, margin_size int, unified bool, ans []*LogicalLine) (result []*LogicalLine, err error) {
 	ht := LogicalLine{
 		line_type:      HUNK_TITLE_LINE,
 		left_reference: Reference{path: left_path}, right_reference: Reference{path: right_path},
@@ -581,7 +637,13 @@ func lines_for_diff(left_path string, right_path string, patch *Patch, columns,
 		return append(ans, &ht), nil
 	}
 	available_cols := columns/2 - margin_size
-	data := DiffData{left_path: left_path, right_path: right_path, available_cols: available_cols, margin_size: margin_size}
+	if unified {
+		available_cols = columns - margin_size
+	}
+	data := DiffData{
+		left_path: left_path, right_path: right_path, available_cols: available_cols, margin_size: margin_size, unified: unified,
+		moved_left: patch.moved_left, moved_right: patch.moved_right,
+	}
 	if left_path != "" {
 		data.left_lines, err = highlighted_lines_for_path(left_path)
 		if err != nil {
@@ -606,9 +668,14 @@ func lines_for_diff(left_path string, right_path string, patch *Patch, columns,
 		}
 		ans = append(ans, &htl)
 		for cnum, chunk := range hunk.chunks {
-			if chunk.is_context {
+			switch {
+			case chunk.is_context && data.unified:
+				ans = unified_lines_for_context_chunk(&data, chunk, ans)
+			case chunk.is_context:
 				ans = lines_for_context_chunk(&data, hunk_num, chunk, cnum, ans)
-			} else {
+			case data.unified:
+				ans = unified_lines_for_diff_chunk(&data, chunk, ans)
+			default:
 				ans = lines_for_diff_chunk(&data, hunk_num, chunk, cnum, ans)
 			}
 		}
@@ -638,7 +705,7 @@ func all_lines(path string, columns, margin_size int, is_add bool, ans []*Logica
 	}
 	for line_number, line := range lines {
 		hlines := make([]HalfScreenLine, 0, 8)
-		hlines = render_half_line(line_number, line, ltype, available_cols, Center{}, hlines)
+		hlines = render_half_line(strconv.Itoa(line_number+1), line, ltype, available_cols, nil, hlines)
 		l := ll
 		if is_add {
 			l.right_reference.linenum = line_number + 1
@@ -686,12 +753,19 @@ func rename_lines(path, other_path string, columns, margin_size int, ans []*Logi
 	return append(ans, &ll), nil
 }
 
-func render(collection *Collection, diff_map map[string]*Patch, screen_size screen_size, largest_line_number int, image_size graphics.Size) (result *LogicalLines, err error) {
+func render(collection *Collection, diff_map map[string]*Patch, screen_size screen_size, largest_line_number int, image_size graphics.Size, unified bool) (result *LogicalLines, err error) {
 	margin_size := utils.Max(3, len(strconv.Itoa(largest_line_number))+1)
+	if unified {
+		margin_size = unified_margin_size(largest_line_number)
+	}
 	ans := make([]*LogicalLine, 0, 1024)
 	columns := screen_size.columns
 	err = collection.Apply(func(path, item_type, changed_path string) error {
-		ans = title_lines(path, changed_path, columns, margin_size, ans)
+		if unified {
+			ans = unified_title_lines(path, changed_path, columns, margin_size, ans)
+		} else {
+			ans = title_lines(path, changed_path, columns, margin_size, ans)
+		}
 		defer func() {
 			ans = append(ans, &LogicalLine{line_type: EMPTY_LINE, screen_lines: []*ScreenLine{{}}})
 		}()
@@ -707,11 +781,13 @@ func render(collection *Collection, diff_map map[string]*Patch, screen_size scre
 			if is_binary {
 				if is_img {
 					ans, err = image_lines(path, changed_path, screen_size, margin_size, image_size, ans)
+				} else if unified {
+					ans, err = unified_binary_lines(path, changed_path, columns, margin_size, ans)
 				} else {
 					ans, err = binary_lines(path, changed_path, columns, margin_size, ans)
 				}
 			} else {
-				ans, err = lines_for_diff(path, changed_path, diff_map[path], columns, margin_size, ans)
+				ans, err = lines_for_diff(path, changed_path, diff_map[path], columns, margin_size, unified, ans)
 			}
 			if err != nil {
 				return err
@@ -720,9 +796,13 @@ func render(collection *Collection, diff_map map[string]*Patch, screen_size scre
 			if is_binary {
 				if is_img {
 					ans, err = image_lines("", path, screen_size, margin_size, image_size, ans)
+				} else if unified {
+					ans, err = unified_binary_lines("", path, columns, margin_size, ans)
 				} else {
 					ans, err = binary_lines("", path, columns, margin_size, ans)
 				}
+			} else if unified {
+				ans, err = unified_all_lines(path, columns, margin_size, true, ans)
 			} else {
 				ans, err = all_lines(path, columns, margin_size, true, ans)
 			}
@@ -733,9 +813,13 @@ func render(collection *Collection, diff_map map[string]*Patch, screen_size scre
 			if is_binary {
 				if is_img {
 					ans, err = image_lines(path, "", screen_size, margin_size, image_size, ans)
+				} else if unified {
+					ans, err = unified_binary_lines(path, "", columns, margin_size, ans)
 				} else {
 					ans, err = binary_lines(path, "", columns, margin_size, ans)
 				}
+			} else if unified {
+				ans, err = unified_all_lines(path, columns, margin_size, false, ans)
 			} else {
 				ans, err = all_lines(path, columns, margin_size, false, ans)
 			}
@@ -752,7 +836,22 @@ func render(collection *Collection, diff_map map[string]*Patch, screen_size scre
 		}
 		return nil
 	})
-	return &LogicalLines{lines: ans[:len(ans)-1], margin_size: margin_size, columns: columns}, err
+	return &LogicalLines{lines: ans[:len(ans)-1], margin_size: margin_size, columns: columns, unified: unified}, err
+}
+
+// index_of_line_for returns the index of the first line showing the same
+// source line as ll, which is from a different rendering of the diff
+func (self *LogicalLines) index_of_line_for(ll *LogicalLine) int {
+	use_left := ll.left_reference.linenum > 0 || ll.right_reference.linenum == 0
+	for i, q := range self.lines {
+		if q.line_type != ll.line_type {
+			continue
+		}
+		if (use_left && q.left_reference == ll.left_reference) || (!use_left && q.right_reference == ll.right_reference) {
+			return i
+		}
+	}
+	return 0
 }
 
 func (self *LogicalLines) num_of_screen_lines() (ans int) {
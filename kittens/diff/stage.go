@@ -0,0 +1,218 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"kitty/tools/utils"
+
+	"golang.org/x/exp/slices"
+)
+
+var _ = fmt.Print
+
+// raw_lines_for_path returns the unmodified lines of the file, unlike
+// lines_for_path which sanitizes them for display
+func raw_lines_for_path(path string) (lines []string, no_newline_at_end bool, err error) {
+	data, err := data_for_path(path)
+	if err != nil || data == "" {
+		return nil, false, err
+	}
+	lines = strings.Split(data, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		no_newline_at_end = true
+	}
+	return
+}
+
+func quote_patch_name(name string) string {
+	if strings.ContainsAny(name, "\"\\\t\n") {
+		return strconv.Quote(name)
+	}
+	return name
+}
+
+// hunk_as_patch returns a patch in the format accepted by git apply that
+// contains only the specified hunk
+func hunk_as_patch(name string, left, right []string, left_no_newline_at_end, right_no_newline_at_end bool, hunk *Hunk) string {
+	ans := strings.Builder{}
+	a, b := quote_patch_name("a/"+name), quote_patch_name("b/"+name)
+	fmt.Fprintf(&ans, "diff --git %s %s\n--- %s\n+++ %s\n", a, b, a, b)
+	fmt.Fprintf(&ans, "@@ -%d,%d +%d,%d @@\n", hunk.left_start+1, hunk.left_count, hunk.right_start+1, hunk.right_count)
+	w := func(prefix string, lines []string, idx int, no_newline_at_end bool) {
+		ans.WriteString(prefix)
+		ans.WriteString(lines[idx])
+		ans.WriteString("\n")
+		if no_newline_at_end && idx == len(lines)-1 {
+			ans.WriteString("\\ No newline at end of file\n")
+		}
+	}
+	for _, chunk := range hunk.chunks {
+		if chunk.is_context {
+			for i := chunk.left_start; i < chunk.left_start+chunk.left_count; i++ {
+				w(" ", left, i, left_no_newline_at_end)
+			}
+			continue
+		}
+		for i := chunk.left_start; i < chunk.left_start+chunk.left_count; i++ {
+			w("-", left, i, left_no_newline_at_end)
+		}
+		for i := chunk.right_start; i < chunk.right_start+chunk.right_count; i++ {
+			w("+", right, i, right_no_newline_at_end)
+		}
+	}
+	return ans.String()
+}
+
+// current_hunk_title returns the title line of the hunk at the top of the
+// screen, or the first hunk below it in the same file
+func (self *Handler) current_hunk_title() *LogicalLine {
+backwards:
+	for i := utils.Min(self.scroll_pos.logical_line, self.logical_lines.Len()-1); i >= 0; i-- {
+		switch ll := self.logical_lines.At(i); ll.line_type {
+		case HUNK_TITLE_LINE:
+			return ll
+		case TITLE_LINE:
+			break backwards
+		}
+	}
+	for i := self.scroll_pos.logical_line + 1; i < self.logical_lines.Len(); i++ {
+		switch ll := self.logical_lines.At(i); ll.line_type {
+		case HUNK_TITLE_LINE:
+			return ll
+		case TITLE_LINE:
+			return nil
+		}
+	}
+	return nil
+}
+
+// refusal returns why hunks from this comparison cannot be applied in the
+// specified way, or an empty string if they can. The hunks are applied to the
+// right side, so staging needs the index on the left and the working tree on
+// the right, and unstaging needs HEAD on the left and the index on the right.
+func (self *git_comparison) refusal(how string) string {
+	switch how {
+	case "stage":
+		if !self.working_tree || self.left != "" {
+			return "Hunks can only be staged when comparing the index to the working tree"
+		}
+	case "unstage":
+		if self.working_tree || self.left != "HEAD" || self.right != "" {
+			return "Hunks can only be unstaged when comparing HEAD to the index, use --staged"
+		}
+	case "revert":
+		if !self.working_tree {
+			return "Hunks can only be reverted when comparing to the working tree"
+		}
+	}
+	return ""
+}
+
+func (self *Handler) apply_hunk(how string) error {
+	var args []string
+	switch how {
+	case "stage":
+		args = []string{"apply", "--cached"}
+	case "unstage":
+		args = []string{"apply", "--cached", "--reverse"}
+	case "revert":
+		args = []string{"apply", "--reverse"}
+	default:
+		return fmt.Errorf("Unknown way to apply a hunk: %#v", how)
+	}
+	message := func(text string) error {
+		self.statusline_message = text
+		self.draw_status_line()
+		return nil
+	}
+	if self.git == nil || self.logical_lines == nil || self.diff_map == nil {
+		return message("Hunks can only be applied when comparing git revisions")
+	}
+	if reason := self.git.refusal(how); reason != "" {
+		return message(reason)
+	}
+	ht := self.current_hunk_title()
+	if ht == nil {
+		return message("No hunk to apply, only changes to modified files can be applied")
+	}
+	left_path, right_path := ht.left_reference.path, ht.right_reference.path
+	var hunk *Hunk
+	if patch := self.diff_map[left_path]; patch != nil {
+		for _, h := range patch.all_hunks {
+			if h.left_start+1 == ht.left_reference.linenum && h.right_start+1 == ht.right_reference.linenum {
+				hunk = h
+				break
+			}
+		}
+	}
+	if hunk == nil {
+		return message("No hunk to apply, only changes to modified files can be applied")
+	}
+	name, err := filepath.Rel(self.git.left_dir, left_path)
+	if err != nil {
+		return err
+	}
+	name = filepath.ToSlash(name)
+	left, left_nl, err := raw_lines_for_path(left_path)
+	if err != nil {
+		return err
+	}
+	right, right_nl, err := raw_lines_for_path(right_path)
+	if err != nil {
+		return err
+	}
+	patch := hunk_as_patch(name, left, right, left_nl, right_nl, hunk)
+	if !slices.ContainsFunc(hunk.chunks, func(c *Chunk) bool { return c.is_context }) {
+		args = append(args, "--unidiff-zero")
+	}
+	if conf.Ignore_whitespace != Ignore_whitespace_none {
+		// the context lines may differ in whitespace from the file
+		args = append(args, "--ignore-whitespace")
+	}
+	args = append([]string{"-C", self.git.top}, args...)
+	if how == "revert" {
+		// reverting discards changes in the working tree, so ask first
+		self.pending_confirmation = func() error { return self.run_git_apply(args, patch, name, left_path, right_path) }
+		return message("Revert this hunk in the working tree, discarding the changes? [y/n]")
+	}
+	return self.run_git_apply(args, patch, name, left_path, right_path)
+}
+
+func (self *Handler) run_git_apply(args []string, patch, name, left_path, right_path string) error {
+	if _, err := git(strings.NewReader(patch), append(args, "-")...); err != nil {
+		self.statusline_message = strings.ReplaceAll(strings.TrimSpace(err.Error()), "\n", " ")
+		self.draw_status_line()
+		return nil
+	}
+	// refresh both sides of the file from git and show the updated diff
+	for _, x := range []struct {
+		is_left   bool
+		dir, path string
+	}{{true, self.git.left_dir, left_path}, {false, self.git.right_dir, right_path}} {
+		data, err := self.git.read(x.is_left, name)
+		if err == nil {
+			err = write_file_in(x.dir, name, data)
+		}
+		if err != nil {
+			return err
+		}
+		invalidate_caches_for_path(x.path)
+	}
+	pos := self.scroll_pos
+	self.restore_position = &pos
+	self.generate_diff()
+	go func() {
+		r := AsyncResult{rtype: HIGHLIGHT}
+		highlight_all([]string{left_path, right_path})
+		self.async_results <- r
+		self.lp.WakeupMainThread()
+	}()
+	return nil
+}
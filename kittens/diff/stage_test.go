@@ -0,0 +1,67 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestHunkAsPatch(t *testing.T) {
+	left := []string{"one", "two", "three", "four"}
+	right := []string{"one", "TWO", "three", "four", "five"}
+	hunk := &Hunk{left_start: 0, left_count: 4, right_start: 0, right_count: 5}
+	for _, op := range []func(){hunk.context_line, hunk.remove_line, hunk.add_line, hunk.context_line, hunk.context_line, hunk.add_line} {
+		op()
+	}
+	if err := hunk.finalize(left, right); err != nil {
+		t.Fatal(err)
+	}
+	expected := `diff --git a/some dir/file b/some dir/file
+--- a/some dir/file
++++ b/some dir/file
+@@ -1,4 +1,5 @@
+ one
+-two
++TWO
+ three
+ four
++five
+\ No newline at end of file
+`
+	if diff := cmp.Diff(expected, hunk_as_patch("some dir/file", left, right, false, true, hunk)); diff != "" {
+		t.Fatalf("Incorrect patch for hunk:\n%s", diff)
+	}
+	if diff := cmp.Diff(`"a/quote\"d"`, quote_patch_name(`a/quote"d`)); diff != "" {
+		t.Fatalf("Incorrectly quoted name:\n%s", diff)
+	}
+}
+
+func TestApplyHunkRefusal(t *testing.T) {
+	for _, x := range []struct {
+		how        string
+		comparison git_comparison
+		allowed    bool
+	}{
+		{"stage", git_comparison{working_tree: true}, true},
+		{"unstage", git_comparison{left: "HEAD"}, true},
+		{"revert", git_comparison{working_tree: true}, true},
+		{"revert", git_comparison{left: "HEAD~3", working_tree: true}, true},
+		// kitten diff HEAD~3
+		{"stage", git_comparison{left: "HEAD~3", working_tree: true}, false},
+		// kitten diff --staged HEAD~3
+		{"unstage", git_comparison{left: "HEAD~3"}, false},
+		{"unstage", git_comparison{left: "HEAD", right: "HEAD~1"}, false},
+		{"stage", git_comparison{left: "HEAD"}, false},
+		{"unstage", git_comparison{working_tree: true}, false},
+		{"revert", git_comparison{left: "HEAD"}, false},
+	} {
+		if reason := x.comparison.refusal(x.how); (reason == "") != x.allowed {
+			t.Fatalf("Incorrect refusal to %s with %#v: %#v", x.how, x.comparison, reason)
+		}
+	}
+}
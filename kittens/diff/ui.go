@@ -74,6 +74,14 @@ type Handler struct {
 	current_search_is_regex, current_search_is_backward bool
 	largest_line_number                                 int
 	images_resized_to                                   graphics.Size
+	layout                                              string
+	merge                                               *Merge
+	git                                                 *git_comparison
+	scrolled_to_hunk                                    int
+	scrolled_to_hunk_pos                                ScrollPos
+	file_tree                                           *FileTree
+	inputting_filter                                    bool
+	pending_confirmation                                func() error
 }
 
 func (self *Handler) calculate_statistics() {
@@ -115,6 +123,8 @@ func (self *Handler) initialize() {
 	self.rl = readline.New(self.lp, readline.RlInit{DontMarkPrompts: true, Prompt: "/"})
 	self.lp.OnEscapeCode = self.on_escape_code
 	image_collection = graphics.NewImageCollection()
+	self.layout = opts.Layout
+	image_display.mode = conf.Image_compare_mode
 	self.current_context_count = opts.Context
 	if self.current_context_count < 0 {
 		self.current_context_count = int(conf.Num_context_lines)
@@ -141,15 +151,17 @@ func (self *Handler) initialize() {
 
 func (self *Handler) generate_diff() {
 	self.diff_map = nil
-	jobs := make([]diff_job, 0, 32)
-	self.collection.Apply(func(path, typ, changed_path string) error {
-		if typ == "diff" {
-			if is_path_text(path) && is_path_text(changed_path) {
-				jobs = append(jobs, diff_job{path, changed_path})
-			}
-		}
-		return nil
-	})
+	if self.merge != nil {
+		context_count := self.current_context_count
+		go func() {
+			r := AsyncResult{rtype: DIFF, diff_map: make(map[string]*Patch, 1)}
+			r.diff_map[self.merge.local], r.err = self.merge.as_patch(context_count)
+			self.async_results <- r
+			self.lp.WakeupMainThread()
+		}()
+		return
+	}
+	jobs := diff_jobs(self.collection)
 	go func() {
 		r := AsyncResult{rtype: DIFF}
 		r.diff_map, r.err = diff(jobs, self.current_context_count)
@@ -204,6 +216,8 @@ func (self *Handler) load_all_images() {
 		go func() {
 			r := AsyncResult{rtype: IMAGE_LOAD}
 			image_collection.LoadAll()
+			compare_all_images(self.collection)
+			add_composites(image_display.mode, image_display.blend)
 			self.async_results <- r
 			self.lp.WakeupMainThread()
 		}()
@@ -252,6 +266,7 @@ func (self *Handler) handle_async_result(r AsyncResult) error {
 	case DIFF:
 		self.diff_map = r.diff_map
 		self.calculate_statistics()
+		self.file_tree.update(self.collection, self.diff_map)
 		self.clear_mouse_selection()
 		err := self.render_diff()
 		if err != nil {
@@ -268,8 +283,13 @@ func (self *Handler) handle_async_result(r AsyncResult) error {
 		self.draw_screen()
 	case IMAGE_RESIZE:
 		self.images_resized_to = r.page_size
+		self.remove_stale_composites()
+		return self.rerender_diff()
+	case IMAGE_LOAD:
+		// ensure the images added after loading are resized as well
+		self.images_resized_to = graphics.Size{}
 		return self.rerender_diff()
-	case IMAGE_LOAD, HIGHLIGHT:
+	case HIGHLIGHT:
 		return self.rerender_diff()
 	}
 	return nil
@@ -292,13 +312,18 @@ func (self *Handler) on_resize(old_size, new_size loop.ScreenSize) error {
 }
 
 func (self *Handler) render_diff() (err error) {
+	if self.diff_columns() < 8 {
+		self.file_tree.visible = false
+	}
 	if self.screen_size.columns < 8 {
 		return fmt.Errorf("Screen too narrow, need at least 8 columns")
 	}
 	if self.screen_size.rows < 2 {
 		return fmt.Errorf("Screen too short, need at least 2 rows")
 	}
-	self.logical_lines, err = render(self.collection, self.diff_map, self.screen_size, self.largest_line_number, self.images_resized_to)
+	sz := self.screen_size
+	sz.columns = self.diff_columns()
+	self.logical_lines, err = render(self.visible_collection(), self.diff_map, sz, self.largest_line_number, self.images_resized_to, self.use_unified_layout())
 	if err != nil {
 		return err
 	}
@@ -316,6 +341,44 @@ func (self *Handler) render_diff() (err error) {
 	return nil
 }
 
+func (self *Handler) use_unified_layout() bool {
+	switch self.layout {
+	case "unified":
+		return true
+	case "side-by-side":
+		return false
+	}
+	return self.diff_columns() < int(conf.Unified_layout_below)
+}
+
+func (self *Handler) toggle_layout() error {
+	if self.logical_lines == nil {
+		return nil
+	}
+	if self.use_unified_layout() {
+		self.layout = "side-by-side"
+	} else {
+		self.layout = "unified"
+	}
+	return self.render_diff_keeping_position()
+}
+
+// render_diff_keeping_position re-renders the diff keeping the line at the
+// top of the screen the same
+func (self *Handler) render_diff_keeping_position() error {
+	current := self.logical_lines.At(self.scroll_pos.logical_line)
+	self.clear_mouse_selection()
+	if err := self.render_diff(); err != nil {
+		return err
+	}
+	self.scroll_pos = ScrollPos{self.logical_lines.index_of_line_for(current), 0}
+	if self.max_scroll_pos.Less(self.scroll_pos) {
+		self.scroll_pos = self.max_scroll_pos
+	}
+	self.draw_screen()
+	return nil
+}
+
 func (self *Handler) draw_image(key string, num_rows, starting_row int) {
 	image_collection.PlaceImageSubRect(self.lp, key, self.images_resized_to, 0, self.screen_size.cell_height*starting_row, -1, -1)
 }
@@ -378,6 +441,7 @@ func (self *Handler) draw_screen() {
 			break
 		}
 	}
+	self.draw_file_tree()
 	self.draw_status_line()
 }
 
@@ -401,7 +465,9 @@ func (self *Handler) draw_status_line() {
 		}
 		sp := statusline_format(fmt.Sprintf("%d%%", frac))
 		var counts string
-		if self.current_search == nil {
+		if self.merge != nil && self.current_search == nil {
+			counts = statusline_format(fmt.Sprintf("%d of %d conflicts resolved", self.merge.num_of_resolved_conflicts(), self.merge.num_of_conflicts))
+		} else if self.current_search == nil {
 			counts = added_count_format(strconv.Itoa(self.added_count)) + statusline_format(`,`) + removed_count_format(strconv.Itoa(self.removed_count))
 		} else {
 			counts = statusline_format(fmt.Sprintf("%d matches", self.current_search.Len()))
@@ -419,7 +485,7 @@ func (self *Handler) on_text(text string, a, b bool) error {
 		return self.rl.OnText(text, a, b)
 	}
 	if self.statusline_message != "" {
-		self.statusline_message = ""
+		self.statusline_message, self.pending_confirmation = "", nil
 		self.draw_status_line()
 		return nil
 	}
@@ -458,13 +524,17 @@ func (self *Handler) on_key_event(ev *loop.KeyEvent) error {
 	if self.inputting_command {
 		defer self.draw_status_line()
 		if ev.MatchesPressOrRepeat("esc") {
-			self.inputting_command = false
+			self.inputting_command, self.inputting_filter = false, false
 			ev.Handled = true
 			return nil
 		}
 		if ev.MatchesPressOrRepeat("enter") {
 			self.inputting_command = false
 			ev.Handled = true
+			if self.inputting_filter {
+				self.inputting_filter = false
+				return self.set_filter(strings.TrimSpace(self.rl.AllText()))
+			}
 			self.do_search(self.rl.AllText())
 			self.draw_screen()
 			return nil
@@ -476,6 +546,13 @@ func (self *Handler) on_key_event(ev *loop.KeyEvent) error {
 			ev.Handled = true
 			self.statusline_message = ""
 			self.draw_status_line()
+			if confirmed := self.pending_confirmation; confirmed != nil {
+				// any key other than y cancels
+				self.pending_confirmation = nil
+				if ev.MatchesPressOrRepeat("y") {
+					return confirmed()
+				}
+			}
 		}
 		return nil
 	}
@@ -577,6 +654,7 @@ func (self *Handler) start_search(is_regex, is_backward bool) {
 	self.inputting_command = true
 	self.current_search_is_regex = is_regex
 	self.current_search_is_backward = is_backward
+	self.rl.SetPrompt("/")
 	self.rl.SetText(``)
 	self.draw_status_line()
 }
@@ -584,7 +662,12 @@ func (self *Handler) start_search(is_regex, is_backward bool) {
 func (self *Handler) dispatch_action(name, args string) error {
 	switch name {
 	case `quit`:
-		self.lp.Quit(0)
+		if self.merge != nil {
+			// quitting without saving the merge is a failure
+			self.lp.Quit(1)
+		} else {
+			self.lp.Quit(0)
+		}
 	case `copy_to_clipboard`:
 		text := self.text_for_current_mouse_selection()
 		if text == "" {
@@ -620,6 +703,8 @@ func (self *Handler) dispatch_action(name, args string) error {
 			done = self.scroll_to_next_change(strings.Contains(args, `prev`))
 		case strings.Contains(args, `match`):
 			done = self.scroll_to_next_match(strings.Contains(args, `prev`), false)
+		case strings.Contains(args, `file`):
+			done = self.scroll_to_next_file(strings.Contains(args, `prev`))
 		case strings.Contains(args, `page`):
 			amt := self.screen_size.num_lines
 			if strings.Contains(args, `prev`) {
@@ -660,6 +745,32 @@ func (self *Handler) dispatch_action(name, args string) error {
 			a, b, _ := strings.Cut(args, " ")
 			self.start_search(config.StringToBool(a), config.StringToBool(b))
 		}
+	case `toggle_layout`:
+		return self.toggle_layout()
+	case `cycle_image_compare_mode`:
+		self.cycle_image_compare_mode()
+	case `adjust_image_blend`:
+		delta, err := strconv.Atoi(args)
+		if err != nil {
+			return fmt.Errorf("Invalid argument to adjust_image_blend: %#v", args)
+		}
+		self.adjust_image_blend(delta)
+	case `toggle_file_tree`:
+		return self.toggle_file_tree()
+	case `toggle_reviewed`:
+		if self.logical_lines != nil {
+			self.toggle_reviewed()
+		}
+	case `filter_files`:
+		if self.diff_map != nil && self.logical_lines != nil {
+			self.start_filter()
+		}
+	case `resolve_conflict`:
+		return self.resolve_conflict(args)
+	case `apply_hunk`:
+		return self.apply_hunk(args)
+	case `save_merge`:
+		self.save_merge()
 	}
 	return nil
 }
@@ -673,6 +784,10 @@ func (self *Handler) on_mouse_event(ev *loop.MouseEvent) error {
 		return nil
 	}
 	if ev.Event_type == loop.MOUSE_PRESS && ev.Buttons&loop.LEFT_MOUSE_BUTTON != 0 {
+		if ev.Cell.X >= self.diff_columns() {
+			self.handle_file_tree_click(ev)
+			return nil
+		}
 		self.start_mouse_selection(ev)
 		return nil
 	}
@@ -0,0 +1,176 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// In the unified layout every line is full width. The margin holds the line
+// numbers from both sides followed by a +/- gutter, and the removed lines of
+// a change are shown above the added lines.
+
+func unified_margin_size(largest_line_number int) int {
+	return 2*len(strconv.Itoa(largest_line_number)) + 4
+}
+
+// unified_margin returns the margin text for a line, line numbers are one
+// based, zero means the line is not present on that side
+func unified_margin(margin_size, left_lnum, right_lnum int, gutter string) string {
+	width := (margin_size - 4) / 2
+	left, right := "", ""
+	if left_lnum > 0 {
+		left = strconv.Itoa(left_lnum)
+	}
+	if right_lnum > 0 {
+		right = strconv.Itoa(right_lnum)
+	}
+	return fmt.Sprintf("%*s %*s %s", width, left, width, right, gutter)
+}
+
+func unified_title_lines(left_path, right_path string, columns, margin_size int, ans []*LogicalLine) []*LogicalLine {
+	left_name, right_name := path_name_map[left_path], path_name_map[right_path]
+	title := left_name
+	if right_name != "" && right_name != left_name {
+		title = left_name + " → " + right_name
+	}
+	ll := LogicalLine{
+		line_type: TITLE_LINE, is_full_width: true,
+		left_reference: Reference{path: left_path}, right_reference: Reference{path: right_path},
+	}
+	sl := ScreenLine{}
+	sl.left.marked_up_text = format_as_sgr.title + fit_in(sanitize(title), columns-margin_size)
+	ll.screen_lines = append(ll.screen_lines, &sl)
+	l2 := ll
+	l2.line_type = EMPTY_LINE
+	l2.screen_lines = nil
+	sl2 := ScreenLine{}
+	sl2.left.marked_up_margin_text = "\x1b[m" + strings.Repeat("━", margin_size)
+	sl2.left.marked_up_text = strings.Repeat("━", columns-margin_size)
+	l2.screen_lines = append(l2.screen_lines, &sl2)
+	return append(ans, &ll, &l2)
+}
+
+func unified_logical_line(ll LogicalLine, hlines []HalfScreenLine) *LogicalLine {
+	ll.is_full_width = true
+	ll.screen_lines = make([]*ScreenLine, len(hlines))
+	for i, hl := range hlines {
+		ll.screen_lines[i] = &ScreenLine{left: hl}
+	}
+	return &ll
+}
+
+func unified_lines_for_context_chunk(data *DiffData, chunk *Chunk, ans []*LogicalLine) []*LogicalLine {
+	hlines := make([]HalfScreenLine, 0, 8)
+	for i := 0; i < chunk.left_count; i++ {
+		left_lnum, right_lnum := chunk.left_start+i+1, chunk.right_start+i+1
+		margin := unified_margin(data.margin_size, left_lnum, right_lnum, " ")
+		hlines = render_half_line(margin, data.left_lines[left_lnum-1], "context", data.available_cols, nil, hlines[:0])
+		ans = append(ans, unified_logical_line(LogicalLine{
+			line_type:       CONTEXT_LINE,
+			left_reference:  Reference{path: data.left_path, linenum: left_lnum},
+			right_reference: Reference{path: data.right_path, linenum: right_lnum},
+		}, hlines))
+	}
+	return ans
+}
+
+func unified_lines_for_diff_chunk(data *DiffData, chunk *Chunk, ans []*LogicalLine) []*LogicalLine {
+	hlines := make([]HalfScreenLine, 0, 8)
+	for i := 0; i < chunk.left_count; i++ {
+		var changes []Segment
+		if i < len(chunk.changes) {
+			changes = chunk.changes[i].left
+		}
+		lnum := chunk.left_start + i + 1
+		hlines = render_changed_half_line(unified_margin(data.margin_size, lnum, 0, "-"), data.left_lines[lnum-1], "remove", data.available_cols, changes, data.moved_left[lnum-1], hlines[:0])
+		ans = append(ans, unified_logical_line(LogicalLine{
+			line_type: CHANGE_LINE, is_change_start: i == 0,
+			left_reference: Reference{path: data.left_path, linenum: lnum},
+		}, hlines))
+	}
+	for i := 0; i < chunk.right_count; i++ {
+		var changes []Segment
+		if i < len(chunk.changes) {
+			changes = chunk.changes[i].right
+		}
+		lnum := chunk.right_start + i + 1
+		hlines = render_changed_half_line(unified_margin(data.margin_size, 0, lnum, "+"), data.right_lines[lnum-1], "add", data.available_cols, changes, data.moved_right[lnum-1], hlines[:0])
+		ans = append(ans, unified_logical_line(LogicalLine{
+			line_type: CHANGE_LINE, is_change_start: i == 0 && chunk.left_count == 0, is_addition: true,
+			right_reference: Reference{path: data.right_path, linenum: lnum},
+		}, hlines))
+	}
+	return ans
+}
+
+func unified_message_line(msg string, columns, margin_size int, ll LogicalLine) *LogicalLine {
+	ll.line_type = HUNK_TITLE_LINE
+	hlines := make([]HalfScreenLine, 0, 2)
+	for _, line := range splitlines(msg, columns-margin_size) {
+		hlines = append(hlines, HalfScreenLine{marked_up_text: line})
+	}
+	return unified_logical_line(ll, hlines)
+}
+
+func unified_all_lines(path string, columns, margin_size int, is_add bool, ans []*LogicalLine) ([]*LogicalLine, error) {
+	available_cols := columns - margin_size
+	ltype, gutter, msg := `remove`, `-`, `This file was removed`
+	ll := LogicalLine{line_type: CHANGE_LINE, is_addition: is_add}
+	if is_add {
+		ltype, gutter, msg = `add`, `+`, `This file was added`
+		ll.right_reference.path = path
+	} else {
+		ll.left_reference.path = path
+	}
+	lines, err := highlighted_lines_for_path(path)
+	if err != nil {
+		return nil, err
+	}
+	ans = append(ans, unified_message_line(msg, columns, margin_size, ll))
+	hlines := make([]HalfScreenLine, 0, 8)
+	for line_number, line := range lines {
+		l := ll
+		margin := ""
+		if is_add {
+			l.right_reference.linenum = line_number + 1
+			margin = unified_margin(margin_size, 0, line_number+1, gutter)
+		} else {
+			l.left_reference.linenum = line_number + 1
+			margin = unified_margin(margin_size, line_number+1, 0, gutter)
+		}
+		l.is_change_start = line_number == 0
+		hlines = render_half_line(margin, line, ltype, available_cols, nil, hlines[:0])
+		ans = append(ans, unified_logical_line(l, hlines))
+	}
+	return ans, nil
+}
+
+func unified_binary_lines(left_path, right_path string, columns, margin_size int, ans []*LogicalLine) ([]*LogicalLine, error) {
+	is_change_start := true
+	for _, path := range []string{left_path, right_path} {
+		if path == "" {
+			continue
+		}
+		sz, err := size_for_path(path)
+		if err != nil {
+			return nil, err
+		}
+		ll := LogicalLine{line_type: CHANGE_LINE, is_change_start: is_change_start, is_addition: path == right_path}
+		gutter := `-`
+		if ll.is_addition {
+			gutter = `+`
+			ll.right_reference.path = path
+		} else {
+			ll.left_reference.path = path
+		}
+		hlines := render_half_line(unified_margin(margin_size, 0, 0, gutter), fmt.Sprintf("Binary file: %s", human_readable(sz)), "", columns-margin_size, nil, nil)
+		ans = append(ans, unified_logical_line(ll, hlines))
+		is_change_start = false
+	}
+	return ans, nil
+}
@@ -0,0 +1,73 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package diff
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestUnifiedLayout(t *testing.T) {
+	margin_size := unified_margin_size(12)
+	if diff := cmp.Diff("12  3 -", unified_margin(margin_size, 12, 3, "-")); diff != "" {
+		t.Fatalf("Unexpected margin:\n%s", diff)
+	}
+	if diff := cmp.Diff("    7 +", unified_margin(margin_size, 0, 7, "+")); diff != "" {
+		t.Fatalf("Unexpected margin:\n%s", diff)
+	}
+
+	data := DiffData{
+		left_path: "left", right_path: "right", available_cols: 80, margin_size: margin_size, unified: true,
+		left_lines:  []string{"same", "old one", "old two", "after"},
+		right_lines: []string{"same", "new one", "after"},
+	}
+	var lines []*LogicalLine
+	lines = unified_lines_for_context_chunk(&data, &Chunk{is_context: true, left_count: 1, right_count: 1}, lines)
+	lines = unified_lines_for_diff_chunk(&data, &Chunk{left_start: 1, right_start: 1, left_count: 2, right_count: 1}, lines)
+	lines = unified_lines_for_context_chunk(&data, &Chunk{is_context: true, left_start: 3, right_start: 2, left_count: 1, right_count: 1}, lines)
+	type line struct {
+		Margin, Text           string
+		Type                   LineType
+		Addition, Change_start bool
+	}
+	actual := make([]line, len(lines))
+	for i, ll := range lines {
+		if !ll.is_full_width || len(ll.screen_lines) != 1 {
+			t.Fatalf("Line %d is not a single full width line", i)
+		}
+		sl := ll.screen_lines[0].left
+		actual[i] = line{sl.marked_up_margin_text, sl.marked_up_text, ll.line_type, ll.is_addition, ll.is_change_start}
+	}
+	expected := []line{
+		{" 1  1  ", "same", CONTEXT_LINE, false, false},
+		{" 2    -", "old one", CHANGE_LINE, false, true},
+		{" 3    -", "old two", CHANGE_LINE, false, false},
+		{"    2 +", "new one", CHANGE_LINE, true, false},
+		{" 4  3  ", "after", CONTEXT_LINE, false, false},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("Unexpected unified lines:\n%s", diff)
+	}
+
+	side_by_side := &LogicalLines{lines: []*LogicalLine{
+		{line_type: CONTEXT_LINE, left_reference: Reference{"left", 1}, right_reference: Reference{"right", 1}},
+		{line_type: CHANGE_LINE, left_reference: Reference{"left", 2}, right_reference: Reference{"right", 2}},
+		{line_type: CHANGE_LINE, left_reference: Reference{"left", 3}, right_reference: Reference{"right", 0}},
+		{line_type: CONTEXT_LINE, left_reference: Reference{"left", 4}, right_reference: Reference{"right", 3}},
+	}}
+	unified := &LogicalLines{lines: lines}
+	for i, expected := range []int{0, 1, 2, 1, 3} {
+		if actual := side_by_side.index_of_line_for(unified.At(i)); actual != expected {
+			t.Fatalf("Unified line %d maps to side-by-side line %d instead of %d", i, actual, expected)
+		}
+	}
+	for i, expected := range []int{0, 1, 2, 4} {
+		if actual := unified.index_of_line_for(side_by_side.At(i)); actual != expected {
+			t.Fatalf("Side-by-side line %d maps to unified line %d instead of %d", i, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+
+	"kitty/tools/tui/subseq"
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// filter_marks returns the marks whose text contains the characters of query
+// in order, best matches first, marks that match equally well are in the
+// order they are on screen
+func filter_marks(query string, marks []*Mark) []*Mark {
+	if query == "" {
+		return marks
+	}
+	matches := subseq.ScoreItems(query, utils.Map(func(m *Mark) string { return m.Text }, marks), subseq.Options{})
+	ans := make([]*Mark, 0, len(marks))
+	scores := make(map[*Mark]float64, len(marks))
+	for i, m := range matches {
+		if m.Score > 0 {
+			ans = append(ans, marks[i])
+			scores[marks[i]] = m.Score
+		}
+	}
+	return utils.StableSort(ans, func(a, b *Mark) bool { return scores[a] > scores[b] })
+}
+
+// label_marks assigns consecutive hints to the marks, starting from offset,
+// so that the best matches get the shortest hints
+func label_marks(marks []*Mark, offset int) map[int]*Mark {
+	ans := make(map[int]*Mark, len(marks))
+	for i, m := range marks {
+		ans[offset+i] = m
+	}
+	return ans
+}
@@ -0,0 +1,44 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+	"testing"
+
+	"kitty/tools/utils"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestFilterMarks(t *testing.T) {
+	marks := utils.Map(func(text string) *Mark { return &Mark{Text: text} }, []string{
+		"https://example.com/foo", "https://github.com/kovidgoyal/kitty", "https://gitlab.com/x/y", "/usr/bin/git",
+	})
+	f := func(query string, expected ...string) {
+		actual := utils.Map(func(m *Mark) string { return m.Text }, filter_marks(query, marks))
+		if len(expected) == 0 && len(actual) == 0 {
+			return
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Fatalf("Filtering by %#v failed:\n%s", query, diff)
+		}
+	}
+	f("", utils.Map(func(m *Mark) string { return m.Text }, marks)...)
+	f("gitk", "https://github.com/kovidgoyal/kitty")
+	f("GITK", "https://github.com/kovidgoyal/kitty")
+	f("xyz")
+	f("bin/git", "/usr/bin/git")
+
+	labelled := label_marks(filter_marks("git", marks), 1)
+	if len(labelled) != 3 || labelled[0] != nil {
+		t.Fatalf("Incorrect hints for filtered marks: %v", labelled)
+	}
+	for i := 1; i <= 3; i++ {
+		if labelled[i] == nil {
+			t.Fatalf("No mark for hint: %d", i)
+		}
+	}
+}
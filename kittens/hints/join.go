@@ -0,0 +1,72 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// join_lines returns the text with the lines that were wrapped by the
+// terminal joined, and, if join_full_width is true, also the lines that fill
+// the whole width of the screen joined to the lines after them, as these are
+// usually text wrapped by the program that output it. The NUL padding added by
+// convert_text is removed, so that regular expressions can match across the
+// newlines that remain. offsets maps every byte of the joined text, and its
+// end, to the corresponding byte in text.
+func join_lines(text string, join_full_width bool) (joined string, offsets []int) {
+	ans := strings.Builder{}
+	ans.Grow(len(text))
+	offsets = make([]int, 0, len(text)+1)
+	for i := 0; i < len(text); {
+		ch := text[i]
+		if ch == 0 || ch == '\r' || ch == '\n' {
+			// a run of padding followed by a line end, or the end of the text
+			j := i
+			for j < len(text) && text[j] == 0 {
+				j++
+			}
+			if j > i && j < len(text) && text[j] != '\r' && text[j] != '\n' {
+				// NUL in the middle of a line, not padding
+				ans.WriteByte(0)
+				offsets = append(offsets, i)
+				i++
+				continue
+			}
+			if j < len(text) {
+				is_full_width := j == i && i > 0 && text[i-1] != '\n' && text[i-1] != '\r'
+				if text[j] == '\n' && !(join_full_width && is_full_width) {
+					ans.WriteByte('\n')
+					offsets = append(offsets, j)
+				}
+				j++
+			}
+			i = j
+			continue
+		}
+		ans.WriteByte(ch)
+		offsets = append(offsets, i)
+		i++
+	}
+	offsets = append(offsets, len(text))
+	return ans.String(), offsets
+}
+
+// map_marks_to_text changes the offsets of the marks from the joined text to
+// the original text
+func map_marks_to_text(marks []Mark, offsets []int) {
+	for i := range marks {
+		m := &marks[i]
+		s, e := m.Start, m.End
+		m.Start = offsets[s]
+		if e > s {
+			// the byte after the last byte of the match, so that any line
+			// ends and padding after the match are not included
+			m.End = offsets[e-1] + 1
+		} else {
+			m.End = m.Start
+		}
+	}
+}
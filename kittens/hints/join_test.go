@@ -0,0 +1,73 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"kitty"
+	"kitty/tools/utils"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestJoinLines(t *testing.T) {
+	cols := 10
+	j := func(text string, join_full_width bool, expected string) {
+		ptext := convert_text(text, cols)
+		joined, offsets := join_lines(ptext, join_full_width)
+		if diff := cmp.Diff(expected, joined); diff != "" {
+			t.Fatalf("Joining %#v failed:\n%s", text, diff)
+		}
+		if len(offsets) != len(joined)+1 || offsets[len(joined)] != len(ptext) {
+			t.Fatalf("Incorrect offsets when joining %#v: %v", text, offsets)
+		}
+		for i := range joined {
+			if joined[i] != ptext[offsets[i]] {
+				t.Fatalf("Offset %d does not point to %#v when joining %#v", i, string(joined[i]), text)
+			}
+		}
+	}
+	j("abc\ndef", false, "abc\ndef")
+	j("abc\ndef", true, "abc\ndef")
+	j("0123456789\rabc\nx", false, "0123456789abc\nx")
+	j("0123456789\nabc\nx", false, "0123456789\nabc\nx")
+	j("0123456789\nabc\nx", true, "0123456789abc\nx")
+	j("a\n\nb", true, "a\n\nb")
+
+	opts := &Options{Type: "path", UrlPrefixes: "default", Regex: kitty.HintsDefaultRegex, MinimumMatchLength: 3}
+	r := func(text string, expected ...string) {
+		ptext := convert_text(text, cols)
+		ptext, marks, _, err := find_marks(ptext, opts)
+		if err != nil {
+			t.Fatalf("%#v failed with error: %s", text, err)
+		}
+		if diff := cmp.Diff(expected, utils.Map(func(m Mark) string { return m.Text }, marks)); diff != "" {
+			t.Fatalf("%#v failed:\n%s", text, diff)
+		}
+		for _, m := range marks {
+			// the marks must point to the text where it is displayed
+			q := strings.NewReplacer("\n", "", "\r", "", "\x00", "").Replace(ptext[m.Start:m.End])
+			if q != m.Text || strings.HasSuffix(ptext[m.Start:m.End], "\x00") {
+				t.Fatalf("Mark start (%d) and end (%d) dont point to %#v in the text for %#v: %#v", m.Start, m.End, m.Text, text, ptext[m.Start:m.End])
+			}
+		}
+	}
+	opts.Type = "word"
+	r("a longword\rs here", "longword", "here")
+	opts.JoinLines = "wrapped"
+	r("a longword\rs here", "longwords", "here")
+
+	opts.Type, opts.JoinLines = "path", "never"
+	r("see a/b/cde\nf.txt", "a/b/cde", "f.txt")
+	opts.JoinLines = "full-width"
+	r("see a/b/cde\nf.txt", "a/b/cdef.txt")
+	r("see a/b/c\nf.txt", "a/b/c", "f.txt")
+
+	opts.Type, opts.Regex = "regex", `(?s)BEGIN(.+?)END`
+	r("BEGIN one\ntwo END", " onetwo ")
+}
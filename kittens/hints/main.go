@@ -121,7 +121,13 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 		tui.ReportError(fmt.Errorf("Failed to read from STDIN with error: %w", err))
 		return 1, nil
 	}
-	if len(args) > 0 && o.CustomizeProcessing == "" && o.Type != "linenum" {
+	if err = check_type(o.Type); err != nil {
+		tui.ReportError(err)
+		return 1, nil
+	}
+	named_type := hint_type(o.Type)
+	is_linenum := o.Type == "linenum" || (named_type != nil && named_type.Linenum_action != "")
+	if len(args) > 0 && o.CustomizeProcessing == "" && !is_linenum {
 		tui.ReportError(fmt.Errorf("Extra command line arguments present: %s", strings.Join(args, " ")))
 		return 1, nil
 	}
@@ -137,6 +143,17 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 		Extra_cli_args: args, Linenum_action: o.LinenumAction,
 	}
 	result.Cwd, _ = os.Getwd()
+	if named_type != nil {
+		if len(result.Programs) == 0 {
+			result.Programs = named_type.Programs
+		}
+		if named_type.Linenum_action != "" {
+			result.Linenum_action = named_type.Linenum_action
+			if result.Customize_processing == "" {
+				result.Customize_processing = "::linenum::"
+			}
+		}
+	}
 	alphabet := o.Alphabet
 	if alphabet == "" {
 		alphabet = DEFAULT_HINT_ALPHABET
@@ -153,6 +170,31 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 	}
 	current_text := ""
 	current_input := ""
+	// in filter mode typed text filters the marks, instead of selecting hints
+	filtering := o.Filter
+	query := ""
+	hint_map := index_map
+	hint_of := make(map[*Mark]int, len(index_map))
+	for idx, m := range index_map {
+		hint_of[m] = idx
+	}
+	refilter := func() {
+		if query == "" {
+			hint_map = index_map
+		} else {
+			candidates := make([]*Mark, 0, len(all_marks))
+			for i := range all_marks {
+				if !ignore_mark_indices.Has(all_marks[i].Index) {
+					candidates = append(candidates, &all_marks[i])
+				}
+			}
+			hint_map = label_marks(filter_marks(query, candidates), utils.Max(0, o.HintsOffset))
+		}
+		hint_of = make(map[*Mark]int, len(hint_map))
+		for idx, m := range hint_map {
+			hint_of[m] = idx
+		}
+	}
 	match_suffix := ""
 	switch o.AddTrailingSpace {
 	case "always":
@@ -164,6 +206,12 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 		}
 	}
 	chosen := []*Mark{}
+	// the action menu is shown once the selection is complete
+	use_menu := o.ActionMenu && o.CustomizeProcessing == "" && !is_linenum
+	showing_menu := false
+	var menu []menu_action
+	var menu_preview preview
+	var chosen_action *menu_action
 	lp, err := loop.New(loop.NoAlternateScreen) // no alternate screen reduces flicker on exit
 	if err != nil {
 		return
@@ -172,9 +220,14 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 	faint := fctx.SprintFunc("dim")
 	hint_style := fctx.SprintFunc(fmt.Sprintf("fg=%s bg=%s bold", o.HintsForegroundColor, o.HintsBackgroundColor))
 	text_style := fctx.SprintFunc(fmt.Sprintf("fg=bright-%s bold", o.HintsTextColor))
+	filter_style := fctx.SprintFunc("reverse")
 
 	highlight_mark := func(m *Mark, mark_text string) string {
-		hint := encode_hint(m.Index, alphabet)
+		idx, found := hint_of[m]
+		if !found {
+			return faint(mark_text)
+		}
+		hint := encode_hint(idx, alphabet)
 		if current_input != "" && !strings.HasPrefix(hint, current_input) {
 			return faint(mark_text)
 		}
@@ -200,19 +253,68 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 		return strings.TrimRightFunc(strings.NewReplacer("\r", "\r\n", "\n", "\r\n").Replace(ans), unicode.IsSpace)
 	}
 
+	draw_menu := func() {
+		lp.ClearScreen()
+		selected := chosen[0].Text
+		if len(chosen) > 1 {
+			selected += fmt.Sprintf(" and %d more", len(chosen)-1)
+		}
+		lines := []string{faint("Selected: ") + selected, menu_preview.description, ""}
+		for _, a := range menu {
+			lines = append(lines, hint_style(" "+a.key+" ")+" "+a.title)
+		}
+		lines = append(lines, "", faint("Press Esc to cancel"))
+		lp.QueueWriteString(strings.Join(lines, "\r\n"))
+	}
+
 	draw_screen := func() {
 		lp.StartAtomicUpdate()
 		defer lp.EndAtomicUpdate()
+		if showing_menu {
+			draw_menu()
+			return
+		}
 		if current_text == "" {
 			current_text = render()
 		}
 		lp.ClearScreen()
 		lp.QueueWriteString(current_text)
+		if filtering {
+			if sz, err := lp.ScreenSize(); err == nil && sz.HeightCells > 0 {
+				lp.MoveCursorTo(1, int(sz.HeightCells))
+				lp.ClearToEndOfLine()
+				lp.QueueWriteString(filter_style(fmt.Sprintf(" Filter: %s ", query)))
+			}
+		}
 	}
 	reset := func() {
 		current_input = ""
 		current_text = ""
 	}
+	finish := func() {
+		if use_menu && len(chosen) > 0 {
+			menu_preview = preview_for(chosen[0], o.Type, result.Cwd)
+			menu = menu_actions(chosen, menu_preview, o.SearchUrl)
+			showing_menu = true
+			draw_screen()
+			return
+		}
+		lp.Quit(0)
+	}
+	choose := func(m *Mark) {
+		chosen = append(chosen, m)
+		ignore_mark_indices.Add(m.Index)
+		if o.Multiple {
+			reset()
+			if query != "" {
+				query = ""
+				refilter()
+			}
+			draw_screen()
+		} else {
+			finish()
+		}
+	}
 
 	lp.OnInitialize = func() (string, error) {
 		lp.SendOverlayReady()
@@ -231,6 +333,26 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 		return nil
 	}
 	lp.OnText = func(text string, _, _ bool) error {
+		if showing_menu {
+			for _, ch := range text {
+				for i := range menu {
+					if menu[i].key == string(ch) {
+						chosen_action = &menu[i]
+						lp.Quit(0)
+						return nil
+					}
+				}
+			}
+			lp.Beep()
+			return nil
+		}
+		if filtering {
+			query += text
+			refilter()
+			current_text = ""
+			draw_screen()
+			return nil
+		}
 		changed := false
 		for _, ch := range text {
 			if strings.ContainsRune(alphabet, ch) {
@@ -240,20 +362,14 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 		}
 		if changed {
 			matches := []*Mark{}
-			for idx, m := range index_map {
+			for idx, m := range hint_map {
 				if eh := encode_hint(idx, alphabet); strings.HasPrefix(eh, current_input) {
 					matches = append(matches, m)
 				}
 			}
 			if len(matches) == 1 {
-				chosen = append(chosen, matches[0])
-				if o.Multiple {
-					ignore_mark_indices.Add(matches[0].Index)
-					reset()
-				} else {
-					lp.Quit(0)
-					return nil
-				}
+				choose(matches[0])
+				return nil
 			}
 			current_text = ""
 			draw_screen()
@@ -262,28 +378,53 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 	}
 
 	lp.OnKeyEvent = func(ev *loop.KeyEvent) error {
-		if ev.MatchesPressOrRepeat("backspace") {
+		if showing_menu {
+			if ev.MatchesPressOrRepeat("esc") {
+				lp.Quit(1)
+			} else if ev.MatchesPressOrRepeat("enter") {
+				ev.Handled = true
+				chosen_action = &menu[0]
+				lp.Quit(0)
+			}
+			return nil
+		}
+		if ev.MatchesPressOrRepeat("tab") {
 			ev.Handled = true
-			r := []rune(current_input)
-			if len(r) > 0 {
-				r = r[:len(r)-1]
-				current_input = string(r)
-				current_text = ""
+			filtering = !filtering
+			reset()
+			draw_screen()
+		} else if ev.MatchesPressOrRepeat("backspace") {
+			ev.Handled = true
+			if filtering {
+				r := []rune(query)
+				if len(r) > 0 {
+					query = string(r[:len(r)-1])
+					refilter()
+					current_text = ""
+				}
+			} else {
+				r := []rune(current_input)
+				if len(r) > 0 {
+					r = r[:len(r)-1]
+					current_input = string(r)
+					current_text = ""
+				}
 			}
 			draw_screen()
-		} else if ev.MatchesPressOrRepeat("enter") || ev.MatchesPressOrRepeat("space") {
+		} else if filtering && ev.MatchesPressOrRepeat("enter") {
+			ev.Handled = true
+			// choose the best match, which has the first hint
+			if m := hint_map[utils.Max(0, o.HintsOffset)]; m != nil && query != "" {
+				choose(m)
+			} else {
+				lp.Beep()
+			}
+		} else if !filtering && (ev.MatchesPressOrRepeat("enter") || ev.MatchesPressOrRepeat("space")) {
 			ev.Handled = true
 			if current_input != "" {
 				idx := decode_hint(current_input, alphabet)
-				if m := index_map[idx]; m != nil {
-					chosen = append(chosen, m)
-					ignore_mark_indices.Add(idx)
-					if o.Multiple {
-						reset()
-						draw_screen()
-					} else {
-						lp.Quit(0)
-					}
+				if m := hint_map[idx]; m != nil {
+					choose(m)
 				} else {
 					current_input = ""
 					current_text = ""
@@ -292,7 +433,7 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 			}
 		} else if ev.MatchesPressOrRepeat("esc") {
 			if o.Multiple {
-				lp.Quit(0)
+				finish()
 			} else {
 				lp.Quit(1)
 			}
@@ -319,6 +460,9 @@ func main(_ *cli.Command, o *Options, args []string) (rc int, err error) {
 		result.Match[i] = m.Text + match_suffix
 		result.Groupdicts[i] = m.Groupdict
 	}
+	if chosen_action != nil {
+		chosen_action.apply(&result)
+	}
 	fmt.Println(output(result))
 	return
 }
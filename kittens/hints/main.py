@@ -23,6 +23,8 @@ def load_custom_processor(customize_processing: str) -> Any:
         return {k: getattr(m, k) for k in dir(m)}
     if customize_processing == '::linenum::':
         return {'handle_result': linenum_handle_result}
+    if customize_processing == '::editor::':
+        return {'handle_result': editor_handle_result}
     custom_path = resolve_custom_file(customize_processing)
     import runpy
     return runpy.run_path(custom_path, run_name='__main__')
@@ -104,8 +106,13 @@ def custom_marking() -> None:
 
 --type
 default=url
-choices=url,regex,path,line,hash,word,linenum,hyperlink,ip
-The type of text to search for. A value of :code:`linenum` is special, it looks
+completion=type:special group:complete_hint_types
+The type of text to search for. One of :code:`url`, :code:`regex`,
+:code:`path`, :code:`line`, :code:`hash`, :code:`word`, :code:`linenum`,
+:code:`hyperlink`, :code:`ip`, :code:`uuid`, :code:`json` for the string values
+in JSON, :code:`email`, :code:`column:N` for the Nth whitespace separated column
+of every line, such as :code:`column:2`, or a type defined in
+:file:`hints.conf`, see {hints_url} for details. A value of :code:`linenum` is special, it looks
 for error messages using the pattern specified with :option:`--regex`, which
 must have the named groups: :code:`path` and :code:`line`. If not specified,
 will look for :code:`path:line`. The :option:`--linenum-action` option
@@ -125,6 +132,19 @@ def custom_marking() -> None:
 :code:`key=value`.
 
 
+--join-lines
+default=never
+choices=never,wrapped,full-width
+Join lines before searching for text, so that text split over multiple lines can
+be matched. A value of :code:`wrapped` joins the lines that were wrapped by the
+terminal because they were too long for the screen. A value of
+:code:`full-width` also joins lines that fill the whole width of the screen to
+the line after them, as these are usually long lines wrapped by the program that
+output them. When joining, the spaces the terminal adds at the ends of lines are
+removed, so that the remaining newlines can be matched by :option:`--regex`. The
+matched text is still highlighted where it is displayed on screen.
+
+
 --linenum-action
 default=self
 type=choice
@@ -164,6 +184,16 @@ def custom_marking() -> None:
 end. In this mode, press :kbd:`Esc` to finish selecting.
 
 
+--filter
+type=bool-set
+Start in filter mode, where typing filters the matches to those containing the
+typed characters in order, instead of selecting hints. The matches that remain
+are given new, short hints, with the best match getting the first hint. Press
+:kbd:`Enter` to select the best match or :kbd:`Tab` to switch to typing the
+hints of the remaining matches. :kbd:`Tab` can also be used to switch to filter
+mode when this option is not set.
+
+
 --multiple-joiner
 default=auto
 String for joining multiple selections when copying to the clipboard or
@@ -175,6 +205,22 @@ def custom_marking() -> None:
 first selection and :code:`-1` for the last.
 
 
+--action-menu
+type=bool-set
+After selecting, show a menu of actions to perform on the selected text, such as
+opening it, copying it, pasting it, opening it in an editor or searching the web
+for it, instead of running :option:`--program`. Above the menu, the URL or path
+the text refers to is shown, along with whether the path exists. Files are opened
+in the editor set by :opt:`editor` in :file:`kitty.conf`, in a new kitty window.
+Not used with :option:`--customize-processing` or the :code:`linenum` type.
+
+
+--search-url
+default=https://duckduckgo.com/?q={query}
+The URL used by the search action of :option:`--action-menu`, :code:`{query}` is
+replaced by the selected text.
+
+
 --add-trailing-space
 default=auto
 choices=auto,always,never
@@ -233,7 +279,7 @@ def custom_marking() -> None:
 hinted.
 '''.format(
     default_regex=DEFAULT_REGEX,
-    line='{{line}}', path='{{path}}',
+    line='{{line}}', path='{{path}}', query='{{query}}',
     hints_url=website_url('kittens/hints'),
 ).format
 help_text = 'Select text from the screen using the keyboard. Defaults to searching for URLs.'
@@ -294,6 +340,14 @@ def is_copy_action(s: str) -> bool:
             }[action])(*cmd)
 
 
+def editor_handle_result(args: List[str], data: Dict[str, Any], target_window_id: int, boss: BossType, extra_cli_args: Sequence[str], *a: Any) -> None:
+    # used by the action menu to open the file in the editor from kitty.conf
+    path, line = linenum_process_result(data)
+    if path:
+        from kitty.utils import get_editor
+        boss.new_window_with_cwd(*get_editor(get_options(), path, line))
+
+
 @result_handler(type_of_input='screen-ansi', has_ready_notification=True)
 def handle_result(args: List[str], data: Dict[str, Any], target_window_id: int, boss: BossType) -> None:
     cp = data['customize_processing']
@@ -200,6 +200,28 @@ var PostProcessorMap = utils.Once(func() map[string]PostProcessorFunc {
 			}
 			return s, e
 		},
+		"json": func(text string, s, e int) (int, int) {
+			// remove the quotes from string values and ignore object keys, the
+			// escapes in the values are removed in find_marks
+			if e-s < 2 || text[s] != '"' || text[e-1] != '"' {
+				return -1, -1
+			}
+			if rest := strings.TrimLeft(text[e:], " \t\r\n\x00"); strings.HasPrefix(rest, ":") {
+				return -1, -1
+			}
+			return s + 1, e - 1
+		},
+		"email": func(text string, s, e int) (int, int) {
+			// remove trailing punctuation from the domain
+			for e > s && (text[e-1] == '.' || text[e-1] == '-') {
+				e--
+			}
+			local, domain, found := strings.Cut(text[s:e], "@")
+			if !found || local == "" || strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || !strings.Contains(domain, ".") || strings.Contains(domain, "..") {
+				return -1, -1
+			}
+			return s, e
+		},
 	}
 })
 
@@ -231,6 +253,25 @@ var RelevantKittyOpts = utils.Once(func() KittyOpts {
 	return read_relevant_kitty_opts(filepath.Join(utils.ConfigDir(), "kitty.conf"))
 })
 
+// column_number returns the column for types of the form column:N
+func column_number(typ string) (int, bool) {
+	if q, found := strings.CutPrefix(typ, "column:"); found {
+		if n, err := strconv.Atoi(q); err == nil && n > 0 {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// matches_first_group returns true if, for the type, only the first group in
+// the regex is matched, when it has no named groups
+func matches_first_group(typ string) bool {
+	if _, is_column := column_number(typ); is_column {
+		return true
+	}
+	return typ == "regex" || hint_type(typ) != nil
+}
+
 func functions_for(opts *Options) (pattern string, post_processors []PostProcessorFunc, group_processors []GroupProcessorFunc) {
 	switch opts.Type {
 	case "url":
@@ -256,7 +297,34 @@ func functions_for(opts *Options) (pattern string, post_processors []PostProcess
 			// IPv6 with no validation
 			`(?:[a-fA-F0-9]{0,4}:){2,7}[a-fA-F0-9]{1,4})`)
 		post_processors = append(post_processors, PostProcessorMap()["ip"])
+	case "uuid":
+		// the match is only the hex digits, so it never includes surrounding
+		// brackets, quotes or a urn:uuid: prefix and needs no post processing
+		pattern = `\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`
+	case "json":
+		pattern = `"(?:[^"\\\n]|\\.)*"`
+		post_processors = append(post_processors, PostProcessorMap()["json"])
+	case "email":
+		pattern = `[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`
+		post_processors = append(post_processors, PostProcessorMap()["email"])
 	default:
+		if n, is_column := column_number(opts.Type); is_column {
+			// whitespace separated words, that may be wrapped onto the next row
+			word, space := `(?:[^\s\x00]|\r)+`, `[ \t\x00]+`
+			pattern = fmt.Sprintf(`(?m)^[ \t\x00]*(?:%s%s){%d}(%s)`, word, space, n-1, word)
+			post_processors = append(post_processors, PostProcessorMap()["brackets"], PostProcessorMap()["quotes"])
+			break
+		}
+		if t := hint_type(opts.Type); t != nil {
+			pattern = t.Regex
+			for _, x := range t.Post_processors {
+				post_processors = append(post_processors, PostProcessorMap()[x])
+			}
+			for _, x := range t.Group_processors {
+				group_processors = append(group_processors, GroupProcessorMap()[x])
+			}
+			break
+		}
 		pattern = opts.Regex
 		if opts.Type == "linenum" {
 			if pattern == kitty.HintsDefaultRegex {
@@ -410,7 +478,7 @@ func mark(r *regexp2.Regexp, post_processors []PostProcessorFunc, group_processo
 		for k, v := range gd {
 			gd2[k] = v
 		}
-		if opts.Type == "regex" && len(m.Groups) > 1 && !m.HasNamedGroups() {
+		if matches_first_group(opts.Type) && len(m.Groups) > 1 && !m.HasNamedGroups() {
 			cp := m.Groups[1].LastCapture()
 			ms, me := cp.Byte_Offsets.Start, cp.Byte_Offsets.End
 			match_start = utils.Max(match_start, ms)
@@ -521,8 +589,36 @@ func (self *ErrNoMatches) Error() string {
 	return fmt.Sprintf("No %s found", none_of)
 }
 
+// unescapes_json returns true if the marks of the type are JSON strings,
+// either the builtin json type or types from hints.conf that use the json post
+// processor
+func unescapes_json(typ string) bool {
+	if slices.Contains(BuiltinTypes, typ) {
+		return typ == "json"
+	}
+	t := hint_type(typ)
+	return t != nil && slices.Contains(t.Post_processors, "json")
+}
+
+// unescape_json_strings replaces the text of the marks, which is the contents
+// of JSON strings, with the strings they represent
+func unescape_json_strings(marks []Mark) {
+	for i := range marks {
+		var s string
+		if json.Unmarshal([]byte(`"`+marks[i].Text+`"`), &s) == nil {
+			marks[i].Text = s
+		}
+	}
+}
+
 func find_marks(text string, opts *Options, cli_args ...string) (sanitized_text string, ans []Mark, index_map map[int]*Mark, err error) {
 	sanitized_text, hyperlinks := process_escape_codes(text)
+	// the text that is matched, which differs from the displayed text when
+	// lines are joined
+	match_text, offsets := sanitized_text, []int(nil)
+	if opts.JoinLines == "wrapped" || opts.JoinLines == "full-width" {
+		match_text, offsets = join_lines(sanitized_text, opts.JoinLines == "full-width")
+	}
 
 	run_basic_matching := func() error {
 		pattern, post_processors, group_processors := functions_for(opts)
@@ -530,13 +626,16 @@ func find_marks(text string, opts *Options, cli_args ...string) (sanitized_text
 		if err != nil {
 			return fmt.Errorf("Failed to compile the regex pattern: %#v with error: %w", pattern, err)
 		}
-		ans = mark(r, post_processors, group_processors, sanitized_text, opts)
+		ans = mark(r, post_processors, group_processors, match_text, opts)
+		if unescapes_json(opts.Type) {
+			unescape_json_strings(ans)
+		}
 		return nil
 	}
 
 	if opts.CustomizeProcessing != "" {
 		cmd := exec.Command(utils.KittyExe(), append([]string{"+runpy", "from kittens.hints.main import custom_marking; custom_marking()"}, cli_args...)...)
-		cmd.Stdin = strings.NewReader(sanitized_text)
+		cmd.Stdin = strings.NewReader(match_text)
 		stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
 		cmd.Stdout, cmd.Stderr = &stdout, &stderr
 		err = cmd.Run()
@@ -557,14 +656,15 @@ func find_marks(text string, opts *Options, cli_args ...string) (sanitized_text
 		if err != nil {
 			return "", nil, nil, fmt.Errorf("Failed to load output from custom processor %#v with error: %w", opts.CustomizeProcessing, err)
 		}
-		err = adjust_python_offsets(sanitized_text, ans)
+		err = adjust_python_offsets(match_text, ans)
 		if err != nil {
 			return "", nil, nil, fmt.Errorf("Custom processor %#v produced invalid mark output with error: %w", opts.CustomizeProcessing, err)
 		}
 	} else if opts.Type == "hyperlink" {
-		ans = hyperlinks
+		// the offsets of hyperlinks are already in the displayed text
+		ans, offsets = hyperlinks, nil
 	} else if opts.Type == "word" {
-		ans = mark_words(sanitized_text, opts)
+		ans = mark_words(match_text, opts)
 	} else {
 		err = run_basic_matching()
 		if err != nil {
@@ -575,6 +675,9 @@ process_answer:
 	if len(ans) == 0 {
 		return "", nil, nil, &ErrNoMatches{Type: opts.Type}
 	}
+	if offsets != nil {
+		map_marks_to_text(ans, offsets)
+	}
 	largest_index := ans[len(ans)-1].Index
 	offset := utils.Max(0, opts.HintsOffset)
 	index_map = make(map[int]*Mark, len(ans))
@@ -3,6 +3,7 @@
 package hints
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"kitty"
@@ -46,6 +47,10 @@ func TestHintMarking(t *testing.T) {
 		}
 		for _, m := range marks {
 			q := strings.NewReplacer("\n", "", "\r", "", "\x00", "").Replace(ptext[m.Start:m.End])
+			if opts.Type == "json" {
+				// the text of the mark is the unescaped JSON string
+				json.Unmarshal([]byte(`"`+q+`"`), &q)
+			}
 			if diff := cmp.Diff(m.Text, q); diff != "" {
 				t.Fatalf("Mark start (%d) and end (%d) dont point to correct offset in text for %#v\n%s", m.Start, m.End, text, diff)
 			}
@@ -119,6 +124,41 @@ func TestHintMarking(t *testing.T) {
 	r(`#one (two) 😍 a-1b `, `#one`, `two`, `a-1b`)
 	r("fōtiz час a\u0310b ", `fōtiz`, `час`, "a\u0310b")
 
+	reset()
+	cols = 60
+	opts.Type = "uuid"
+	r(`id: 123e4567-e89b-12d3-a456-426614174000, x`, `123e4567-e89b-12d3-a456-426614174000`)
+	r(`(123E4567-E89B-12D3-A456-426614174000)`, `123E4567-E89B-12D3-A456-426614174000`)
+	r(`{123e4567-e89b-12d3-a456-426614174000} "urn:uuid:123e4567-e89b-12d3-a456-426614174001"`, `123e4567-e89b-12d3-a456-426614174000`, `123e4567-e89b-12d3-a456-426614174001`)
+	r(`123e4567-e89b-12d3-a456-42661417400`)
+	r(`x123e4567-e89b-12d3-a456-426614174000`)
+
+	reset()
+	cols = 60
+	opts.Type = "json"
+	r(`{"name": "web-0", "ns": "prod"}`, `web-0`, `prod`)
+	r(`["a \"quoted\" b", 1, "c"]`, `a "quoted" b`, `c`)
+	r(`{"path": "C:\\dir\/f", "s": "\u00e9\n"}`, `C:\dir/f`, "é\n")
+	r(`{"key"  :  "value"}`, `value`)
+	r(`{"empty": ""}`)
+
+	reset()
+	cols = 40
+	opts.Type = "column:2"
+	r("PID  CMD\n  1 init\n 22 /usr/bin/x --y\n 3", `CMD`, `init`, `/usr/bin/x`)
+	opts.Type = "column:1"
+	r("web-0   1/1  Running\nweb-1   0/1  Pending", `web-0`, `web-1`)
+	opts.Type = "column:3"
+	r("a b\nc d e", `e`)
+
+	reset()
+	cols = 60
+	opts.Type = "email"
+	r(`Mail me@example.com.`, `me@example.com`)
+	r(`<first.last+tag@sub.example.co.uk>, x@y`, `first.last+tag@sub.example.co.uk`)
+	r(`mailto:someone@example.org`, `someone@example.org`)
+	r(`.bad@example.com bad.@example.com a@b..com`)
+
 	reset()
 	tdir := t.TempDir()
 	simple := filepath.Join(tdir, "simple.py")
@@ -0,0 +1,115 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// What the selected text refers to, shown above the action menu
+type preview struct {
+	description string
+	path        string // the local file or directory, if any
+	line        int    // the line number in the file, if any
+	is_file     bool
+}
+
+var line_number_suffix = utils.Once(func() *regexp.Regexp { return regexp.MustCompile(`:(\d+)(?::\d+)?$`) })
+
+func preview_for(m *Mark, typ, cwd string) (ans preview) {
+	text := m.Text
+	looks_like_path := typ == "path" || typ == "linenum" || strings.ContainsRune(text, '/') || strings.HasPrefix(text, "~")
+	if typ == "url" || typ == "hyperlink" || m.Is_hyperlink {
+		u, err := url.Parse(text)
+		if err != nil || u.Scheme != "file" {
+			ans.description = "URL: " + text
+			return
+		}
+		text, looks_like_path = u.Path, true
+	}
+	if !looks_like_path {
+		ans.description = "Text: " + text
+		return
+	}
+	path := text
+	if p, ok := m.Groupdict["path"].(string); ok && p != "" {
+		path = p
+		if l, ok := m.Groupdict["line"].(string); ok {
+			ans.line, _ = strconv.Atoi(l)
+		}
+	} else if sm := line_number_suffix().FindStringSubmatchIndex(path); sm != nil {
+		ans.line, _ = strconv.Atoi(path[sm[2]:sm[3]])
+		path = path[:sm[0]]
+	}
+	path = utils.Expanduser(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cwd, path)
+	}
+	switch s, err := os.Stat(path); {
+	case err == nil && s.IsDir():
+		ans.description = fmt.Sprintf("Path: %s (directory)", path)
+		ans.path = path
+	case err == nil:
+		ans.description = fmt.Sprintf("Path: %s (file)", path)
+		ans.path, ans.is_file = path, true
+		if ans.line > 0 {
+			ans.description = fmt.Sprintf("Path: %s at line %d (file)", path, ans.line)
+		}
+	case typ == "path" || typ == "linenum":
+		ans.description = fmt.Sprintf("Path: %s (does not exist)", path)
+	default:
+		ans.description = "Text: " + m.Text
+	}
+	return
+}
+
+type menu_action struct {
+	key, title string
+	apply      func(r *Result)
+}
+
+// menu_actions returns the actions available for the selected marks, they
+// change the result returned to kitty to perform the action
+func menu_actions(chosen []*Mark, pv preview, search_url string) (ans []menu_action) {
+	set_program := func(program string) func(r *Result) {
+		return func(r *Result) { r.Programs = []string{program} }
+	}
+	ans = append(ans,
+		menu_action{"o", "Open", set_program("default")},
+		menu_action{"c", "Copy to clipboard", set_program("@")},
+		menu_action{"p", "Paste into the terminal", set_program("-")},
+	)
+	if len(chosen) == 1 && pv.is_file {
+		title, line := "Open in editor", utils.Max(1, pv.line)
+		if pv.line > 0 {
+			title = fmt.Sprintf("Open in editor at line %d", pv.line)
+		}
+		ans = append(ans, menu_action{"e", title, func(r *Result) {
+			// kitty opens the file in the editor from kitty.conf in a new
+			// window, not with the programs
+			r.Programs = nil
+			r.Customize_processing = "::editor::"
+			r.Groupdicts = []map[string]any{{"path": pv.path, "line": strconv.Itoa(line)}}
+		}})
+	}
+	if search_url != "" {
+		ans = append(ans, menu_action{"s", "Search the web", func(r *Result) {
+			r.Programs, r.Type = []string{"default"}, "url"
+			r.Match = utils.Map(func(m *Mark) string {
+				return strings.ReplaceAll(search_url, "{query}", url.QueryEscape(m.Text))
+			}, chosen)
+			r.Groupdicts = make([]map[string]any, len(chosen))
+		}})
+	}
+	return
+}
@@ -0,0 +1,70 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestActionMenu(t *testing.T) {
+	cwd := t.TempDir()
+	os.Mkdir(filepath.Join(cwd, "dir"), 0o700)
+	os.WriteFile(filepath.Join(cwd, "dir", "f.go"), nil, 0o600)
+	p := func(text, typ, expected string, gd ...string) preview {
+		m := Mark{Text: text, Groupdict: map[string]any{}}
+		for i := 0; i+1 < len(gd); i += 2 {
+			m.Groupdict[gd[i]] = gd[i+1]
+		}
+		ans := preview_for(&m, typ, cwd)
+		if ans.description != expected {
+			t.Fatalf("Incorrect preview for %#v: %#v != %#v", text, ans.description, expected)
+		}
+		return ans
+	}
+	p("https://example.com", "url", "URL: https://example.com")
+	p("file://"+filepath.Join(cwd, "dir"), "url", "Path: "+filepath.Join(cwd, "dir")+" (directory)")
+	p("hello", "word", "Text: hello")
+	p("dir/nope", "regex", "Text: dir/nope")
+	p("dir/nope", "path", "Path: "+filepath.Join(cwd, "dir", "nope")+" (does not exist)")
+	pv := p("dir/f.go:12:3", "path", "Path: "+filepath.Join(cwd, "dir", "f.go")+" at line 12 (file)")
+	if !pv.is_file || pv.line != 12 {
+		t.Fatalf("Incorrect preview: %#v", pv)
+	}
+	p("whatever", "linenum", "Path: "+filepath.Join(cwd, "dir", "f.go")+" at line 7 (file)", "path", "dir/f.go", "line", "7")
+
+	chosen := []*Mark{{Text: "dir/f.go:12:3"}}
+	result := func(key string) Result {
+		for _, a := range menu_actions(chosen, pv, "https://s.com/?q={query}") {
+			if a.key == key {
+				r := Result{Match: []string{chosen[0].Text}, Type: "path", Programs: []string{"@"}}
+				a.apply(&r)
+				return r
+			}
+		}
+		t.Fatalf("No action with key: %s", key)
+		return Result{}
+	}
+	if diff := cmp.Diff([]string{"default"}, result("o").Programs); diff != "" {
+		t.Fatalf("Incorrect programs for open action:\n%s", diff)
+	}
+	r := result("e")
+	if diff := cmp.Diff([]map[string]any{{"path": filepath.Join(cwd, "dir", "f.go"), "line": "12"}}, r.Groupdicts); diff != "" || r.Customize_processing != "::editor::" || len(r.Programs) > 0 {
+		t.Fatalf("Incorrect result for editor action: %#v\n%s", r, diff)
+	}
+	r = result("s")
+	if diff := cmp.Diff([]string{"https://s.com/?q=dir%2Ff.go%3A12%3A3"}, r.Match); diff != "" || r.Type != "url" {
+		t.Fatalf("Incorrect result for search action: %#v\n%s", r, diff)
+	}
+	for _, a := range menu_actions(chosen, preview{}, "") {
+		if a.key == "e" || a.key == "s" {
+			t.Fatalf("Unavailable action present in menu: %s", a.title)
+		}
+	}
+}
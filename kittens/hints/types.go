@@ -0,0 +1,159 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+
+	"kitty/tools/cli"
+	"kitty/tools/config"
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+var BuiltinTypes = []string{"url", "regex", "path", "line", "hash", "word", "linenum", "hyperlink", "ip", "uuid", "json", "email"}
+
+var GroupProcessorMap = utils.Once(func() map[string]GroupProcessorFunc {
+	return map[string]GroupProcessorFunc{
+		"linenum": linenum_group_processor,
+	}
+})
+
+// A type of text to hint, defined in hints.conf
+type HintType struct {
+	Name             string
+	Regex            string
+	Post_processors  []string
+	Group_processors []string
+	Programs         []string
+	Linenum_action   string
+}
+
+type hint_types_parser struct {
+	types   map[string]*HintType
+	current *HintType
+}
+
+func (self *hint_types_parser) line_handler(key, val string) error {
+	if key == "type" {
+		// settings after an invalid type line must not apply to the previous type
+		self.current = nil
+		if val == "" || strings.ContainsAny(val, " \t:") {
+			return fmt.Errorf("Invalid type name: %#v", val)
+		}
+		if slices.Contains(BuiltinTypes, val) {
+			return fmt.Errorf("The builtin type %s cannot be redefined", val)
+		}
+		self.current = &HintType{Name: val}
+		self.types[val] = self.current
+		return nil
+	}
+	t := self.current
+	if t == nil {
+		return fmt.Errorf("The %s setting must come after a valid type line", key)
+	}
+	switch key {
+	case "regex":
+		if _, err := regexp2.Compile(val, regexp2.RE2); err != nil {
+			return fmt.Errorf("Invalid regex for the type %s: %w", t.Name, err)
+		}
+		t.Regex = val
+	case "post_process":
+		for _, x := range strings.Fields(val) {
+			if PostProcessorMap()[x] == nil {
+				return fmt.Errorf("Unknown post processor: %s", x)
+			}
+			t.Post_processors = append(t.Post_processors, x)
+		}
+	case "group_process":
+		for _, x := range strings.Fields(val) {
+			if GroupProcessorMap()[x] == nil {
+				return fmt.Errorf("Unknown group processor: %s", x)
+			}
+			t.Group_processors = append(t.Group_processors, x)
+		}
+	case "program":
+		t.Programs = append(t.Programs, val)
+	case "linenum_action":
+		switch val {
+		case "self", "window", "tab", "os_window", "background":
+			t.Linenum_action = val
+		default:
+			return fmt.Errorf("Unknown linenum_action: %s", val)
+		}
+	default:
+		return fmt.Errorf("Unknown setting: %s", key)
+	}
+	return nil
+}
+
+type hint_types_result struct {
+	types     map[string]*HintType
+	bad_lines []config.ConfigLine
+}
+
+func load_hint_types(paths ...string) (ans hint_types_result, err error) {
+	p := hint_types_parser{types: make(map[string]*HintType)}
+	cp := config.ConfigParser{LineHandler: p.line_handler}
+	if len(paths) > 0 {
+		err = cp.ParseFiles(paths...)
+	} else {
+		err = cp.LoadConfig("hints.conf", nil, nil)
+	}
+	return hint_types_result{types: p.types, bad_lines: cp.BadLines()}, err
+}
+
+// HintTypes returns the types defined in hints.conf in the kitty config
+// directory
+var HintTypes = utils.Once(func() hint_types_result {
+	ans, _ := load_hint_types()
+	return ans
+})
+
+func hint_type(name string) *HintType {
+	return HintTypes().types[name]
+}
+
+// check_type returns an error if name is neither a builtin type nor one
+// defined in hints.conf
+func check_type(name string) error {
+	if slices.Contains(BuiltinTypes, name) {
+		return nil
+	}
+	if strings.HasPrefix(name, "column:") {
+		if _, ok := column_number(name); !ok {
+			return fmt.Errorf("The column number in %s must be a positive integer", name)
+		}
+		return nil
+	}
+	ht := HintTypes()
+	var msg string
+	if t := ht.types[name]; t == nil {
+		msg = fmt.Sprintf("Unknown type: %s. Types other than the builtin ones (%s) must be defined in hints.conf", name, strings.Join(append(slices.Clone(BuiltinTypes), "column:N"), ", "))
+	} else if t.Regex == "" {
+		msg = fmt.Sprintf("The type %s in hints.conf does not specify a regex", name)
+	} else {
+		return nil
+	}
+	for _, bl := range ht.bad_lines {
+		msg += fmt.Sprintf("\nInvalid line %d in %s: %s (%s)", bl.Line_number, bl.Src_file, bl.Line, bl.Err)
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+func CompleteTypes(completions *cli.Completions, word string, arg_num int) {
+	cli.NamesCompleter("Builtin types", BuiltinTypes...)(completions, word, arg_num)
+	names := maps.Keys(HintTypes().types)
+	slices.Sort(names)
+	cli.NamesCompleter("Types from hints.conf", names...)(completions, word, arg_num)
+}
+
+func complete_hint_types(completions *cli.Completions, word string, arg_num int) {
+	CompleteTypes(completions, word, arg_num)
+}
@@ -0,0 +1,85 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package hints
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kitty/tools/utils"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestHintTypes(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "hints.conf")
+	os.WriteFile(conf, []byte(`
+type ticket
+regex \b([A-Z]+-[0-9]+)\b
+program @
+program -
+
+type err
+regex (?P<path>\S+\.go):(?P<line>\d+)
+post_process brackets quotes
+group_process linenum
+linenum_action tab
+
+type url
+regex x
+
+type bad
+regex (
+post_process nonesuch
+`), 0o600)
+	ht, err := load_hint_types(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := []int{}
+	for _, bl := range ht.bad_lines {
+		bad = append(bad, bl.Line_number)
+	}
+	if diff := cmp.Diff([]int{13, 14, 17, 18}, bad); diff != "" {
+		t.Fatalf("Incorrect bad lines in hints.conf:\n%s", diff)
+	}
+	if diff := cmp.Diff(&HintType{Name: "ticket", Regex: `\b([A-Z]+-[0-9]+)\b`, Programs: []string{"@", "-"}}, ht.types["ticket"]); diff != "" {
+		t.Fatalf("Incorrectly parsed type:\n%s", diff)
+	}
+
+	orig := HintTypes
+	HintTypes = func() hint_types_result { return ht }
+	defer func() { HintTypes = orig }()
+	if err := check_type("bad"); err == nil {
+		t.Fatalf("A type without a regex was accepted")
+	}
+	if err := check_type("nonesuch"); err == nil {
+		t.Fatalf("An unknown type was accepted")
+	}
+	for _, x := range []string{"url", "ticket", "err"} {
+		if err := check_type(x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := &Options{MinimumMatchLength: 3}
+	r := func(typ, text string, expected ...string) []Mark {
+		opts.Type = typ
+		_, marks, _, err := find_marks(convert_text(text, 40), opts)
+		if err != nil {
+			t.Fatalf("Marking %#v as %s failed with error: %s", text, typ, err)
+		}
+		if diff := cmp.Diff(expected, utils.Map(func(m Mark) string { return m.Text }, marks)); diff != "" {
+			t.Fatalf("Marking %#v as %s failed:\n%s", text, typ, diff)
+		}
+		return marks
+	}
+	r("ticket", "fixes ABC-12 and X-1, not abc-1", "ABC-12", "X-1")
+	marks := r("err", "see (main.go:12)", "main.go:12")
+	if diff := cmp.Diff(map[string]any{"path": "main.go", "line": "12"}, marks[0].Groupdict); diff != "" {
+		t.Fatalf("Incorrect groups for named type:\n%s", diff)
+	}
+}
@@ -153,6 +153,7 @@
 
 if TYPE_CHECKING:
     from .rc.base import ResponseType
+    from .remote_control import PreSharedKey
 
 RCResponse = Union[Dict[str, Any], None, AsyncResponse]
 
@@ -358,6 +359,13 @@ def __init__(
         if args.listen_on and self.allow_remote_control in ('y', 'socket', 'socket-only', 'password'):
             listen_fd = listen_on(args.listen_on)
             self.listening_on = args.listen_on
+        self.rc_psk: Optional['PreSharedKey'] = None
+        # messages received over a TCP socket must be sealed with the pre-shared key
+        self.rc_psk_required = False
+        if self.listening_on and opts.remote_control_psk_file:
+            from .remote_control import load_psk
+            self.rc_psk = load_psk(opts.remote_control_psk_file)
+            self.rc_psk_required = self.listening_on.startswith('tcp')
         self.child_monitor = ChildMonitor(
             self.on_child_death,
             DumpCommands(args) if args.dump_commands or args.dump_bytes else None,
@@ -574,8 +582,10 @@ def add_child(self, window: Window) -> None:
         assert window.child.pid is not None and window.child.child_fd is not None
         self.child_monitor.add_child(window.id, window.child.pid, window.child.child_fd, window.screen)
         self.window_id_map[window.id] = window
+        from .remote_control import notify_event_subscribers
+        notify_event_subscribers('open', window)
 
-    def _handle_remote_command(self, cmd: str, window: Optional[Window] = None, peer_id: int = 0) -> RCResponse:
+    def _handle_remote_command(self, cmd: str, window: Optional[Window] = None, peer_id: int = 0, in_reply_to: str = '') -> RCResponse:
         from .remote_control import is_cmd_allowed, parse_cmd
         response = None
         window = window or None
@@ -592,6 +602,12 @@ def _handle_remote_command(self, cmd: str, window: Optional[Window] = None, peer
             return response
         if not pcmd:
             return response
+        if in_reply_to:
+            # responses to this command, however late, are sealed as replies to it
+            pcmd['in_reply_to'] = in_reply_to
+        if pcmd.get('get_public_key'):
+            # the public key is not a secret, it is in the environment of every child
+            return {'ok': True, 'data': self.encryption_public_key}
         self_window: Optional[Window] = None
         if window is not None:
             self_window = window
@@ -678,7 +694,7 @@ def remote_cmd_permission_received(self, pcmd: Dict[str, Any], window_id: int, p
             if response is None:
                 send_data_to_peer(peer_id, b'')
             elif not isinstance(response, AsyncResponse):
-                send_data_to_peer(peer_id, encode_response_for_peer(response))
+                send_data_to_peer(peer_id, encode_response_for_peer(response, pcmd.get('in_reply_to', '')))
 
     def _execute_remote_command(
         self, pcmd: Dict[str, Any], window: Optional[Window] = None, peer_id: int = 0, self_window: Optional[Window] = None
@@ -745,13 +761,21 @@ def peer_message_received(self, msg_bytes: bytes, peer_id: int) -> Union[bytes,
         terminator = b'\x1b\\'
         if msg_bytes.startswith(cmd_prefix) and msg_bytes.endswith(terminator):
             cmd = msg_bytes[len(cmd_prefix):-len(terminator)].decode('utf-8')
-            response = self._handle_remote_command(cmd, peer_id=peer_id)
+            in_reply_to = ''
+            if self.rc_psk is not None or self.rc_psk_required:
+                from kitty.remote_control import unseal_peer_message
+                try:
+                    cmd, in_reply_to = unseal_peer_message(cmd, self.rc_psk, self.rc_psk_required)
+                except Exception as e:
+                    log_error(f'Ignoring remote control message as {e}')
+                    return None
+            response = self._handle_remote_command(cmd, peer_id=peer_id, in_reply_to=in_reply_to)
             if response is None:
                 return None
             if isinstance(response, AsyncResponse):
                 return True
             from kitty.remote_control import encode_response_for_peer
-            return encode_response_for_peer(response)
+            return encode_response_for_peer(response, in_reply_to)
 
         data:SingleInstanceData = json.loads(msg_bytes.decode('utf-8'))
         if isinstance(data, dict) and data.get('cmd') == 'new_instance':
@@ -831,6 +855,8 @@ def on_child_death(self, window_id: int) -> None:
         window = self.window_id_map.pop(window_id, None)
         if window is None:
             return
+        from .remote_control import notify_event_subscribers
+        notify_event_subscribers('close', window)
         with self.suppress_focus_change_events():
             for close_action in window.actions_on_close:
                 try:
@@ -230,7 +230,7 @@ wakeup_io_loop(ChildMonitor *self, bool in_signal_handler) {
 
 static void* io_loop(void *data);
 static void* talk_loop(void *data);
-static void send_response_to_peer(id_type peer_id, const char *msg, size_t msg_sz);
+static bool send_response_to_peer(id_type peer_id, const char *msg, size_t msg_sz);
 static void wakeup_talk_loop(bool);
 static bool talk_thread_started = false;
 
@@ -1767,9 +1767,9 @@ end:
     return 0;
 }
 
-static void
+static bool
 send_response_to_peer(id_type peer_id, const char *msg, size_t msg_sz) {
-    bool wakeup = false;
+    bool wakeup = false, sent = false;
     talk_mutex(lock);
     for (size_t i = 0; i < talk_data.num_peers; i++) {
         Peer *peer = talk_data.peers + i;
@@ -1787,6 +1787,7 @@ send_response_to_peer(id_type peer_id, const char *msg, size_t msg_sz) {
                     memcpy(peer->write.data + peer->write.used, msg, msg_sz);
                     peer->write.used += msg_sz;
                 }
+                sent = true;
             }
             wakeup = true;
             break;
@@ -1794,6 +1795,7 @@ send_response_to_peer(id_type peer_id, const char *msg, size_t msg_sz) {
     }
     talk_mutex(unlock);
     if (wakeup) wakeup_talk_loop(false);
+    return sent;
 }
 
 // }}}
@@ -1851,8 +1853,8 @@ send_data_to_peer(PyObject *self UNUSED, PyObject *args) {
     char * msg; Py_ssize_t sz;
     unsigned long long peer_id;
     if (!PyArg_ParseTuple(args, "Ks#", &peer_id, &msg, &sz)) return NULL;
-    send_response_to_peer(peer_id, msg, sz);
-    Py_RETURN_NONE;
+    if (send_response_to_peer(peer_id, msg, sz)) Py_RETURN_TRUE;
+    Py_RETURN_FALSE;
 }
 
 static PyObject *
@@ -449,7 +449,17 @@ static PyTypeObject AES256GCMDecrypt_Type = {
 
 // }}}
 
+static PyObject*
+secret_from_data(PyObject *self UNUSED, PyObject *args) {
+    const char *data;
+    int hash_algorithm = SHA256_HASH;
+    Py_ssize_t data_len;
+    if (!PyArg_ParseTuple(args, "y#|i", &data, &data_len, &hash_algorithm)) return NULL;
+    return hash_data_to_secret((const unsigned char*)data, data_len, hash_algorithm);
+}
+
 static PyMethodDef module_methods[] = {
+    METHODB(secret_from_data, METH_VARARGS),
     {NULL, NULL, 0, NULL}        /* Sentinel */
 };
 
@@ -1400,7 +1400,7 @@ def get_click_interval() -> float:
     pass
 
 
-def send_data_to_peer(peer_id: int, data: Union[str, bytes]) -> None:
+def send_data_to_peer(peer_id: int, data: Union[str, bytes]) -> bool:
     pass
 
 
@@ -1456,6 +1456,10 @@ SHA384_HASH: int
 SHA512_HASH: int
 
 
+class CryptoError(Exception):
+    pass
+
+
 class Secret:
     pass
 
@@ -1477,6 +1481,11 @@ class EllipticCurveKey:
     def private(self) -> Secret: ...
 
 
+def secret_from_data(
+    data: bytes, hash_algorithm: int = 0  # SHA256_HASH
+) -> Secret: pass
+
+
 class AES256GCMEncrypt:
 
     def __init__(self, key: Secret): ...
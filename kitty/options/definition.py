@@ -2832,6 +2832,19 @@
 See :ref:`rc_custom_auth` for details.
 ''')
 
+opt('remote_control_psk_file', '',
+    long_text='''
+Path to a file containing a pre-shared key used to secure remote control over
+TCP sockets, see :option:`kitty --listen-on`. When set, every remote control
+message sent over a TCP socket, in either direction, must be encrypted and
+authenticated with this key, messages that are not are ignored. Clients use the
+key via :option:`kitty @ --psk-file`. Leading and trailing whitespace in the
+file is ignored. Relative paths are resolved from the kitty configuration
+directory. See :ref:`rc_psk` for details.
+Changing this option by reloading the config is not supported.
+'''
+    )
+
 opt('allow_remote_control', 'no',
     choices=('password', 'socket-only', 'socket', 'no', 'n', 'false', 'yes', 'y', 'true'),
     long_text='''
@@ -1147,6 +1147,9 @@ def remote_control_password(self, val: str, ans: typing.Dict[str, typing.Any]) -
         for k, v in remote_control_password(val, ans["remote_control_password"]):
             ans["remote_control_password"][k] = v
 
+    def remote_control_psk_file(self, val: str, ans: typing.Dict[str, typing.Any]) -> None:
+        ans['remote_control_psk_file'] = str(val)
+
     def repaint_delay(self, val: str, ans: typing.Dict[str, typing.Any]) -> None:
         ans['repaint_delay'] = positive_int(val)
 
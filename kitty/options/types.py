@@ -408,6 +408,7 @@
  'pointer_shape_when_grabbed',
  'remember_window_size',
  'remote_control_password',
+ 'remote_control_psk_file',
  'repaint_delay',
  'resize_debounce_time',
  'resize_in_steps',
@@ -562,6 +563,7 @@ class Options:
     pointer_shape_when_dragging: choices_for_pointer_shape_when_dragging = 'beam'
     pointer_shape_when_grabbed: choices_for_pointer_shape_when_grabbed = 'arrow'
     remember_window_size: bool = True
+    remote_control_psk_file: str = ''
     repaint_delay: int = 10
     resize_debounce_time: typing.Tuple[float, float] = (0.1, 0.5)
     resize_in_steps: bool = False
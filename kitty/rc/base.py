@@ -49,6 +49,11 @@ class UnknownLayout(ValueError):
     hide_traceback = True
 
 
+class UnknownEvent(ValueError):
+
+    hide_traceback = True
+
+
 class StreamError(ValueError):
 
     hide_traceback = True
@@ -163,15 +168,18 @@ class AsyncResponder:
     def __init__(self, payload_get: PayloadGetType, window: Optional[Window]) -> None:
         self.async_id: str = payload_get('async_id', missing='')
         self.peer_id: int = payload_get('peer_id', missing=0)
+        self.in_reply_to: str = payload_get('in_reply_to', missing='')
         self.window_id: int = getattr(window, 'id', 0)
 
     def send_data(self, data: Any) -> None:
         from kitty.remote_control import send_response_to_client
-        send_response_to_client(data=data, peer_id=self.peer_id, window_id=self.window_id, async_id=self.async_id)
+        send_response_to_client(
+            data=data, peer_id=self.peer_id, window_id=self.window_id, async_id=self.async_id, in_reply_to=self.in_reply_to)
 
     def send_error(self, error: str) -> None:
         from kitty.remote_control import send_response_to_client
-        send_response_to_client(error=error, peer_id=self.peer_id, window_id=self.window_id, async_id=self.async_id)
+        send_response_to_client(
+            error=error, peer_id=self.peer_id, window_id=self.window_id, async_id=self.async_id, in_reply_to=self.in_reply_to)
 
 
 @dataclass(frozen=True)
@@ -317,6 +325,9 @@ class RemoteCommand:
     argspec = args_count = args_completion = ArgsHandling()
     field_to_option_map: Optional[Dict[str, str]] = None
     reads_streaming_data: bool = False
+    # The command responds with a stream of responses, until cancelled,
+    # instead of a single response
+    sends_streaming_data: bool = False
 
     def __init__(self) -> None:
         self.desc = self.desc or self.short_desc
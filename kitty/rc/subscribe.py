@@ -0,0 +1,68 @@
+#!/usr/bin/env python
+# License: GPLv3 Copyright: 2023, Kovid Goyal <kovid at kovidgoyal.net>
+
+from typing import TYPE_CHECKING, Optional
+
+from kitty.types import AsyncResponse
+
+from .base import ArgsType, Boss, PayloadGetType, PayloadType, RCOptions, RemoteCommand, ResponseType, UnknownEvent, Window
+
+if TYPE_CHECKING:
+    from kitty.cli_stub import SubscribeRCOptions as CLIOptions
+
+
+all_events = ('open', 'close', 'focus', 'title', 'command-finished')
+
+
+class Subscribe(RemoteCommand):
+
+    protocol_spec = __doc__ = '''
+    events/list.str: The events to subscribe to, all events if empty. Items can also be comma separated lists of events.
+    '''
+
+    short_desc = 'Subscribe to events in kitty'
+    desc = (
+        'Print out events as they happen in kitty, one JSON object per line, until interrupted.'
+        ' Every event has a :code:`type` and the :code:`window_id`, :code:`tab_id` and :code:`os_window_id`'
+        ' of the window it happened in. The event types are:'
+        ' :code:`open` and :code:`close` when a window is opened or closed,'
+        ' :code:`focus` when a window gains or loses focus, with the :code:`focused` state,'
+        ' :code:`title` when the title of a window changes, with the new :code:`title` and'
+        ' :code:`command-finished` when a command run in the shell finishes, with the :code:`exit_status`'
+        ' of the command, if known. This last requires :ref:`shell_integration`.'
+        ' When run in a kitty window without :option:`kitty @ --to`, events are sent over the terminal'
+        ' and stop when the foreground process in the window changes, for example, when this command exits.'
+    )
+    options_spec = '''\
+--events
+type=list
+A comma separated list of events to subscribe to. Can be specified multiple times.
+If not specified, all events are subscribed to. Choices: open, close, focus, title, command-finished
+'''
+    is_asynchronous = True
+    sends_streaming_data = True
+
+    def message_to_kitty(self, global_opts: RCOptions, opts: 'CLIOptions', args: ArgsType) -> PayloadType:
+        return {'events': opts.events}
+
+    def response_from_kitty(self, boss: Boss, window: Optional[Window], payload_get: PayloadGetType) -> ResponseType:
+        from kitty.remote_control import EventSubscription, event_subscriptions
+        events = set()
+        for x in payload_get('events') or ():
+            events.update(filter(None, (e.strip() for e in x.split(','))))
+        for e in events:
+            if e not in all_events:
+                raise UnknownEvent(f'Unknown event type: {e}')
+        async_id = payload_get('async_id', missing='')
+        event_subscriptions[async_id] = EventSubscription(
+            frozenset(events or all_events), payload_get('stream_id', missing=''),
+            peer_id=payload_get('peer_id', missing=0), window_id=getattr(window, 'id', 0),
+            in_reply_to=payload_get('in_reply_to', missing=''))
+        return AsyncResponse()
+
+    def cancel_async_request(self, boss: 'Boss', window: Optional['Window'], payload_get: PayloadGetType) -> None:
+        from kitty.remote_control import event_subscriptions
+        event_subscriptions.pop(payload_get('async_id', missing=''), None)
+
+
+subscribe = Subscribe()
@@ -30,10 +30,12 @@
 from .fast_data_types import (
     AES256GCMDecrypt,
     AES256GCMEncrypt,
+    CryptoError,
     EllipticCurveKey,
     get_boss,
     get_options,
     read_command_response,
+    secret_from_data,
     send_data_to_peer,
 )
 from .rc.base import NoResponse, PayloadGetter, all_command_names, command_for_name
@@ -47,8 +49,92 @@
     from .window import Window
 
 
-def encode_response_for_peer(response: Any) -> bytes:
-    return b'\x1bP@kitty-cmd' + json.dumps(response).encode('utf-8') + b'\x1b\\'
+max_clock_skew = 5 * 60 * 1e9
+
+
+def sealed_message_authenticated_data(direction: str, timestamp: int, in_reply_to: str) -> bytes:
+    # the direction is authenticated so that requests cannot be reflected back
+    # to their sender as responses and vice versa
+    return f'{direction}:{timestamp}:{in_reply_to}'.encode('utf-8')
+
+
+class PreSharedKey:
+
+    def __init__(self, key: bytes) -> None:
+        self.secret = secret_from_data(key)
+        # the IVs of recently unsealed messages and when they arrived, used to
+        # detect replayed messages
+        self.seen_ivs: Dict[bytes, int] = {}
+
+    def seal(self, data: bytes, direction: str = 'response', in_reply_to: str = '') -> Dict[str, Any]:
+        e = AES256GCMEncrypt(self.secret)
+        timestamp = time_ns()
+        e.add_authenticated_but_unencrypted_data(sealed_message_authenticated_data(direction, timestamp, in_reply_to))
+        sealed = e.add_data_to_be_encrypted(data, True)
+        ans = {'timestamp': timestamp, 'iv': encode_as_base85(e.iv), 'tag': encode_as_base85(e.tag), 'sealed': encode_as_base85(sealed)}
+        if in_reply_to:
+            ans['in_reply_to'] = in_reply_to
+        return ans
+
+    def unseal(self, msg: Dict[str, Any], direction: str = 'request') -> bytes:
+        timestamp = int(msg['timestamp'])
+        now = time_ns()
+        if abs(now - timestamp) > max_clock_skew:
+            raise ValueError(
+                f'sealed message has timestamp {(now - timestamp) / 1e9:.1f} seconds from now.'
+                ' Could be an attempt at a replay attack or an incorrect clock on a remote machine.')
+        # a message can only be replayed while its timestamp is valid
+        while self.seen_ivs:
+            iv, arrived_at = next(iter(self.seen_ivs.items()))
+            if now - arrived_at < 2 * max_clock_skew:
+                break
+            del self.seen_ivs[iv]
+        iv = base64.b85decode(msg['iv'])
+        if iv in self.seen_ivs:
+            raise ValueError('sealed message has already been received, could be an attempt at a replay attack')
+        d = AES256GCMDecrypt(self.secret, iv, base64.b85decode(msg['tag']))
+        d.add_data_to_be_authenticated_but_not_decrypted(sealed_message_authenticated_data(direction, timestamp, str(msg.get('in_reply_to', ''))))
+        try:
+            data = d.add_data_to_be_decrypted(base64.b85decode(msg['sealed']), True)
+        except CryptoError:
+            raise ValueError(f'sealed {direction} could not be authenticated, the pre-shared key is probably incorrect')
+        self.seen_ivs[iv] = now
+        return data
+
+
+def load_psk(path: str) -> Optional[PreSharedKey]:
+    try:
+        with open(resolve_custom_file(path), 'rb') as f:
+            key = f.read().strip()
+    except OSError as e:
+        log_error(f'Failed to read remote_control_psk_file with error: {e}')
+        return None
+    if not key:
+        log_error(f'The remote_control_psk_file {path} is empty')
+        return None
+    return PreSharedKey(key)
+
+
+def unseal_peer_message(serialized_msg: str, psk: Optional[PreSharedKey], required: bool) -> Tuple[str, str]:
+    # returns the message and the IV it was sealed with, responses to it must
+    # be sealed as replies to that IV
+    msg = json.loads(serialized_msg)
+    if not isinstance(msg, dict) or 'sealed' not in msg:
+        if required:
+            raise ValueError('message is not sealed with the pre-shared key')
+        return serialized_msg, ''
+    if psk is None:
+        raise ValueError('message is sealed but no usable remote_control_psk_file is configured')
+    return psk.unseal(msg).decode('utf-8'), str(msg['iv'])
+
+
+def encode_response_for_peer(response: Any, in_reply_to: str = '') -> bytes:
+    data = json.dumps(response).encode('utf-8')
+    if in_reply_to:
+        psk = get_boss().rc_psk
+        if psk is not None:
+            data = json.dumps(psk.seal(data, 'response', in_reply_to)).encode('utf-8')
+    return b'\x1bP@kitty-cmd' + data + b'\x1b\\'
 
 
 def parse_cmd(serialized_cmd: str, encryption_key: EllipticCurveKey) -> Dict[str, Any]:
@@ -59,6 +145,7 @@ def parse_cmd(serialized_cmd: str, encryption_key: EllipticCurveKey) -> Dict[str
     if not isinstance(pcmd, dict) or 'version' not in pcmd:
         return {}
     pcmd.pop('password', None)
+    pcmd.pop('in_reply_to', None)
     if 'encrypted' in pcmd:
         if pcmd.get('enc_proto', '1') != RC_ENCRYPTION_PROTOCOL_VERSION:
             log_error(f'Ignoring encrypted rc command with unsupported protocol: {pcmd.get("enc_proto")}')
@@ -195,10 +282,11 @@ def handle_cmd(
     c = command_for_name(cmd['cmd'])
     payload = cmd.get('payload') or {}
     payload['peer_id'] = peer_id
+    payload['in_reply_to'] = cmd.get('in_reply_to', '')
     async_id = str(cmd.get('async', ''))
     stream_id = str(cmd.get('stream_id', ''))
     stream = bool(cmd.get('stream', False))
-    if (stream or stream_id) and not c.reads_streaming_data:
+    if (stream or stream_id) and not (c.reads_streaming_data or c.sends_streaming_data):
         return {'ok': False, 'error': 'Streaming send of data is not supported for this command'}
     if stream_id:
         payload['stream_id'] = stream_id
@@ -244,6 +332,11 @@ def handle_cmd(
 environment variable :envvar:`KITTY_LISTEN_ON` is checked. If that is also not
 found, messages are sent to the controlling terminal for this process, i.e.
 they will only work if this process is run within a kitty window.
+Use :code:`all` to send the command to all running kitty instances of the
+current user that are listening on UNIX sockets, or :code:`glob:pattern` to
+send it to those whose socket address matches the shell glob pattern, for
+example, :code:`glob:/tmp/mykitty-*`. The response from each instance is
+output prefixed by its address.
 
 
 --password
@@ -268,12 +361,82 @@ def handle_cmd(
 to checking the environment variable :envvar:`KITTY_RC_PASSWORD`.
 
 
+--psk-file
+completion=type:file relative:conf
+A file containing the pre-shared key to use when talking to kitty over a TCP
+socket, see :opt:`remote_control_psk_file`. Leading and trailing whitespace is
+ignored. Relative paths are resolved from the kitty configuration directory.
+
+
+--psk-env
+default=KITTY_RC_PSK
+The name of an environment variable to read the pre-shared key from.
+Used if no :option:`kitty @ --psk-file` is supplied. Defaults
+to checking the environment variable :envvar:`KITTY_RC_PSK`.
+
+
 --use-password
 default=if-available
 choices=if-available,never,always
 If no password is available, kitty will usually just send the remote control command
 without a password. This option can be used to force it to :code:`always` or :code:`never` use
 the supplied password.
+
+
+--retry
+type=int
+default=0
+The number of times to retry connecting to kitty when its socket is not
+available, for example, because kitty is still starting up. The delay between
+attempts starts at :option:`kitty @ --retry-delay` and doubles after every
+attempt, up to a maximum of five seconds.
+
+
+--retry-delay
+type=float
+default=0.1
+The number of seconds to wait before the first retry when connecting to kitty
+fails, see :option:`kitty @ --retry`.
+
+
+--timeout
+type=float
+default=0
+The number of seconds to wait for a response from kitty, and for a connection
+to it to be established. The default of zero means use the default timeout of
+each command, which is usually ten seconds, or two minutes when a password is
+used, to give the user time to allow the command. Timeouts specified for
+individual commands, such as :option:`kitty @ select-window --response-timeout`,
+take precedence.
+
+
+--output-format
+default=text
+choices=text,json
+The format in which to output the response from kitty. With :code:`text` the
+data in the response is printed as text and errors are printed to STDERR. With
+:code:`json` the complete response is printed to STDOUT as a JSON object with
+the keys: :code:`ok`, :code:`data`, :code:`error` and :code:`tb` (the
+traceback). Failures that happen without a response from kitty, such as not
+being able to connect to it, are output as responses with :code:`ok` set to
+:code:`false`. In both cases, the exit code is non-zero if the command failed.
+
+
+--dry-run
+type=bool-set
+Print the commands that would be sent to kitty as pretty printed JSON, without
+contacting kitty. Commands sent in multiple chunks are printed once per chunk.
+When the command would be encrypted, the encryption is shown with placeholders
+around the unencrypted command, with the password hidden.
+
+
+--echo
+type=bool-set
+Print every message sent to kitty and every response received from it to
+STDERR, prefixed by :code:`>` and :code:`<` respectively. Useful for debugging.
+Encrypted messages are printed as sent, use :option:`kitty @ --dry-run` to see
+their contents. Responses sealed with a pre-shared key are printed after being
+unsealed, prefixed by :code:`(unsealed)`.
 '''.format, appname=appname)
 
 
@@ -435,7 +598,9 @@ def create_basic_command(name: str, payload: Any = None, no_response: bool = Fal
     return ans
 
 
-def send_response_to_client(data: Any = None, error: str = '', peer_id: int = 0, window_id: int = 0, async_id: str = '') -> None:
+def send_response_to_client(
+    data: Any = None, error: str = '', peer_id: int = 0, window_id: int = 0, async_id: str = '', in_reply_to: str = ''
+) -> None:
     if active_async_requests.pop(async_id, None) is None:
         return
     if error:
@@ -443,13 +608,60 @@ def send_response_to_client(data: Any = None, error: str = '', peer_id: int = 0,
     else:
         response = {'ok': True, 'data': data}
     if peer_id > 0:
-        send_data_to_peer(peer_id, encode_response_for_peer(response))
+        send_data_to_peer(peer_id, encode_response_for_peer(response, in_reply_to))
     elif window_id > 0:
         w = get_boss().window_id_map[window_id]
         if w is not None:
             w.send_cmd_response(response)
 
 
+class EventSubscription:
+
+    def __init__(self, events: FrozenSet[str], stream_id: str, peer_id: int = 0, window_id: int = 0, in_reply_to: str = '') -> None:
+        self.events = events
+        self.stream_id = stream_id
+        self.peer_id = peer_id
+        self.window_id = window_id
+        self.in_reply_to = in_reply_to
+        # events sent over a tty are only wanted while the subscriber is the
+        # foreground process, once it exits without cancelling the
+        # subscription, they would be read as input by the shell
+        self.foreground_process_group = -1 if peer_id > 0 else self.current_foreground_process_group()
+
+    def current_foreground_process_group(self) -> int:
+        w = get_boss().window_id_map.get(self.window_id)
+        if w is None or w.child.child_fd is None:
+            return -1
+        try:
+            return os.tcgetpgrp(w.child.child_fd)
+        except OSError:
+            return -1
+
+    def send(self, data: Dict[str, Any]) -> bool:
+        response = {'ok': True, 'stream_id': self.stream_id, 'data': data}
+        if self.peer_id > 0:
+            return send_data_to_peer(self.peer_id, encode_response_for_peer(response, self.in_reply_to))
+        w = get_boss().window_id_map.get(self.window_id)
+        if w is None or self.foreground_process_group < 0 or self.current_foreground_process_group() != self.foreground_process_group:
+            return False
+        w.send_cmd_response(response)
+        return True
+
+
+# Subscriptions to events created by the subscribe command, keyed by async_id
+event_subscriptions: Dict[str, EventSubscription] = {}
+
+
+def notify_event_subscribers(event: str, window: 'Window', **data: Any) -> None:
+    if not event_subscriptions:
+        return
+    data = {'type': event, 'window_id': window.id, 'tab_id': window.tab_id, 'os_window_id': window.os_window_id, **data}
+    for async_id, s in tuple(event_subscriptions.items()):
+        if event in s.events and not s.send(data):
+            # the client has gone away
+            event_subscriptions.pop(async_id, None)
+
+
 def get_password(opts: RCOptions) -> str:
     if opts.use_password == 'never':
         return ''
@@ -2061,6 +2061,9 @@ shell_prompt_marking(Screen *self, PyObject *data) {
             case 'C':
                 self->linebuf->line_attrs[self->cursor->y].prompt_kind = OUTPUT_START;
                 break;
+            case 'D':
+                CALLBACK("on_command_finished", "O", data);
+                break;
         }
     }
     if (global_state.debug_rendering) {
@@ -838,6 +838,8 @@ def title_updated(self) -> None:
         t = self.tabref()
         if t is not None:
             t.title_changed(self)
+        from .remote_control import notify_event_subscribers
+        notify_event_subscribers('title', self, title=self.title)
 
     def set_title(self, title: Optional[str]) -> None:
         if title:
@@ -958,6 +960,8 @@ def focus_changed(self, focused: bool) -> None:
             return
         self.is_focused = focused
         call_watchers(weakref.ref(self), 'on_focus_change', {'focused': focused})
+        from .remote_control import notify_event_subscribers
+        notify_event_subscribers('focus', self, focused=focused)
         for c in self.actions_on_focus_change:
             try:
                 c(self, focused)
@@ -983,6 +987,14 @@ def title_changed(self, new_title: Optional[str], is_base64: bool = False) -> No
         if self.override_title is None:
             self.title_updated()
 
+    def on_command_finished(self, data: str) -> None:
+        # data is the payload of OSC 133;D with an optional exit status
+        exit_status: Optional[int] = None
+        with suppress(Exception):
+            exit_status = int(data.partition(';')[2])
+        from .remote_control import notify_event_subscribers
+        notify_event_subscribers('command-finished', self, exit_status=exit_status)
+
     def icon_changed(self, new_icon: object) -> None:
         pass  # TODO: Implement this
 
@@ -82,6 +82,9 @@ def on_bell(self) -> None:
     def on_activity_since_last_focus(self) -> None:
         pass
 
+    def on_command_finished(self, data) -> None:
+        pass
+
     def on_mouse_event(self, event):
         ev = MouseEvent(**event)
         opts = get_options()
@@ -61,3 +61,29 @@ def corrupt_data(data):
         d = AES256GCMDecrypt(bob_secret, e.iv, e.tag)
         d.add_data_to_be_authenticated_but_not_decrypted(auth_data)
         self.assertRaises(CryptoError, d.add_data_to_be_decrypted, corrupt_data(ciphertext), True)
+
+    def test_pre_shared_key(self):
+        if is_rlimit_memlock_too_low():
+            self.skipTest('RLIMIT_MEMLOCK is too low')
+        import json
+        from time import time_ns
+
+        from kitty.remote_control import PreSharedKey, max_clock_skew
+        alice, bob = PreSharedKey(b'key'), PreSharedKey(b'key')
+        msg = alice.seal(b'data', 'request')
+        self.ae(bob.unseal(json.loads(json.dumps(msg))), b'data')
+        self.assertRaises(ValueError, bob.unseal, msg)  # replay
+        self.assertRaises(ValueError, PreSharedKey(b'other key').unseal, alice.seal(b'data', 'request'))
+        msg = alice.seal(b'data', 'request')
+        msg['timestamp'] = time_ns() + 2 * max_clock_skew
+        self.assertRaises(ValueError, bob.unseal, msg)
+        msg = alice.seal(b'data', 'request')
+        msg['timestamp'] += 1  # the timestamp is authenticated
+        self.assertRaises(ValueError, bob.unseal, msg)
+        # the direction and the request replied to are authenticated
+        self.assertRaises(ValueError, bob.unseal, alice.seal(b'data', 'response', 'iv'))
+        msg = alice.seal(b'data', 'response', 'iv')
+        self.ae(bob.unseal(msg, 'response'), b'data')
+        msg = alice.seal(b'data', 'response', 'iv')
+        msg['in_reply_to'] = 'other iv'
+        self.assertRaises(ValueError, bob.unseal, msg, 'response')
@@ -463,6 +463,13 @@ func GetOptionValue[T any](self *Command, name string) (ans T, err error) {
 	return
 }
 
+// OptionWasSpecified returns true if the option named name was specified on
+// the command line, rather than having its default value
+func (self *Command) OptionWasSpecified(name string) bool {
+	opt := self.option_map[name]
+	return opt != nil && len(opt.values_from_cmdline) > 0
+}
+
 func (self *Command) GetOptionValues(pointer_to_options_struct any) error {
 	val := reflect.ValueOf(pointer_to_options_struct).Elem()
 	if val.Kind() != reflect.Struct {
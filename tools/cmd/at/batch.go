@@ -0,0 +1,172 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package at
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"kitty/tools/cli"
+	"kitty/tools/rc"
+	"kitty/tools/utils/shlex"
+)
+
+var _ = fmt.Print
+
+// The number of commands sent to kitty before waiting for responses
+const batch_pipeline_depth = 64
+
+type batch_command struct {
+	lineno  int
+	cmdline string
+}
+
+// report_status prints the exit status of the command to STDERR, so that it
+// does not get mixed up with the responses printed to STDOUT
+func (self batch_command) report_status(err error) {
+	switch {
+	case err == nil:
+		fmt.Fprintf(os.Stderr, "Line %d: %s: ok\n", self.lineno, self.cmdline)
+	case errors.As(err, &json_reported_error{}):
+		// the error message is already in the JSON output
+		fmt.Fprintf(os.Stderr, "Line %d: %s: exit status %d\n", self.lineno, self.cmdline, exit_code_for_error(err))
+	default:
+		fmt.Fprintf(os.Stderr, "Line %d: %s: exit status %d: %s\n", self.lineno, self.cmdline, exit_code_for_error(err), err)
+	}
+}
+
+// parse_batch_command runs the command line through the normal command
+// parsing machinery, returning the data for the command instead of sending
+// it to kitty
+func parse_batch_command(args []string) (io_data *rc_io_data, err error) {
+	root := cli.NewRootCommand()
+	at_root_command := EntryPoint(root)
+	if at_root_command.FindSubCommand(args[0]) == nil {
+		return nil, fmt.Errorf("No command named: %s", args[0])
+	}
+	rc_command_interceptor = func(x *rc_io_data) error {
+		io_data = x
+		return nil
+	}
+	defer func() { rc_command_interceptor = nil }()
+	cmd, err := root.ParseArgs(append([]string{"kitten", "@"}, args...))
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Run == nil {
+		return nil, fmt.Errorf("Not a command that can be sent to kitty")
+	}
+	if _, err = cmd.Run(cmd, cmd.Args); err != nil {
+		return nil, err
+	}
+	if io_data == nil {
+		return nil, fmt.Errorf("Not a command that can be sent to kitty")
+	}
+	if io_data.streams_responses {
+		return nil, fmt.Errorf("Commands that stream responses cannot be used in batch mode")
+	}
+	return
+}
+
+// read_batch_commands calls callback with the arguments of every command in
+// src, or the error from splitting its command line
+func read_batch_commands(src io.Reader, callback func(batch_command, []string, error) error) error {
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := shlex.Split(line)
+		if err == nil && len(args) == 0 {
+			continue
+		}
+		if err = callback(batch_command{lineno: lineno, cmdline: line}, args, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func run_batch(path string) (num_failed int, err error) {
+	src := os.Stdin
+	if path != "-" {
+		if src, err = os.Open(path); err != nil {
+			return
+		}
+		defer src.Close()
+	}
+	client := create_client()
+	var pipeline *rc.Pipeline
+	if global_options.to_network != "" && !rc_global_opts.DryRun {
+		if pipeline, err = client.NewPipeline(batch_pipeline_depth); err != nil {
+			return
+		}
+		defer client.Close()
+	}
+	err = read_batch_commands(src, func(bc batch_command, args []string, err error) error {
+		var io_data *rc_io_data
+		if err == nil {
+			io_data, err = parse_batch_command(args)
+		}
+		if err != nil {
+			// report the failure after the output of preceding commands
+			if pipeline != nil {
+				if ferr := pipeline.Flush(); ferr != nil {
+					return ferr
+				}
+			}
+			if rc_global_opts.OutputFormat == "json" {
+				err = report_response_as_json(nil, nil, fmt.Errorf("Line %d: %s: %w", bc.lineno, bc.cmdline, err))
+			}
+			num_failed++
+			bc.report_status(err)
+			return nil
+		}
+		on_response := func(response *rc.Response, err error) {
+			if err = report_response(io_data, response, err); err != nil {
+				num_failed++
+			}
+			bc.report_status(err)
+		}
+		if rc_global_opts.DryRun {
+			if err = dry_run(io_data); err != nil {
+				num_failed++
+			}
+			bc.report_status(err)
+			return nil
+		}
+		if pipeline == nil {
+			on_response(client.Send(io_data.as_request()))
+			return nil
+		}
+		return pipeline.Send(io_data.as_request(), on_response)
+	})
+	if pipeline != nil {
+		if ferr := pipeline.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	return
+}
+
+func batch_main(path string) (int, error) {
+	if len(global_options.targets) > 0 {
+		return 1, fmt.Errorf("Batch mode cannot be used with multiple kitty instances")
+	}
+	num_failed, err := run_batch(path)
+	if err != nil {
+		return 1, err
+	}
+	if num_failed > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
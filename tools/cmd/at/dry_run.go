@@ -0,0 +1,104 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package at
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"kitty/tools/rc"
+	"kitty/tools/tty"
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// Shows an encrypted command with placeholders for the encryption
+type dry_run_encrypted_cmd struct {
+	Version   [3]int                  `json:"version"`
+	IV        string                  `json:"iv"`
+	Tag       string                  `json:"tag"`
+	Pubkey    string                  `json:"pubkey"`
+	Encrypted *utils.RemoteControlCmd `json:"encrypted"`
+}
+
+// Shows a command sealed with a pre-shared key with placeholders for the
+// sealing
+type dry_run_sealed_cmd struct {
+	Timestamp int64  `json:"timestamp"`
+	IV        string `json:"iv"`
+	Tag       string `json:"tag"`
+	Sealed    any    `json:"sealed"`
+}
+
+// dry_run_message returns what would be sent to kitty for cmd, with the
+// encryption and sealing replaced by placeholders
+func dry_run_message(cmd utils.RemoteControlCmd) any {
+	var ans any = &cmd
+	if global_options.password != "" {
+		cmd.Password = "<password>"
+		cmd.Timestamp = time.Now().UnixNano()
+		ans = &dry_run_encrypted_cmd{
+			Version: cmd.Version, IV: "<random>", Tag: "<authentication tag>", Pubkey: "<ephemeral public key>", Encrypted: &cmd}
+	}
+	if global_options.psk != nil && global_options.to_network != "" {
+		ans = &dry_run_sealed_cmd{Timestamp: time.Now().UnixNano(), IV: "<random>", Tag: "<authentication tag>", Sealed: ans}
+	}
+	return ans
+}
+
+// dry_run prints the commands that would be sent to kitty for io_data, one
+// per chunk, without contacting kitty
+func dry_run(io_data *rc_io_data) error {
+	req := io_data.as_request()
+	if wid := create_client().KittyWindowId; wid > 0 && req.Cmd.KittyWindowId == 0 {
+		req.Cmd.KittyWindowId = wid
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	for {
+		is_last := true
+		if req.MultiplePayloadGenerator != nil {
+			var err error
+			if is_last, err = req.MultiplePayloadGenerator(); err != nil {
+				if errors.Is(err, rc.ErrWaitingOnStdin) {
+					return fmt.Errorf("The remaining chunks of this command are read from the keyboard and cannot be shown in a dry run")
+				}
+				return err
+			}
+		}
+		if err := enc.Encode(dry_run_message(*req.Cmd)); err != nil {
+			return err
+		}
+		if is_last {
+			return nil
+		}
+	}
+}
+
+// Writes lines to a terminal that may be in raw mode, as it is while talking
+// to kitty over it, where a newline does not move to the start of the line
+type crlf_writer struct {
+	w io.Writer
+}
+
+func (self crlf_writer) Write(p []byte) (int, error) {
+	if _, err := self.w.Write(bytes.ReplaceAll(p, []byte{'\n'}, []byte{'\r', '\n'})); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// echo_writer returns the writer that messages are echoed to with --echo
+func echo_writer() io.Writer {
+	if tty.IsTerminal(os.Stderr.Fd()) {
+		return crlf_writer{os.Stderr}
+	}
+	return os.Stderr
+}
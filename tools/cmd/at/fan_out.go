@@ -0,0 +1,136 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package at
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"kitty/tools/rc"
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// The response from one of many kitty instances, in JSON output
+type instance_response struct {
+	To string `json:"to"`
+	*rc.Response
+}
+
+type payload_chunk struct {
+	payload any
+	stream  bool
+}
+
+// record_chunks runs the payload generator of the command to completion so
+// that the same chunks can be sent to every instance
+func record_chunks(io_data *rc_io_data) (ans []payload_chunk, err error) {
+	for {
+		is_last, err := io_data.multiple_payload_generator(io_data)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, payload_chunk{io_data.rc.Payload, io_data.rc.Stream})
+		if is_last {
+			return ans, nil
+		}
+	}
+}
+
+// for_instance returns a copy of io_data that can be sent concurrently with
+// other copies, replaying the recorded chunks, if any
+func (self *rc_io_data) for_instance(chunks []payload_chunk) *rc_io_data {
+	ans := *self
+	cmd := *self.rc
+	ans.rc = &cmd
+	if chunks != nil {
+		ans.multiple_payload_generator = func(io_data *rc_io_data) (bool, error) {
+			io_data.rc.Payload, io_data.rc.Stream = chunks[0].payload, chunks[0].stream
+			chunks = chunks[1:]
+			return len(chunks) == 0, nil
+		}
+	}
+	return &ans
+}
+
+func send_to_instance(io_data *rc_io_data, to string) (*rc.Response, error) {
+	client := create_client()
+	network, address, err := utils.ParseSocketAddress(to)
+	if err != nil {
+		return nil, err
+	}
+	client.Network, client.Address = network, address
+	if client.Password != "" {
+		// KITTY_PUBLIC_KEY is only valid for the kitty instance this
+		// process is running in
+		if client.PublicKey, err = client.FetchPublicKey(); err != nil {
+			return nil, err
+		}
+	}
+	return client.Send(io_data.as_request())
+}
+
+// send_to_all_instances sends the command to every kitty instance matched by
+// --to concurrently, and reports their responses in order
+func send_to_all_instances(io_data *rc_io_data) (exit_code int, err error) {
+	if io_data.streams_responses || io_data.on_key_event != nil {
+		return 1, fmt.Errorf("The %s command cannot be sent to multiple kitty instances", io_data.rc.Cmd)
+	}
+	var chunks []payload_chunk
+	if io_data.multiple_payload_generator != nil {
+		if chunks, err = record_chunks(io_data); err != nil {
+			return 1, err
+		}
+	}
+	targets := global_options.targets
+	responses := make([]*rc.Response, len(targets))
+	errs := make([]error, len(targets))
+	wg := sync.WaitGroup{}
+	for i, to := range targets {
+		wg.Add(1)
+		go func(i int, to string, io_data *rc_io_data) {
+			defer wg.Done()
+			responses[i], errs[i] = send_to_instance(io_data, to)
+		}(i, to, io_data.for_instance(chunks))
+	}
+	wg.Wait()
+
+	for i, to := range targets {
+		if err = report_instance_response(io_data, to, responses[i], errs[i]); err != nil && exit_code == 0 {
+			exit_code = exit_code_for_error(err)
+		}
+	}
+	return exit_code, nil
+}
+
+// report_instance_response outputs the response from the instance at to,
+// returning a non-nil error if the command failed
+func report_instance_response(io_data *rc_io_data, to string, response *rc.Response, err error) error {
+	if rc_global_opts.OutputFormat == "json" {
+		ir := instance_response{To: to, Response: as_json_response(io_data, response, err)}
+		serialized, jerr := json.Marshal(ir)
+		if jerr != nil {
+			return jerr
+		}
+		fmt.Println(string(serialized))
+		if err == nil && !ir.Ok {
+			err = fmt.Errorf("%s", ir.Error)
+		}
+		return err
+	}
+	text := ""
+	if err == nil {
+		text, err = response_as_text(io_data, response)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", to, err)
+		return err
+	}
+	if text != "" {
+		fmt.Printf("%s:\n%s\n", to, text)
+	}
+	return nil
+}
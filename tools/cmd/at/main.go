@@ -17,26 +17,26 @@ import (
 
 	"golang.org/x/sys/unix"
 
-	"kitty"
 	"kitty/tools/cli"
-	"kitty/tools/crypto"
+	"kitty/tools/rc"
 	"kitty/tools/tty"
 	"kitty/tools/tui"
 	"kitty/tools/tui/loop"
 	"kitty/tools/utils"
 	"kitty/tools/utils/shlex"
-
-	"github.com/jamesruan/go-rfc1924/base85"
 )
 
 const lowerhex = "0123456789abcdef"
 
-var ProtocolVersion [3]int = [3]int{0, 26, 0}
+var ProtocolVersion = rc.ProtocolVersion
 
 type GlobalOptions struct {
 	to_network, to_address, password string
-	to_address_is_from_env_var       bool
-	already_setup                    bool
+	psk                              []byte
+	// The instances to send commands to when --to is all or a glob
+	targets                    []string
+	to_address_is_from_env_var bool
+	already_setup              bool
 }
 
 var global_options GlobalOptions
@@ -72,31 +72,6 @@ func set_payload_string_field(io_data *rc_io_data, field, data string) {
 	payload_interface.Set(struct_in_interface) // copies struct_in_interface back to payload
 }
 
-func get_pubkey(encoded_key string) (encryption_version string, pubkey []byte, err error) {
-	if encoded_key == "" {
-		encoded_key = os.Getenv("KITTY_PUBLIC_KEY")
-		if encoded_key == "" {
-			err = fmt.Errorf("Password usage requested but KITTY_PUBLIC_KEY environment variable is not available")
-			return
-		}
-	}
-	encryption_version, encoded_key, found := strings.Cut(encoded_key, ":")
-	if !found {
-		err = fmt.Errorf("KITTY_PUBLIC_KEY environment variable does not have a : in it")
-		return
-	}
-	if encryption_version != kitty.RC_ENCRYPTION_PROTOCOL_VERSION {
-		err = fmt.Errorf("KITTY_PUBLIC_KEY has unknown version, if you are running on a remote system, update kitty on this system")
-		return
-	}
-	pubkey = make([]byte, base85.DecodedLen(len(encoded_key)))
-	n, err := base85.Decode(pubkey, []byte(encoded_key))
-	if err == nil {
-		pubkey = pubkey[:n]
-	}
-	return
-}
-
 type escaped_string string
 
 func (s escaped_string) MarshalJSON() ([]byte, error) {
@@ -138,174 +113,226 @@ func (s escaped_string) MarshalJSON() ([]byte, error) {
 	return buf, nil
 }
 
-func simple_serializer(rc *utils.RemoteControlCmd) (ans []byte, err error) {
-	return json.Marshal(rc)
-}
-
-type serializer_func func(rc *utils.RemoteControlCmd) ([]byte, error)
+type serializer_func = rc.Serializer
 
-func debug_to_log(args ...any) {
-	f, err := os.OpenFile("/tmp/kdlog", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-	if err == nil {
-		fmt.Fprintln(f, args...)
-		f.Close()
-	}
+func create_serializer(password string, encoded_pubkey string, io_data *rc_io_data) (err error) {
+	io_data.serializer, err = rc.NewSerializer(password, encoded_pubkey)
+	return
 }
 
-var serializer serializer_func = simple_serializer
+type rc_io_data struct {
+	cmd                    *cli.Command
+	rc                     *utils.RemoteControlCmd
+	serializer             serializer_func
+	on_key_event           func(lp *loop.Loop, ke *loop.KeyEvent) error
+	string_response_is_err bool
+	timeout                time.Duration
+	// timeout was specified on the command line for this command
+	explicit_timeout           bool
+	multiple_payload_generator func(io_data *rc_io_data) (bool, error)
+	// kitty responds with a stream of responses until interrupted
+	streams_responses bool
+}
 
-func create_serializer(password string, encoded_pubkey string, io_data *rc_io_data) (err error) {
-	io_data.serializer = simple_serializer
-	if password != "" {
-		encryption_version, pubkey, err := get_pubkey(encoded_pubkey)
-		if err != nil {
-			return err
-		}
-		io_data.serializer = func(rc *utils.RemoteControlCmd) (ans []byte, err error) {
-			ec, err := crypto.Encrypt_cmd(rc, global_options.password, pubkey, encryption_version)
-			if err != nil {
-				return
-			}
-			return json.Marshal(ec)
-		}
-		if io_data.timeout < 120*time.Second {
-			io_data.timeout = 120 * time.Second
-		}
-	}
-	return nil
+func seconds(x float64) time.Duration {
+	return time.Duration(x * float64(time.Second))
 }
 
-type ResponseData struct {
-	as_str    string
-	is_string bool
+func (self *rc_io_data) as_request() *rc.Request {
+	ans := rc.Request{Cmd: self.rc, Timeout: self.timeout, OnKeyEvent: self.on_key_event}
+	// timeouts specified by the user are used as is, the one for the
+	// command taking precedence over --timeout
+	switch {
+	case self.explicit_timeout:
+		ans.ExactTimeout = true
+	case rc_global_opts.Timeout > 0:
+		ans.Timeout, ans.ExactTimeout = seconds(rc_global_opts.Timeout), true
+	}
+	if self.multiple_payload_generator != nil {
+		ans.MultiplePayloadGenerator = func() (bool, error) { return self.multiple_payload_generator(self) }
+	}
+	return &ans
 }
 
-func (self *ResponseData) UnmarshalJSON(data []byte) error {
-	if bytes.HasPrefix(data, []byte("\"")) {
-		self.is_string = true
-		return json.Unmarshal(data, &self.as_str)
+func create_client() *rc.Client {
+	client := rc.Client{
+		Network: global_options.to_network, Address: global_options.to_address, Password: global_options.password, PSK: global_options.psk,
+		Retries: rc_global_opts.Retry, RetryDelay: seconds(rc_global_opts.RetryDelay), ConnectTimeout: seconds(rc_global_opts.Timeout),
 	}
-	if bytes.Equal(data, []byte("true")) {
-		self.as_str = "True"
-	} else if bytes.Equal(data, []byte("false")) {
-		self.as_str = "False"
-	} else {
-		self.as_str = string(data)
+	wid, err := strconv.Atoi(os.Getenv("KITTY_WINDOW_ID"))
+	if err == nil && wid > 0 {
+		client.KittyWindowId = uint(wid)
 	}
-	return nil
+	if rc_global_opts.Echo {
+		client.Echo = echo_writer()
+	}
+	return &client
 }
 
-type Response struct {
-	Ok        bool         `json:"ok"`
-	Data      ResponseData `json:"data,omitempty"`
-	Error     string       `json:"error,omitempty"`
-	Traceback string       `json:"tb,omitempty"`
+// When set, commands are handed to this function instead of being sent to
+// kitty, used to collect commands for batch mode
+var rc_command_interceptor func(io_data *rc_io_data) error
+
+// Used for failures that have already been output as part of a JSON response
+type json_reported_error struct {
+	error
 }
 
-type rc_io_data struct {
-	cmd                        *cli.Command
-	rc                         *utils.RemoteControlCmd
-	serializer                 serializer_func
-	on_key_event               func(lp *loop.Loop, ke *loop.KeyEvent) error
-	string_response_is_err     bool
-	timeout                    time.Duration
-	multiple_payload_generator func(io_data *rc_io_data) (bool, error)
+func (self json_reported_error) Unwrap() error { return self.error }
 
-	chunks_done bool
+// Exit codes for the ways in which talking to kitty can fail, so that scripts
+// can tell them apart from commands that kitty reports as failed
+const (
+	exit_code_connection_failed = 2
+	exit_code_timeout           = 3
+	exit_code_protocol_error    = 4
+)
+
+func exit_code_for_error(err error) int {
+	var cerr *rc.ConnectionError
+	var perr *rc.ProtocolError
+	switch {
+	case errors.As(err, &cerr):
+		return exit_code_connection_failed
+	case errors.Is(err, rc.ErrTimeout):
+		return exit_code_timeout
+	case errors.As(err, &perr):
+		return exit_code_protocol_error
+	}
+	return 1
 }
 
-func (self *rc_io_data) next_chunk() (chunk []byte, err error) {
-	if self.chunks_done {
-		return make([]byte, 0), nil
+func send_rc_command(io_data *rc_io_data) (exit_code int, err error) {
+	if rc_command_interceptor != nil {
+		return 0, rc_command_interceptor(io_data)
 	}
-	if self.multiple_payload_generator != nil {
-		is_last, err := self.multiple_payload_generator(self)
-		if err != nil {
-			return nil, err
-		}
-		if is_last {
-			self.chunks_done = true
-		}
-		return self.serializer(self.rc)
+	err = setup_global_options(io_data.cmd)
+	if err != nil {
+		return 1, err
 	}
-	self.chunks_done = true
-	return self.serializer(self.rc)
+	if rc_global_opts.DryRun {
+		return 0, dry_run(io_data)
+	}
+	if len(global_options.targets) > 0 {
+		return send_to_all_instances(io_data)
+	}
+	return send_to_kitty(io_data)
 }
 
-func get_response(do_io func(io_data *rc_io_data) ([]byte, error), io_data *rc_io_data) (ans *Response, err error) {
-	serialized_response, err := do_io(io_data)
+// send_to_kitty sends the command to the kitty instance specified by --to and
+// reports its response, returning the exit code for the command
+func send_to_kitty(io_data *rc_io_data) (exit_code int, err error) {
+	if io_data.streams_responses {
+		err = stream_responses(io_data)
+	} else {
+		response, serr := create_client().Send(io_data.as_request())
+		err = report_response(io_data, response, serr)
+	}
 	if err != nil {
-		if errors.Is(err, os.ErrDeadlineExceeded) && io_data.rc.Async != "" {
-			io_data.rc.Payload = nil
-			io_data.rc.CancelAsync = true
-			io_data.multiple_payload_generator = nil
-			io_data.rc.NoResponse = true
-			io_data.chunks_done = false
-			do_io(io_data)
-			err = fmt.Errorf("Timed out waiting for a response from kitty")
+		exit_code = exit_code_for_error(err)
+		if errors.As(err, &json_reported_error{}) {
+			err = nil
 		}
-		return
+		return exit_code, err
 	}
-	if len(serialized_response) == 0 {
-		if io_data.rc.NoResponse {
-			res := Response{Ok: true}
-			ans = &res
-			return
+	return 0, nil
+}
+
+// stream_responses prints the data from every response kitty sends, as one
+// line of JSON, until the user interrupts
+func stream_responses(io_data *rc_io_data) error {
+	line_end := "\n"
+	if global_options.to_network == "" && tty.IsTerminal(os.Stdout.Fd()) {
+		// the terminal is in raw mode while talking to kitty over it
+		line_end = "\r\n"
+	}
+	var failure error
+	buf := bytes.Buffer{}
+	err := create_client().StreamResponses(io_data.as_request(), func(response *rc.Response) error {
+		if !response.Ok {
+			failure = report_response(io_data, response, nil)
+			return failure
 		}
-		err = fmt.Errorf("Received empty response from kitty")
-		return
-	}
-	var response Response
-	err = json.Unmarshal(serialized_response, &response)
-	if err != nil {
-		err = fmt.Errorf("Invalid response received from kitty, unmarshalling error: %w", err)
-		return
+		buf.Reset()
+		if err := json.Compact(&buf, response.Data); err != nil {
+			return err
+		}
+		buf.WriteString(line_end)
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	})
+	if err != nil && failure == nil {
+		err = report_response(io_data, nil, err)
 	}
-	ans = &response
-	return
+	return err
 }
 
-func send_rc_command(io_data *rc_io_data) (err error) {
-	err = setup_global_options(io_data.cmd)
+// report_response outputs the response to a command in the format specified
+// by the user, returning a non-nil error if the command failed
+func report_response(io_data *rc_io_data, response *rc.Response, err error) error {
+	if rc_global_opts.OutputFormat == "json" {
+		return report_response_as_json(io_data, response, err)
+	}
 	if err != nil {
 		return err
 	}
-	wid, err := strconv.Atoi(os.Getenv("KITTY_WINDOW_ID"))
-	if err == nil && wid > 0 {
-		io_data.rc.KittyWindowId = uint(wid)
+	return handle_response(io_data, response)
+}
+
+func report_response_as_json(io_data *rc_io_data, response *rc.Response, err error) error {
+	response = as_json_response(io_data, response, err)
+	serialized, jerr := json.Marshal(response)
+	if jerr != nil {
+		return jerr
+	}
+	fmt.Println(string(serialized))
+	if !response.Ok {
+		if err == nil {
+			err = fmt.Errorf("%s", response.Error)
+		}
+		// keep the original error so that its exit code can be determined
+		return json_reported_error{err}
 	}
-	err = create_serializer(global_options.password, "", io_data)
+	return nil
+}
+
+// as_json_response returns the response to output for the command, with
+// failures to talk to kitty and string responses that are errors converted
+// to failed responses
+func as_json_response(io_data *rc_io_data, response *rc.Response, err error) *rc.Response {
 	if err != nil {
-		return
+		return &rc.Response{Ok: false, Error: err.Error()}
 	}
-	var response *Response
-	if global_options.to_network == "" {
-		response, err = get_response(do_tty_io, io_data)
-		if err != nil {
-			return
-		}
-	} else {
-		response, err = get_response(do_socket_io, io_data)
-		if err != nil {
-			return
+	if response.Ok && io_data.string_response_is_err {
+		if text, is_string := response.DataAsText(); is_string {
+			return &rc.Response{Ok: false, Error: text}
 		}
 	}
-	if err != nil || response == nil {
-		return err
+	return response
+}
+
+func handle_response(io_data *rc_io_data, response *rc.Response) error {
+	text, err := response_as_text(io_data, response)
+	if text != "" {
+		fmt.Println(text)
 	}
+	return err
+}
+
+// response_as_text returns the output of a successful command or the error
+// from a failed one
+func response_as_text(io_data *rc_io_data, response *rc.Response) (string, error) {
 	if !response.Ok {
 		if response.Traceback != "" {
 			fmt.Fprintln(os.Stderr, response.Traceback)
 		}
-		return fmt.Errorf("%s", response.Error)
-	}
-	if response.Data.is_string && io_data.string_response_is_err {
-		return fmt.Errorf("%s", response.Data.as_str)
+		return "", fmt.Errorf("%s", response.Error)
 	}
-	if response.Data.as_str != "" {
-		fmt.Println(strings.TrimRight(response.Data.as_str, "\n \t"))
+	text, is_string := response.DataAsText()
+	if is_string && io_data.string_response_is_err {
+		return "", fmt.Errorf("%s", text)
 	}
-	return
+	return strings.TrimRight(text, "\n \t"), nil
 }
 
 func get_password(password string, password_file string, password_env string, use_password string) (ans string, err error) {
@@ -361,6 +388,20 @@ func get_password(password string, password_file string, password_env string, us
 	return ans, nil
 }
 
+func get_psk(psk_file string, psk_env string) (ans []byte, err error) {
+	if psk_file != "" {
+		if ans, err = os.ReadFile(utils.ResolveConfPath(psk_file)); err != nil {
+			return nil, fmt.Errorf("Failed to read the pre-shared key with error: %w", err)
+		}
+	} else if psk_env != "" {
+		ans = []byte(os.Getenv(psk_env))
+	}
+	if ans = bytes.TrimSpace(ans); len(ans) == 0 {
+		ans = nil
+	}
+	return
+}
+
 var all_commands []func(*cli.Command) *cli.Command = make([]func(*cli.Command) *cli.Command, 0, 64)
 
 func register_at_cmd(f func(*cli.Command) *cli.Command) {
@@ -379,7 +420,17 @@ func setup_global_options(cmd *cli.Command) (err error) {
 		rc_global_opts.To = os.Getenv("KITTY_LISTEN_ON")
 		global_options.to_address_is_from_env_var = true
 	}
-	if rc_global_opts.To != "" {
+	switch {
+	case rc_global_opts.To == "all":
+		if global_options.targets, err = rc.DiscoverSockets(); err == nil && len(global_options.targets) == 0 {
+			err = fmt.Errorf("No running kitty instances listening on UNIX sockets were found")
+		}
+	case strings.HasPrefix(rc_global_opts.To, "glob:"):
+		pattern := rc_global_opts.To[len("glob:"):]
+		if global_options.targets, err = rc.MatchSockets(pattern); err == nil && len(global_options.targets) == 0 {
+			err = fmt.Errorf("No running kitty instances listening on UNIX sockets matching %#v were found", pattern)
+		}
+	case rc_global_opts.To != "":
 		network, address, err := utils.ParseSocketAddress(rc_global_opts.To)
 		if err != nil {
 			return err
@@ -387,6 +438,12 @@ func setup_global_options(cmd *cli.Command) (err error) {
 		global_options.to_network = network
 		global_options.to_address = address
 	}
+	if err != nil {
+		return err
+	}
+	if global_options.psk, err = get_psk(rc_global_opts.PskFile, rc_global_opts.PskEnv); err != nil {
+		return err
+	}
 	q, err := get_password(rc_global_opts.Password, rc_global_opts.PasswordFile, rc_global_opts.PasswordEnv, rc_global_opts.UsePassword)
 	global_options.password = q
 	global_options.already_setup = true
@@ -399,12 +456,20 @@ func EntryPoint(tool_root *cli.Command) *cli.Command {
 		Name:             "@",
 		Usage:            "[global options] [sub-command] [sub-command options] [sub-command args]",
 		ShortDescription: "Control kitty remotely",
-		HelpText:         "Control kitty by sending it commands. Set the allow_remote_control option in :file:`kitty.conf` for this to work. When run without any sub-commands this will start an interactive shell to control kitty.",
-		Run:              shell_main,
+		HelpText: "Control kitty by sending it commands. Set the allow_remote_control option in :file:`kitty.conf` for this to work. When run without any sub-commands this will start an interactive shell to control kitty. " +
+			"Failures are reported with distinct exit codes: 2 when connecting to kitty fails, 3 when kitty does not respond in time and 4 when kitty sends an invalid response.",
+		Run: shell_main,
 	})
 	add_rc_global_opts(at_root_command)
 
 	global_options_group := at_root_command.OptionGroups[0]
+	at_root_command.Add(cli.OptionSpec{
+		Name:      "--batch",
+		Help:      "Read commands from the specified file, one per line, and send them all to kitty over a single connection, instead of starting the interactive shell. Use - to read from STDIN. Lines are split using shell quoting rules, blank lines and lines starting with # are ignored. The output of each command is printed in order and failures are reported with their line numbers.",
+		Completer: cli.ChainCompleters(cli.NamesCompleter("Keywords", "-"), cli.FnmatchCompleter("Files", cli.CWD, "*")),
+	})
+
+	add_run_script_command(at_root_command)
 
 	for _, reg_func := range all_commands {
 		c := reg_func(at_root_command)
@@ -5,9 +5,18 @@ package at
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"kitty/tools/crypto"
+	"kitty/tools/rc"
+	"kitty/tools/rc/rctest"
 	"kitty/tools/utils"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/slices"
 )
 
 func TestEncodeJSON(t *testing.T) {
@@ -102,3 +111,235 @@ func TestRCSerialization(t *testing.T) {
 		t.Fatal("Incorrect version in encrypted command: ", ec.Version)
 	}
 }
+
+func TestBatchParsing(t *testing.T) {
+	src := strings.NewReader("# a comment\n\nls --all-env-vars\n  set-font-size 'a b'  \n")
+	seen := []string{}
+	err := read_batch_commands(src, func(bc batch_command, args []string, err error) error {
+		if err != nil {
+			return err
+		}
+		seen = append(seen, fmt.Sprintf("%d: %s", bc.lineno, strings.Join(args, "|")))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"3: ls|--all-env-vars", "4: set-font-size|a b"}, seen); diff != "" {
+		t.Fatalf("Unexpected batch commands:\n%s", diff)
+	}
+
+	io_data, err := parse_batch_command([]string{"ls", "--all-env-vars"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if io_data.rc.Cmd != "ls" || !io_data.rc.Payload.(ls_json_type).All_env_vars {
+		t.Fatalf("Batch command not parsed correctly: %#v", io_data.rc)
+	}
+	for _, bad := range [][]string{{"not-a-command"}, {"ls", "--not-an-option"}} {
+		if _, err = parse_batch_command(bad); err == nil {
+			t.Fatalf("Parsing of invalid batch command did not fail: %#v", bad)
+		}
+	}
+}
+
+func TestScripting(t *testing.T) {
+	interp := new_script_interpreter(nil)
+	run := func(script string) error {
+		return interp.run_lines(strings.Split(script, "\n"), "test.kitty-rc")
+	}
+	err := run(`# build a list
+set items '[1,"two",{"a":3}]'
+set out
+for x in $items extra
+    if $x != two
+        set out $out$x,
+    else
+        set skipped \
+            yes
+    end
+end
+set a ${items.2.a} $$x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{
+		"items": `[1,"two",{"a":3}]`, "out": `1,{"a":3},extra,`, "skipped": "yes", "x": "extra", "a": "3 $x",
+	}, interp.vars); diff != "" {
+		t.Fatalf("Unexpected variables after running script:\n%s", diff)
+	}
+	for script, expected := range map[string]string{
+		"echo $missing":                "test.kitty-rc:1: Unknown variable: missing",
+		"\nelse":                       "test.kitty-rc:2: else without a matching if",
+		"if 1\nfor x in a":             "test.kitty-rc:2: Unterminated block, missing end",
+		"end":                          "test.kitty-rc:1: end without a matching for or if",
+		"if 1 <= 2\nend":               "test.kitty-rc:1: Invalid condition: 1 <= 2",
+		"echo ${items.7}":              "test.kitty-rc:1: Invalid array index: 7",
+		"if not $skipped\nend\nexit 3": "Script exited with code: 3",
+	} {
+		if err = run(script); err == nil || err.Error() != expected {
+			t.Fatalf("Unexpected error for script %#v: %v", script, err)
+		}
+	}
+	needs_more, err := interp.feed_line("if $out")
+	if err != nil || !needs_more {
+		t.Fatalf("Start of block not recognized: %v", err)
+	}
+	if needs_more, err = interp.feed_line("set out done"); err != nil || !needs_more {
+		t.Fatalf("Inside of block not recognized: %v", err)
+	}
+	if needs_more, err = interp.feed_line("end"); err != nil || needs_more || interp.vars["out"] != "done" {
+		t.Fatalf("End of block not recognized: %v", err)
+	}
+}
+
+func TestSendToAllInstances(t *testing.T) {
+	var servers []*rctest.Server
+	for i := 0; i < 2; i++ {
+		s, err := rctest.NewServer("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		global_options.targets = append(global_options.targets, s.ListenOn)
+		servers = append(servers, s)
+	}
+	defer func() { global_options.targets = nil }()
+	send := func(args ...string) int {
+		io_data, err := parse_batch_command(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exit_code, err := send_to_all_instances(io_data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exit_code
+	}
+	if exit_code := send("send-text", "--match", "id:1", "hello"); exit_code != 0 {
+		t.Fatalf("Unexpected exit code for sending to all instances: %d", exit_code)
+	}
+	servers[1].Respond("set-font-size", &rc.Response{Ok: false, Error: "failed"})
+	if exit_code := send("set-font-size", "14"); exit_code != 1 {
+		t.Fatalf("Failure of one instance not reported: %d", exit_code)
+	}
+	for _, s := range servers {
+		var sent []string
+		for _, cmd := range s.Commands() {
+			sent = append(sent, fmt.Sprint(cmd.Cmd, " ", cmd.Payload))
+		}
+		// commands without responses can be received in any order
+		slices.Sort(sent)
+		if diff := cmp.Diff([]string{"send-text map[data:base64:aGVsbG8= match:id:1]", "set-font-size map[size:14]"}, sent); diff != "" {
+			t.Fatalf("Unexpected commands received by %s:\n%s", s.ListenOn, diff)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	cmd := utils.RemoteControlCmd{Cmd: "ls", Version: ProtocolVersion}
+	if m := dry_run_message(cmd); m.(*utils.RemoteControlCmd).Cmd != "ls" {
+		t.Fatalf("Unexpected dry run message for an unencrypted command: %#v", m)
+	}
+	global_options.password, global_options.psk, global_options.to_network = "secret", []byte("psk"), "unix"
+	defer func() { global_options.password, global_options.psk, global_options.to_network = "", nil, "" }()
+	serialized, err := json.Marshal(dry_run_message(cmd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Sealed struct {
+			Encrypted utils.RemoteControlCmd `json:"encrypted"`
+		} `json:"sealed"`
+	}
+	if err = json.Unmarshal(serialized, &m); err != nil {
+		t.Fatal(err)
+	}
+	if c := m.Sealed.Encrypted; c.Cmd != "ls" || c.Password != "<password>" || strings.Contains(string(serialized), "secret") {
+		t.Fatalf("Unexpected dry run message for an encrypted command: %s", serialized)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	s, err := rctest.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	global_options.to_network, global_options.to_address, err = utils.ParseSocketAddress(s.ListenOn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc_global_opts.OutputFormat = "json"
+	defer func() {
+		global_options.to_network, global_options.to_address, rc_global_opts.OutputFormat = "", "", ""
+	}()
+	send := func(expected_exit_code int) (ans map[string]any) {
+		io_data, err := parse_batch_command([]string{"ls"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig_stdout := os.Stdout
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = w
+		exit_code, err := send_to_kitty(io_data)
+		os.Stdout = orig_stdout
+		w.Close()
+		output, _ := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Failure was not reported as JSON: %s", err)
+		}
+		if exit_code != expected_exit_code {
+			t.Fatalf("Unexpected exit code: %d != %d", expected_exit_code, exit_code)
+		}
+		if err = json.Unmarshal(output, &ans); err != nil {
+			t.Fatalf("Output is not JSON: %#v: %s", string(output), err)
+		}
+		return
+	}
+	s.Respond("ls", &rc.Response{Ok: true, Data: json.RawMessage(`[{"id":1}]`)})
+	if diff := cmp.Diff(map[string]any{"ok": true, "data": []any{map[string]any{"id": float64(1)}}}, send(0)); diff != "" {
+		t.Fatalf("Unexpected JSON for a successful command:\n%s", diff)
+	}
+	s.Respond("ls", &rc.Response{Ok: false, Error: "failed", Traceback: "tb"})
+	if diff := cmp.Diff(map[string]any{"ok": false, "error": "failed", "tb": "tb"}, send(1)); diff != "" {
+		t.Fatalf("Unexpected JSON for a failed command:\n%s", diff)
+	}
+	s.Close()
+	if ans := send(exit_code_connection_failed); ans["ok"] != false || ans["error"] == "" || len(ans) != 2 {
+		t.Fatalf("Unexpected JSON for a failure to connect: %#v", ans)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	defer func() { rc_global_opts.Timeout = 0 }()
+	timeout := func(global_timeout float64, args ...string) (time.Duration, bool) {
+		rc_global_opts.Timeout = global_timeout
+		io_data, err := parse_batch_command(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := io_data.as_request()
+		return req.Timeout, req.ExactTimeout
+	}
+	for _, x := range []struct {
+		global_timeout float64
+		args           []string
+		expected       time.Duration
+		exact          bool
+	}{
+		{0, []string{"ls"}, 10 * time.Second, false},
+		{2, []string{"ls"}, 2 * time.Second, true},
+		{0, []string{"select-window"}, 60 * time.Second, false},
+		{2, []string{"select-window"}, 2 * time.Second, true},
+		{2, []string{"select-window", "--response-timeout", "5"}, 5 * time.Second, true},
+	} {
+		if actual, exact := timeout(x.global_timeout, x.args...); actual != x.expected || exact != x.exact {
+			t.Fatalf("Unexpected timeout for %v with --timeout=%v: %v (exact: %v) != %v (exact: %v)", x.args, x.global_timeout, actual, exact, x.expected, x.exact)
+		}
+	}
+}
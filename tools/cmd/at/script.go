@@ -0,0 +1,488 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package at
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"kitty/tools/cli"
+	"kitty/tools/cli/markup"
+	"kitty/tools/tty"
+	"kitty/tools/utils"
+	"kitty/tools/utils/shlex"
+)
+
+var _ = fmt.Print
+
+// The keywords of the scripting language, in addition to the remote control
+// commands, with their descriptions
+var script_keywords = map[string]string{
+	"set":     "Set a variable: set NAME VALUE",
+	"unset":   "Remove a variable: unset NAME",
+	"capture": "Store the response of a command in a variable: capture NAME COMMAND [ARGS]",
+	"echo":    "Print its arguments",
+	"source":  "Run the commands in the specified script file",
+	"for":     "Loop over words or the items of a JSON array: for NAME in WORDS ... end",
+	"if":      "Run commands conditionally: if [not] VALUE [== or != VALUE] ... [else ...] end",
+	"else":    "Start the commands to run when an if condition is false",
+	"end":     "End a for or if block",
+}
+
+const max_source_depth = 32
+
+var err_incomplete_script = errors.New("Unterminated block, missing end")
+
+type script_statement struct {
+	filename        string
+	lineno          int
+	words           []string
+	body, else_body []*script_statement
+}
+
+type script_error struct {
+	filename string
+	lineno   int
+	err      error
+}
+
+func (self *script_error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", self.filename, self.lineno, self.err)
+}
+
+func (self *script_error) Unwrap() error { return self.err }
+
+type script_command_failed struct {
+	name      string
+	exit_code int
+}
+
+func (self script_command_failed) Error() string {
+	return fmt.Sprintf("%s failed with exit code: %d", self.name, self.exit_code)
+}
+
+// Raised by the exit keyword to stop running the script
+type script_exit struct {
+	exit_code int
+}
+
+func (self *script_exit) Error() string {
+	return fmt.Sprintf("Script exited with code: %d", self.exit_code)
+}
+
+// parse_script converts lines of script into a tree of statements, returning
+// err_incomplete_script if a block is not terminated
+func parse_script(lines []string, filename string) (ans []*script_statement, err error) {
+	type open_block struct {
+		statement *script_statement
+		in_else   bool
+	}
+	stack := []*open_block{}
+	add := func(s *script_statement) {
+		if len(stack) == 0 {
+			ans = append(ans, s)
+		} else if top := stack[len(stack)-1]; top.in_else {
+			top.statement.else_body = append(top.statement.else_body, s)
+		} else {
+			top.statement.body = append(top.statement.body, s)
+		}
+	}
+	fail := func(lineno int, msg string, args ...any) ([]*script_statement, error) {
+		return nil, &script_error{filename, lineno, fmt.Errorf(msg, args...)}
+	}
+	for i := 0; i < len(lines); i++ {
+		lineno := i + 1
+		line := strings.TrimSpace(lines[i])
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + " " + strings.TrimSpace(lines[i])
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := shlex.Split(line)
+		if err != nil {
+			return fail(lineno, "Could not parse line: %s", err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		s := &script_statement{filename: filename, lineno: lineno, words: words}
+		switch words[0] {
+		case "for":
+			if len(words) < 3 || words[2] != "in" {
+				return fail(lineno, "for must be of the form: for NAME in WORDS")
+			}
+			add(s)
+			stack = append(stack, &open_block{statement: s})
+		case "if":
+			if len(words) < 2 {
+				return fail(lineno, "if must be followed by a condition")
+			}
+			add(s)
+			stack = append(stack, &open_block{statement: s})
+		case "else":
+			if len(stack) == 0 || stack[len(stack)-1].statement.words[0] != "if" || stack[len(stack)-1].in_else {
+				return fail(lineno, "else without a matching if")
+			}
+			stack[len(stack)-1].in_else = true
+		case "end":
+			if len(stack) == 0 {
+				return fail(lineno, "end without a matching for or if")
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			add(s)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, &script_error{filename, stack[len(stack)-1].statement.lineno, err_incomplete_script}
+	}
+	return
+}
+
+type script_interpreter struct {
+	at_root_command *cli.Command
+	vars            map[string]string
+	source_depth    int
+	// lines of an unfinished block when used interactively
+	pending []string
+}
+
+func new_script_interpreter(at_root_command *cli.Command) *script_interpreter {
+	return &script_interpreter{at_root_command: at_root_command, vars: make(map[string]string)}
+}
+
+const var_pat = `\$(?:\$|([a-zA-Z_][a-zA-Z0-9_]*|[0-9]+)|\{([a-zA-Z_][a-zA-Z0-9_]*|[0-9]+)((?:\.[^.}]+)*)\})`
+
+func json_value_as_string(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func lookup_json_path(val string, path []string) (string, error) {
+	var v any
+	if err := json.Unmarshal(utils.UnsafeStringToBytes(val), &v); err != nil {
+		return "", fmt.Errorf("Not a JSON value: %s", val)
+	}
+	for _, key := range path {
+		switch x := v.(type) {
+		case map[string]any:
+			q, found := x[key]
+			if !found {
+				return "", fmt.Errorf("No key named: %s", key)
+			}
+			v = q
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(x) {
+				return "", fmt.Errorf("Invalid array index: %s", key)
+			}
+			v = x[idx]
+		default:
+			return "", fmt.Errorf("Cannot lookup %s in a scalar value", key)
+		}
+	}
+	return json_value_as_string(v), nil
+}
+
+// expand replaces $name, ${name} and ${name.key.0} in word with the values
+// of variables, looking up keys and indices in variables that contain JSON.
+// $$ is replaced by $.
+func (self *script_interpreter) expand(word string) (ans string, err error) {
+	ans = utils.MustCompile(var_pat).ReplaceAllStringFunc(word, func(m string) string {
+		if err != nil {
+			return ""
+		}
+		if m == "$$" {
+			return "$"
+		}
+		groups := utils.MustCompile(var_pat).FindStringSubmatch(m)
+		name, path := groups[1], ""
+		if name == "" {
+			name, path = groups[2], groups[3]
+		}
+		val, found := self.vars[name]
+		if !found {
+			err = fmt.Errorf("Unknown variable: %s", name)
+			return ""
+		}
+		if path != "" {
+			val, err = lookup_json_path(val, strings.Split(path[1:], "."))
+		}
+		return val
+	})
+	return
+}
+
+func (self *script_interpreter) expand_all(words []string) (ans []string, err error) {
+	ans = make([]string, len(words))
+	for i, w := range words {
+		if ans[i], err = self.expand(w); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func is_truthy(val string) bool {
+	switch strings.TrimSpace(val) {
+	case "", "0", "false", "False", "null", "[]", "{}":
+		return false
+	}
+	return true
+}
+
+func (self *script_interpreter) evaluate_condition(words []string) (ans bool, err error) {
+	negate := len(words) > 0 && words[0] == "not"
+	if negate {
+		words = words[1:]
+	}
+	switch {
+	case len(words) == 1:
+		ans = is_truthy(words[0])
+	case len(words) == 3 && (words[1] == "==" || words[1] == "!="):
+		ans = (words[0] == words[2]) == (words[1] == "==")
+	default:
+		return false, fmt.Errorf("Invalid condition: %s", strings.Join(words, " "))
+	}
+	return ans != negate, nil
+}
+
+// capture runs the remote control command and returns the data from its
+// response
+func (self *script_interpreter) capture(args []string) (string, error) {
+	if len(global_options.targets) > 0 {
+		return "", fmt.Errorf("The output of commands cannot be captured when sending them to multiple kitty instances")
+	}
+	if rc_global_opts.DryRun {
+		return "", fmt.Errorf("The output of commands cannot be captured in a dry run")
+	}
+	io_data, err := parse_batch_command(args)
+	if err != nil {
+		return "", err
+	}
+	response, err := create_client().Send(io_data.as_request())
+	if err != nil {
+		return "", err
+	}
+	if !response.Ok {
+		return "", fmt.Errorf("%s", response.Error)
+	}
+	if len(response.Data) == 0 {
+		return "", nil
+	}
+	var v any
+	if err = response.UnmarshalData(&v); err != nil {
+		return "", err
+	}
+	if s, is_string := v.(string); is_string && io_data.string_response_is_err {
+		return "", fmt.Errorf("%s", s)
+	}
+	return json_value_as_string(v), nil
+}
+
+func (self *script_interpreter) exec_statements(statements []*script_statement) error {
+	for _, s := range statements {
+		if err := self.exec_statement(s); err != nil {
+			var se *script_error
+			var sx *script_exit
+			if errors.As(err, &se) || errors.As(err, &sx) {
+				return err
+			}
+			return &script_error{s.filename, s.lineno, err}
+		}
+	}
+	return nil
+}
+
+func (self *script_interpreter) exec_statement(s *script_statement) (err error) {
+	switch s.words[0] {
+	case "for":
+		items := []string{}
+		words, err := self.expand_all(s.words[3:])
+		if err != nil {
+			return err
+		}
+		for _, w := range words {
+			var arr []any
+			if strings.HasPrefix(w, "[") && json.Unmarshal(utils.UnsafeStringToBytes(w), &arr) == nil {
+				for _, x := range arr {
+					items = append(items, json_value_as_string(x))
+				}
+			} else {
+				items = append(items, w)
+			}
+		}
+		for _, item := range items {
+			self.vars[s.words[1]] = item
+			if err = self.exec_statements(s.body); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "if":
+		words, err := self.expand_all(s.words[1:])
+		if err != nil {
+			return err
+		}
+		cond, err := self.evaluate_condition(words)
+		if err != nil {
+			return err
+		}
+		if cond {
+			return self.exec_statements(s.body)
+		}
+		return self.exec_statements(s.else_body)
+	}
+	words, err := self.expand_all(s.words)
+	if err != nil {
+		return err
+	}
+	args := words[1:]
+	switch words[0] {
+	case "set":
+		if len(args) < 1 {
+			return fmt.Errorf("set must be of the form: set NAME VALUE")
+		}
+		self.vars[args[0]] = strings.Join(args[1:], " ")
+	case "unset":
+		for _, name := range args {
+			delete(self.vars, name)
+		}
+	case "capture":
+		if len(args) < 2 {
+			return fmt.Errorf("capture must be of the form: capture NAME COMMAND [ARGS]")
+		}
+		val, err := self.capture(args[1:])
+		if err != nil {
+			return err
+		}
+		self.vars[args[0]] = val
+	case "echo":
+		fmt.Println(strings.Join(args, " "))
+	case "source":
+		if len(args) != 1 {
+			return fmt.Errorf("source must be of the form: source FILE")
+		}
+		path := utils.Expanduser(args[0])
+		if !filepath.IsAbs(path) && s.filename != "" && s.filename != "-" && s.filename != "<shell>" {
+			path = filepath.Join(filepath.Dir(s.filename), path)
+		}
+		return self.run_file(path)
+	case "exit":
+		code := 0
+		if len(args) > 0 {
+			if code, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("Invalid exit code: %s", args[0])
+			}
+		}
+		return &script_exit{code}
+	default:
+		if exit_code := run_rc_command(self.at_root_command, words); exit_code != 0 {
+			return script_command_failed{words[0], exit_code}
+		}
+	}
+	return nil
+}
+
+func (self *script_interpreter) run_lines(lines []string, filename string) error {
+	statements, err := parse_script(lines, filename)
+	if err != nil {
+		return err
+	}
+	return self.exec_statements(statements)
+}
+
+func (self *script_interpreter) run_file(path string) (err error) {
+	if self.source_depth >= max_source_depth {
+		return fmt.Errorf("Scripts sourced too deeply, stopping at: %s", path)
+	}
+	var data []byte
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	self.source_depth++
+	defer func() { self.source_depth-- }()
+	return self.run_lines(utils.Splitlines(utils.UnsafeBytesToString(data)), path)
+}
+
+// feed_line is used by the interactive shell to run a line of script. If the
+// line starts a block, lines are accumulated until the block is finished and
+// needs_more is true.
+func (self *script_interpreter) feed_line(line string) (needs_more bool, err error) {
+	self.pending = append(self.pending, line)
+	statements, err := parse_script(self.pending, "<shell>")
+	if errors.Is(err, err_incomplete_script) {
+		return true, nil
+	}
+	self.pending = nil
+	if err != nil {
+		return false, err
+	}
+	return false, self.exec_statements(statements)
+}
+
+func run_script_main(cmd *cli.Command, args []string) (int, error) {
+	if rc_command_interceptor != nil {
+		return 1, fmt.Errorf("Scripts cannot be run in batch mode")
+	}
+	if len(args) < 1 {
+		return 1, fmt.Errorf("Must specify the path to a script file")
+	}
+	if err := setup_global_options(cmd); err != nil {
+		return 1, err
+	}
+	formatter = markup.New(tty.IsTerminal(os.Stdout.Fd()))
+	interp := new_script_interpreter(cmd.Parent)
+	serialized_args, _ := json.Marshal(args[1:])
+	interp.vars["args"] = string(serialized_args)
+	for i, arg := range args {
+		interp.vars[strconv.Itoa(i)] = arg
+	}
+	if err := interp.run_file(args[0]); err != nil {
+		var sx *script_exit
+		if errors.As(err, &sx) {
+			return sx.exit_code, nil
+		}
+		return 1, err
+	}
+	return 0, nil
+}
+
+func add_run_script_command(at_root_command *cli.Command) {
+	at_root_command.AddSubCommand(&cli.Command{
+		Name:             "run-script",
+		Usage:            "SCRIPT_FILE [ARGS...]",
+		ShortDescription: "Run a script of remote control commands",
+		HelpText: "Run a script of remote control commands, conventionally stored in a file with the extension :file:`.kitty-rc`. Use - to read the script from STDIN. " +
+			"Every line of the script is a remote control command, exactly as it would be typed in the kitty shell, or one of the scripting keywords: " +
+			"set, unset, capture, echo, source, for, if, else, end and exit. Lines starting with # are comments.\n\n" +
+			"Variables are set with :code:`set name value` and used as :code:`$name` or :code:`${name}`. " +
+			"The response of a command can be stored in a variable with :code:`capture name command args`, " +
+			"for example, :code:`capture windows ls`. Keys and indices in variables containing JSON can be used as :code:`${windows.0.tabs.0.id}`. " +
+			"The script arguments are available as :code:`$1`, :code:`$2`, etc. and as a JSON array in :code:`$args`.\n\n" +
+			"Blocks of commands are run repeatedly with :code:`for name in words...` or conditionally with " +
+			":code:`if value`, :code:`if value == other` or :code:`if not value`, optionally followed by :code:`else`, and are terminated by :code:`end`. " +
+			"A word containing a JSON array is expanded into its items in a for loop. " +
+			"The script stops at the first command that fails.",
+		Run:                   run_script_main,
+		AllowOptionsAfterArgs: 1,
+		ArgCompleter:          cli.ChainCompleters(cli.FnmatchCompleter("Scripts", cli.CWD, "*.kitty-rc"), cli.FnmatchCompleter("Files", cli.CWD, "*")),
+		StopCompletingAtArg:   1,
+	})
+}
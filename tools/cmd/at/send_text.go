@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
+	"kitty/tools/rc"
 	"kitty/tools/tty"
 	"kitty/tools/tui/loop"
 	"kitty/tools/utils"
@@ -14,9 +15,6 @@ import (
 	"strings"
 )
 
-var end_reading_from_stdin = errors.New("end reading from STDIN")
-var waiting_on_stdin = errors.New("wait for key events from STDIN")
-
 func make_file_gen(f *os.File) func(*rc_io_data) (bool, error) {
 	chunk := make([]byte, 2048)
 	file_gen := func(io_data *rc_io_data) (bool, error) {
@@ -66,7 +64,7 @@ func parse_send_text(io_data *rc_io_data, args []string) error {
 			io_data.on_key_event = func(lp *loop.Loop, ke *loop.KeyEvent) error {
 				ke.Handled = true
 				if ke.MatchesPressOrRepeat("ctrl+d") {
-					return end_reading_from_stdin
+					return rc.ErrEndReadingFromStdin
 				}
 				bs := "kitty-key:" + base64.StdEncoding.EncodeToString([]byte(ke.AsCSI()))
 				pending_key_events = append(pending_key_events, bs)
@@ -87,7 +85,7 @@ func parse_send_text(io_data *rc_io_data, args []string) error {
 					pending_key_events = pending_key_events[1:]
 					return false, nil
 				}
-				return false, waiting_on_stdin
+				return false, rc.ErrWaitingOnStdin
 			}
 			generators = append(generators, key_gen)
 		} else {
@@ -11,6 +11,8 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/exp/maps"
+
 	"kitty/tools/cli"
 	"kitty/tools/cli/markup"
 	"kitty/tools/tui/loop"
@@ -24,6 +26,8 @@ var _ = fmt.Print
 var formatter *markup.Context
 
 const prompt = "🐱 "
+const continuation_prompt = "… "
+const exit_help = "Exit this shell, with the specified exit code, if any: exit [CODE]"
 
 var ErrExec = errors.New("Execute command")
 
@@ -112,63 +116,99 @@ func show_basic_help() {
 	fmt.Fprintln(&output, " ", formatter.Green("help"))
 	fmt.Fprintln(&output, "   ", "Show this help")
 	fmt.Fprintln(&output, " ", formatter.Green("exit"))
-	fmt.Fprintln(&output, "   ", "Exit this shell")
+	fmt.Fprintln(&output, "   ", exit_help)
+	fmt.Fprintln(&output)
+	fmt.Fprintln(&output, formatter.Title("Scripting")+":")
+	for _, k := range utils.StableSort(maps.Keys(script_keywords), func(a, b string) bool { return a < b }) {
+		fmt.Fprintln(&output, " ", formatter.Green(k))
+		fmt.Fprintln(&output, "   ", script_keywords[k])
+	}
 	cli.ShowHelpInPager(output.String())
 }
 
-func exec_command(at_root_command *cli.Command, rl *readline.Readline, cmdline string) bool {
+// run_rc_command runs a single remote control command, as typed in the
+// shell, returning its exit code
+func run_rc_command(at_root_command *cli.Command, args []string) (exit_code int) {
+	if at_root_command.FindSubCommand(args[0]) == nil {
+		fmt.Fprintln(os.Stderr, "No command named", formatter.BrightRed(args[0])+". Type help for a list of commands")
+		return 1
+	}
+	cmdline := []string{"kitten", "@"}
+	cmdline = append(cmdline, args...)
+	root := cli.NewRootCommand()
+	EntryPoint(root)
+	return root.ExecArgs(cmdline)
+}
+
+// exec_command runs the command line, returning false and the exit code of
+// the shell if the shell should exit
+func exec_command(at_root_command *cli.Command, rl *readline.Readline, interp *script_interpreter, cmdline string) (bool, int) {
 	parsed_cmdline, err := shlex.Split(cmdline)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Could not parse cmdline:", err)
-		return true
+		return true, 0
 	}
-	if len(parsed_cmdline) == 0 {
-		return true
+	if len(parsed_cmdline) == 0 && len(interp.pending) == 0 {
+		return true, 0
 	}
 	cwd, _ := os.Getwd()
 	hi := readline.HistoryItem{Timestamp: time.Now(), Cmd: rl.AllText(), ExitCode: -1, Cwd: cwd}
+	if len(interp.pending) > 0 {
+		// inside a block, every line is part of the script
+		parsed_cmdline = []string{""}
+	}
 	switch parsed_cmdline[0] {
-	case "exit":
-		hi.ExitCode = 0
-		rl.AddHistoryItem(hi)
-		return false
 	case "help":
 		hi.ExitCode = 0
 		defer rl.AddHistoryItem(hi)
 		if len(parsed_cmdline) == 1 {
 			show_basic_help()
-			return true
+			return true, 0
 		}
 		switch parsed_cmdline[1] {
 		case "exit":
-			fmt.Println("Exit this shell")
+			fmt.Println(exit_help)
 		case "help":
 			fmt.Println("Show help")
 		default:
 			sc := at_root_command.FindSubCommand(parsed_cmdline[1])
-			if sc == nil {
+			if desc, is_keyword := script_keywords[parsed_cmdline[1]]; is_keyword {
+				fmt.Println(desc)
+			} else if sc == nil {
 				hi.ExitCode = 1
 				fmt.Fprintln(os.Stderr, "No command named", formatter.BrightRed(parsed_cmdline[1])+". Type help for a list of commands")
 			} else {
 				sc.ShowHelpWithCommandString(sc.Name)
 			}
 		}
-		return true
+		return true, 0
 	default:
-		if at_root_command.FindSubCommand(parsed_cmdline[0]) == nil {
+		// exit is handled by the script interpreter, so that it behaves
+		// the same as in scripts
+		defer rl.AddHistoryItem(hi)
+		needs_more, err := interp.feed_line(cmdline)
+		hi.Duration = time.Now().Sub(hi.Timestamp)
+		if needs_more {
+			hi.ExitCode = 0
+			rl.SetPrompt(continuation_prompt)
+			return true, 0
+		}
+		rl.SetPrompt(prompt)
+		hi.ExitCode = 0
+		if err != nil {
+			var sx *script_exit
+			if errors.As(err, &sx) {
+				hi.ExitCode = sx.exit_code
+				return false, sx.exit_code
+			}
 			hi.ExitCode = 1
-			fmt.Fprintln(os.Stderr, "No command named", formatter.BrightRed(parsed_cmdline[0])+". Type help for a list of commands")
-			return true
+			// failed commands have already reported their errors
+			if !errors.As(err, &script_command_failed{}) {
+				fmt.Fprintln(os.Stderr, err)
+			}
 		}
-		cmdline := []string{"kitten", "@"}
-		cmdline = append(cmdline, parsed_cmdline...)
-		root := cli.NewRootCommand()
-		EntryPoint(root)
-		hi.ExitCode = root.ExecArgs(cmdline)
-		hi.Duration = time.Now().Sub(hi.Timestamp)
-		rl.AddHistoryItem(hi)
 	}
-	return true
+	return true, 0
 }
 
 func completions(before_cursor, after_cursor string) (ans *cli.Completions) {
@@ -192,6 +232,10 @@ func completions(before_cursor, after_cursor string) (ans *cli.Completions) {
 	}
 	add_sc("help", "Show help")
 	add_sc("exit", "Exit the kitty shell")
+	for k, desc := range script_keywords {
+		add_sc(k, desc)
+	}
+	a.FindSubCommand("source").ArgCompleter = cli.FnmatchCompleter("Files", cli.CWD, "*")
 	root.Validate()
 	ans = root.GetCompletions(argv, nil)
 	ans.CurrentWordIdx = position_of_last_arg - len(prefix)
@@ -203,6 +247,9 @@ func shell_main(cmd *cli.Command, args []string) (int, error) {
 	if err != nil {
 		return 1, err
 	}
+	if batch, _ := cli.GetOptionValue[string](cmd, "Batch"); batch != "" {
+		return batch_main(batch)
+	}
 	formatter = markup.New(true)
 	fmt.Println("Welcome to the kitty shell!")
 	fmt.Println("Use", formatter.Green("help"), "for assistance or", formatter.Green("exit"), "to quit.")
@@ -229,14 +276,15 @@ func shell_main(cmd *cli.Command, args []string) (int, error) {
 	defer func() {
 		rl.Shutdown()
 	}()
+	interp := new_script_interpreter(cmd)
 	for {
 		rc, err := shell_loop(rl, true)
 		if err != nil {
 			if err == ErrExec {
 				cmdline := rl.AllText()
 				cmdline = strings.ReplaceAll(cmdline, "\\\n", "")
-				if !exec_command(cmd, rl, cmdline) {
-					return 0, nil
+				if keep_going, exit_code := exec_command(cmd, rl, interp, cmdline); !keep_going {
+					return exit_code, nil
 				}
 				continue
 			}
@@ -82,15 +82,16 @@ func run_CMD_NAME(cmd *cli.Command, args []string) (return_code int, err error)
 		cmd:                    cmd,
 		rc:                     rc,
 		timeout:                time.Duration(timeout * float64(time.Second)),
+		explicit_timeout:       cmd.OptionWasSpecified("ResponseTimeout"),
 		string_response_is_err: STRING_RESPONSE_IS_ERROR,
+		streams_responses:      RESPONSE_STREAM_WANTED,
 	}
 	err = create_payload_CMD_NAME(&io_data, cmd, args)
 	if err != nil {
 		return
 	}
 
-	err = send_rc_command(&io_data)
-	return
+	return send_rc_command(&io_data)
 }
 
 func setup_CMD_NAME(parent *cli.Command) *cli.Command {
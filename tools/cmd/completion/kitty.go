@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"kitty/kittens/hints"
 	"kitty/tools/cli"
 	"kitty/tools/themes"
 )
@@ -66,6 +67,10 @@ func complete_themes(completions *cli.Completions, word string, arg_num int) {
 	themes.CompleteThemes(completions, word, arg_num)
 }
 
+func complete_hint_types(completions *cli.Completions, word string, arg_num int) {
+	hints.CompleteTypes(completions, word, arg_num)
+}
+
 func EntryPoint(tool_root *cli.Command) {
 	tool_root.AddSubCommand(&cli.Command{
 		Name: "__complete__", Hidden: true,
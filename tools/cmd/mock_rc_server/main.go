@@ -0,0 +1,168 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package mock_rc_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"kitty/tools/cli"
+	"kitty/tools/rc"
+	"kitty/tools/rc/rctest"
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+type Options struct {
+	ListenOn  string
+	Password  string
+	PskFile   string
+	Responses string
+	Output    string
+	EnvFile   string
+}
+
+// read_responses reads a JSON object mapping command names to either a
+// single response or a list of responses
+func read_responses(path string) (ans map[string][]*rc.Response, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var raw map[string]json.RawMessage
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("The responses file %s is not a JSON object: %w", path, err)
+	}
+	ans = make(map[string][]*rc.Response, len(raw))
+	for cmd, val := range raw {
+		var responses []*rc.Response
+		if err = json.Unmarshal(val, &responses); err != nil {
+			var r rc.Response
+			if err = json.Unmarshal(val, &r); err != nil {
+				return nil, fmt.Errorf("The response for %s in %s is invalid: %w", cmd, path, err)
+			}
+			responses = append(responses, &r)
+		}
+		ans[cmd] = responses
+	}
+	return
+}
+
+func run_server(opts *Options) (err error) {
+	output := os.Stdout
+	if opts.Output != "-" {
+		if output, err = os.Create(opts.Output); err != nil {
+			return
+		}
+		defer output.Close()
+	}
+	server, err := rctest.NewServer(opts.ListenOn)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	server.Password = opts.Password
+	if opts.PskFile != "" {
+		psk, err := os.ReadFile(opts.PskFile)
+		if err != nil {
+			return err
+		}
+		server.PSK = bytes.TrimSpace(psk)
+	}
+	if opts.Responses != "" {
+		responses, err := read_responses(opts.Responses)
+		if err != nil {
+			return err
+		}
+		for cmd, r := range responses {
+			server.Respond(cmd, r...)
+		}
+	}
+	output_lock := sync.Mutex{}
+	server.OnCommand = func(cmd *rctest.Command) {
+		line, err := json.Marshal(cmd)
+		if err != nil {
+			return
+		}
+		output_lock.Lock()
+		defer output_lock.Unlock()
+		output.Write(append(line, '\n'))
+	}
+	env := fmt.Sprintf("export KITTY_LISTEN_ON=%s\nexport KITTY_PUBLIC_KEY=%s\n", utils.QuoteStringForSH(server.ListenOn), utils.QuoteStringForSH(server.PublicKey))
+	if opts.EnvFile != "" {
+		// written atomically so that its existence means the server is ready
+		if err = utils.AtomicUpdateFile(opts.EnvFile, []byte(env), 0o644); err != nil {
+			return
+		}
+	} else {
+		os.Stderr.WriteString(env)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGINT, unix.SIGTERM)
+	<-signals
+	return
+}
+
+func EntryPoint(root *cli.Command) *cli.Command {
+	sc := root.AddSubCommand(&cli.Command{
+		Name:             "__mock_rc_server__",
+		Usage:            "[options]",
+		ShortDescription: "Run a mock kitty remote control server",
+		HelpText: "Run a server that behaves like a kitty instance receiving remote control commands, for testing programs that control kitty. " +
+			"Every command received is printed as a line of JSON, decrypted if it was sent with a password. " +
+			"Commands are answered with the responses from the :option:`--responses` file, or with a plain success response. " +
+			"The address of the server and its public key are printed to STDERR, or written to the :option:`--env-file`, " +
+			"as shell commands setting the :envvar:`KITTY_LISTEN_ON` and :envvar:`KITTY_PUBLIC_KEY` environment variables. The server runs until interrupted.",
+		Hidden: true,
+		Run: func(cmd *cli.Command, args []string) (ret int, err error) {
+			if len(args) != 0 {
+				return 1, fmt.Errorf("No command line arguments are allowed")
+			}
+			opts := &Options{}
+			if err = cmd.GetOptionValues(opts); err != nil {
+				return 1, err
+			}
+			if err = run_server(opts); err != nil {
+				return 1, err
+			}
+			return 0, nil
+		},
+	})
+	sc.Add(cli.OptionSpec{
+		Name: "--listen-on",
+		Help: "The address to listen on, in the same form as :option:`kitty --listen-on`, for example, :code:`unix:/tmp/mock` or :code:`tcp:localhost:0`. Defaults to a UNIX socket in a temporary directory.",
+	})
+	sc.Add(cli.OptionSpec{
+		Name: "--password",
+		Help: "Refuse commands that are not sent with this password.",
+	})
+	sc.Add(cli.OptionSpec{
+		Name:      "--psk-file",
+		Help:      "A file containing a pre-shared key. Messages must be sealed with it, like kitty does when :opt:`remote_control_psk_file` is set.",
+		Completer: cli.FnmatchCompleter("Files", cli.CWD, "*"),
+	})
+	sc.Add(cli.OptionSpec{
+		Name:      "--responses",
+		Help:      "A JSON file mapping command names to the response to send for them, for example: :code:`{\"ls\": {\"ok\": true, \"data\": []}}`. A list of responses can be used to send different responses to successive commands, the last one being repeated.",
+		Completer: cli.FnmatchCompleter("JSON files", cli.CWD, "*.json"),
+	})
+	sc.Add(cli.OptionSpec{
+		Name:      "--output",
+		Default:   "-",
+		Help:      "The file to write the received commands to. Defaults to STDOUT.",
+		Completer: cli.FnmatchCompleter("Files", cli.CWD, "*"),
+	})
+	sc.Add(cli.OptionSpec{
+		Name:      "--env-file",
+		Help:      "Write the address and public key of the server to this file instead of STDERR, once the server is ready.",
+		Completer: cli.FnmatchCompleter("Files", cli.CWD, "*"),
+	})
+	return sc
+}
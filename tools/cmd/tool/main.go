@@ -18,6 +18,7 @@ import (
 	"kitty/tools/cli"
 	"kitty/tools/cmd/at"
 	"kitty/tools/cmd/edit_in_kitty"
+	"kitty/tools/cmd/mock_rc_server"
 	"kitty/tools/cmd/pytest"
 	"kitty/tools/cmd/update_self"
 	"kitty/tools/tui"
@@ -57,6 +58,8 @@ func KittyToolEntryPoints(root *cli.Command) {
 	themes.ParseEntryPoint(root)
 	// __pytest__
 	pytest.EntryPoint(root)
+	// __mock_rc_server__
+	mock_rc_server.EntryPoint(root)
 	// __hold_till_enter__
 	root.AddSubCommand(&cli.Command{
 		Name:            "__hold_till_enter__",
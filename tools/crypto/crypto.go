@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"github.com/jamesruan/go-rfc1924/base85"
 	"kitty/tools/utils"
+	"strconv"
 	"time"
 )
 
@@ -82,6 +83,23 @@ func encrypt(plaintext []byte, alice_public_key []byte, encryption_protocol stri
 	return
 }
 
+func decrypt(ciphertext, iv, tag, private_key, bob_public_key []byte) (plaintext []byte, err error) {
+	shared_secret_raw, err := curve25519_derive_shared_secret(private_key, bob_public_key)
+	if err != nil {
+		return
+	}
+	shared_secret_hashed := sha256.Sum256(shared_secret_raw)
+	block, err := aes.NewCipher(shared_secret_hashed[:])
+	if err != nil {
+		return
+	}
+	aesgcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return
+	}
+	return aesgcm.Open(nil, iv, append(ciphertext, tag...), nil)
+}
+
 func KeyPair(encryption_protocol string) (private_key []byte, public_key []byte, err error) {
 	switch encryption_protocol {
 	case "1":
@@ -119,4 +137,111 @@ func Encrypt_cmd(cmd *utils.RemoteControlCmd, password string, other_pubkey []by
 	return
 }
 
+// Decrypt_cmd decrypts a command encrypted by Encrypt_cmd with the public key
+// corresponding to private_key. Commands with a timestamp more than five
+// minutes from now are rejected, to prevent replay attacks.
+func Decrypt_cmd(encrypted_cmd *utils.EncryptedRemoteControlCmd, private_key []byte) (cmd utils.RemoteControlCmd, err error) {
+	if encrypted_cmd.EncProto != "" && encrypted_cmd.EncProto != "1" {
+		err = fmt.Errorf("Unknown encryption protocol: %s", encrypted_cmd.EncProto)
+		return
+	}
+	var iv, tag, pubkey, ciphertext []byte
+	for _, x := range []struct {
+		dest *[]byte
+		src  string
+	}{{&iv, encrypted_cmd.IV}, {&tag, encrypted_cmd.Tag}, {&pubkey, encrypted_cmd.Pubkey}, {&ciphertext, encrypted_cmd.Encrypted}} {
+		if *x.dest, err = b85_decode(x.src); err != nil {
+			return
+		}
+	}
+	plaintext, err := decrypt(ciphertext, iv, tag, private_key, pubkey)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(plaintext, &cmd); err != nil {
+		return
+	}
+	delta := time.Duration(time.Now().UnixNano() - cmd.Timestamp)
+	if delta > MaxClockSkew || delta < -MaxClockSkew {
+		err = fmt.Errorf("Encrypted command has a timestamp %s from now", delta)
+	}
+	return
+}
+
+// Messages with timestamps further than this from the current time are
+// rejected
+const MaxClockSkew = 5 * time.Minute
+
+// The directions a sealed message can be sent in. The direction is
+// authenticated, so that a request cannot be reflected back to its sender as
+// a response and vice versa.
+const (
+	SealedRequest  = "request"
+	SealedResponse = "response"
+)
+
+func sealed_message_authenticated_data(msg *utils.SealedRemoteControlMessage, direction string) []byte {
+	return []byte(direction + ":" + strconv.FormatInt(msg.Timestamp, 10) + ":" + msg.InReplyTo)
+}
+
+func psk_cipher(psk []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(psk)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts and authenticates data with the pre-shared key psk. The
+// current time is included so that the message can only be used for a limited
+// time. Responses must specify the IV of the sealed request they are for as
+// in_reply_to.
+func Seal(data []byte, psk []byte, direction, in_reply_to string) (ans utils.SealedRemoteControlMessage, err error) {
+	aesgcm, err := psk_cipher(psk)
+	if err != nil {
+		return
+	}
+	iv := make([]byte, aesgcm.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return
+	}
+	ans.Timestamp, ans.InReplyTo = time.Now().UnixNano(), in_reply_to
+	output := aesgcm.Seal(nil, iv, data, sealed_message_authenticated_data(&ans, direction))
+	ans.IV, ans.Tag, ans.Sealed = b85_encode(iv), b85_encode(output[len(output)-16:]), b85_encode(output[:len(output)-16])
+	return
+}
+
+// Unseal returns the data in a message sealed with the pre-shared key psk,
+// failing if the message was tampered with, was not sent in the specified
+// direction or has a timestamp more than five minutes from now. Checking
+// msg.InReplyTo for responses is the responsibility of the caller.
+func Unseal(msg *utils.SealedRemoteControlMessage, psk []byte, direction string) (data []byte, err error) {
+	delta := time.Duration(time.Now().UnixNano() - msg.Timestamp)
+	if delta > MaxClockSkew || delta < -MaxClockSkew {
+		return nil, fmt.Errorf("Sealed message has a timestamp %s from now", delta)
+	}
+	var iv, tag, ciphertext []byte
+	for _, x := range []struct {
+		dest *[]byte
+		src  string
+	}{{&iv, msg.IV}, {&tag, msg.Tag}, {&ciphertext, msg.Sealed}} {
+		if *x.dest, err = b85_decode(x.src); err != nil {
+			return
+		}
+	}
+	aesgcm, err := psk_cipher(psk)
+	if err != nil {
+		return
+	}
+	if len(iv) != aesgcm.NonceSize() {
+		return nil, fmt.Errorf("Sealed message has an IV of incorrect size: %d", len(iv))
+	}
+	data, err = aesgcm.Open(nil, iv, append(ciphertext, tag...), sealed_message_authenticated_data(msg, direction))
+	if err != nil {
+		err = fmt.Errorf("Sealed %s could not be authenticated, the pre-shared key is probably incorrect", direction)
+	}
+	return
+}
+
 // }}}
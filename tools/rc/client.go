@@ -0,0 +1,363 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"kitty/tools/tui/loop"
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+const DefaultTimeout = 10 * time.Second
+
+// Commands sent with a password may need to wait for the user to allow them
+const PasswordTimeout = 120 * time.Second
+
+type Request struct {
+	Cmd *utils.RemoteControlCmd
+	// How long to wait for data from kitty before giving up
+	Timeout time.Duration
+	// Use Timeout as is, instead of increasing it to PasswordTimeout for
+	// commands sent with a password
+	ExactTimeout bool
+	// For commands whose payload is sent in multiple chunks. Called before
+	// each chunk is serialized, it must update Cmd.Payload and return true
+	// when the last chunk has been produced.
+	MultiplePayloadGenerator func() (is_last bool, err error)
+	// For commands that forward key events from the terminal to kitty
+	OnKeyEvent func(lp *loop.Loop, ke *loop.KeyEvent) error
+
+	chunks_done bool
+}
+
+// NewRequest creates a request to run the remote control command named cmd,
+// for example, "ls" or "set-font-size", with the specified payload.
+func NewRequest(cmd string, payload any) *Request {
+	return &Request{
+		Cmd:     &utils.RemoteControlCmd{Cmd: cmd, Version: ProtocolVersion, Payload: payload},
+		Timeout: DefaultTimeout,
+	}
+}
+
+// MakeAsync marks the request as one that kitty responds to asynchronously,
+// such as select-window. If no response is received within the timeout, the
+// request is cancelled.
+func (self *Request) MakeAsync() (err error) {
+	self.Cmd.Async, err = utils.HumanRandomId(128)
+	return
+}
+
+// MakeStreaming marks the request as one whose payload is streamed to kitty
+// in multiple chunks, see MultiplePayloadGenerator.
+func (self *Request) MakeStreaming() (err error) {
+	self.Cmd.StreamId, err = utils.HumanRandomId(128)
+	self.Cmd.Stream = err == nil
+	return
+}
+
+func (self *Request) next_chunk(serializer Serializer) (chunk []byte, err error) {
+	if self.chunks_done {
+		return make([]byte, 0), nil
+	}
+	if self.MultiplePayloadGenerator != nil {
+		is_last, err := self.MultiplePayloadGenerator()
+		if err != nil {
+			return nil, err
+		}
+		if is_last {
+			self.chunks_done = true
+		}
+		return serializer(self.Cmd)
+	}
+	self.chunks_done = true
+	return serializer(self.Cmd)
+}
+
+type Client struct {
+	// The socket to connect to, as returned by utils.ParseSocketAddress. When
+	// Network is empty, commands are sent via the controlling terminal.
+	Network, Address string
+	Password         string
+	// The public key of the kitty instance, used when Password is set.
+	// Defaults to the value of the KITTY_PUBLIC_KEY environment variable.
+	PublicKey     string
+	KittyWindowId uint
+	// A pre-shared key used to seal all messages exchanged with kitty over a
+	// socket, see the remote_control_psk_file option in kitty.conf
+	PSK []byte
+	// How many times to retry connecting to kitty when its socket is not
+	// available, for example, because kitty is still starting up. The delay
+	// between attempts starts at RetryDelay and doubles after every attempt.
+	Retries    int
+	RetryDelay time.Duration
+	// How long to wait for a connection to kitty, DefaultTimeout if zero
+	ConnectTimeout time.Duration
+	// Created from Password and PublicKey if not set
+	Serializer Serializer
+	// If not nil, every message sent to kitty and every response received
+	// from it is written to it, on a line of its own, for debugging.
+	// Responses sealed with PSK are written unsealed, prefixed by (unsealed).
+	Echo io.Writer
+
+	conn       net.Conn
+	reader     *response_reader
+	serializer Serializer
+	psk        *psk_session
+}
+
+// NewClient creates a client for the kitty instance listening at the address
+// to, in the same form as accepted by kitty --listen-on. When to is empty
+// the controlling terminal is used, which only works when running inside a
+// kitty window.
+func NewClient(to, password string) (*Client, error) {
+	ans := Client{Password: password}
+	if to != "" {
+		network, address, err := utils.ParseSocketAddress(to)
+		if err != nil {
+			return nil, err
+		}
+		ans.Network, ans.Address = network, address
+	}
+	if wid, err := strconv.Atoi(os.Getenv("KITTY_WINDOW_ID")); err == nil && wid > 0 {
+		ans.KittyWindowId = uint(wid)
+	}
+	return &ans, nil
+}
+
+// Connect opens a connection to kitty that is re-used for all subsequent
+// requests until Close is called. Without it, a new connection is made for
+// every request.
+func (self *Client) Connect() (err error) {
+	if self.Network == "" {
+		return fmt.Errorf("Persistent connections are only possible when talking to kitty over a socket")
+	}
+	if self.conn != nil {
+		return nil
+	}
+	self.conn, err = self.dial()
+	if err == nil {
+		self.reader = new_response_reader(self.sealing_session())
+	}
+	return
+}
+
+func (self *Client) Close() (err error) {
+	if self.conn != nil {
+		err = self.conn.Close()
+		self.conn, self.reader = nil, nil
+	}
+	return
+}
+
+// sealing_session returns the session used to seal messages with the
+// pre-shared key, or nil if there is no pre-shared key
+func (self *Client) sealing_session() *psk_session {
+	if self.PSK == nil {
+		return nil
+	}
+	if self.psk == nil || !bytes.Equal(self.psk.psk, self.PSK) {
+		self.psk = new_psk_session(self.PSK)
+	}
+	return self.psk
+}
+
+func (self *Client) ensure_serializer() (err error) {
+	if self.serializer != nil {
+		return
+	}
+	if self.Serializer == nil {
+		// the fetched key can only be trusted if the connection is
+		if self.Password != "" && self.PublicKey == "" && os.Getenv("KITTY_PUBLIC_KEY") == "" && (self.PSK != nil || self.Network == "unix") {
+			if self.PublicKey, err = self.FetchPublicKey(); err != nil {
+				return
+			}
+		}
+		if self.Serializer, err = NewSerializer(self.Password, self.PublicKey); err != nil {
+			return
+		}
+		if self.PSK != nil && self.Network != "" {
+			self.Serializer = self.sealing_session().sealing(self.Serializer)
+		}
+	}
+	self.serializer = self.echoing(self.Serializer)
+	return
+}
+
+// echoing returns a serializer that also echoes the messages serialized by
+// serializer
+func (self *Client) echoing(serializer Serializer) Serializer {
+	if self.Echo == nil {
+		return serializer
+	}
+	return func(rc *utils.RemoteControlCmd) (ans []byte, err error) {
+		if ans, err = serializer(rc); err == nil {
+			self.echo('>', ans)
+		}
+		return
+	}
+}
+
+// echo writes data to Echo. Messages are echoed as sent, but responses
+// sealed with the pre-shared key are echoed after being unsealed, so they
+// are labelled as such.
+func (self *Client) echo(direction byte, data []byte) {
+	if self.Echo != nil {
+		line := make([]byte, 0, len(data)+16)
+		line = append(line, direction, ' ')
+		if direction == '<' && self.PSK != nil && self.Network != "" {
+			line = append(line, "(unsealed) "...)
+		}
+		line = append(append(line, data...), '\n')
+		self.Echo.Write(line)
+	}
+}
+
+// FetchPublicKey asks kitty for its public key over the socket, for when the
+// KITTY_PUBLIC_KEY environment variable is not available, such as when
+// controlling kitty on another machine. The key is only as trustworthy as
+// the connection, so use it with UNIX sockets or with a PSK.
+func (self *Client) FetchPublicKey() (ans string, err error) {
+	if self.Network == "" {
+		return "", fmt.Errorf("The public key of kitty can only be fetched when talking to it over a socket")
+	}
+	var serializer Serializer = SimpleSerializer
+	if self.PSK != nil {
+		serializer = self.sealing_session().sealing(serializer)
+	}
+	req := NewRequest("", nil)
+	req.Cmd.GetPublicKey = true
+	serialized_response, err := self.do_io(req, self.echoing(serializer))
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the public key of kitty: %w", classify_error(err))
+	}
+	r, err := self.response_for_request(req, serialized_response)
+	if err != nil {
+		return
+	}
+	if !r.Ok {
+		return "", fmt.Errorf("Failed to get the public key of kitty: %s", r.Error)
+	}
+	err = r.UnmarshalData(&ans)
+	return
+}
+
+// The maximum delay between attempts to connect to kitty
+const max_retry_delay = 5 * time.Second
+
+func (self *Client) dial() (conn net.Conn, err error) {
+	network := self.Network
+	// utils.ParseSocketAddress uses ip for tcp addresses with numeric hosts
+	switch network {
+	case "ip", "ip4", "ip6":
+		network = "tcp" + network[2:]
+	}
+	timeout := self.ConnectTimeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	delay := self.RetryDelay
+	for attempt := 0; ; attempt++ {
+		if conn, err = net.DialTimeout(network, self.Address, timeout); err == nil {
+			return
+		}
+		if attempt >= self.Retries || !is_retriable(err) {
+			return nil, &ConnectionError{err}
+		}
+		time.Sleep(delay)
+		delay = utils.Min(2*delay, max_retry_delay)
+	}
+}
+
+func (self *Client) do_io(req *Request, serializer Serializer) (serialized_response []byte, err error) {
+	if self.Network == "" {
+		return do_tty_io(req, serializer)
+	}
+	if self.conn != nil {
+		return do_socket_io(self.conn, self.reader, req, serializer)
+	}
+	conn, err := self.dial()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	return do_socket_io(conn, new_response_reader(self.sealing_session()), req, serializer)
+}
+
+func (self *Client) prepare(req *Request) (err error) {
+	if err = self.ensure_serializer(); err != nil {
+		return
+	}
+	if self.KittyWindowId > 0 && req.Cmd.KittyWindowId == 0 {
+		req.Cmd.KittyWindowId = self.KittyWindowId
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = DefaultTimeout
+	}
+	if self.Password != "" && req.Timeout < PasswordTimeout && !req.ExactTimeout {
+		req.Timeout = PasswordTimeout
+	}
+	return
+}
+
+func (self *Client) response_for_request(req *Request, serialized_response []byte) (*Response, error) {
+	if len(serialized_response) == 0 {
+		if req.Cmd.NoResponse {
+			return &Response{Ok: true}, nil
+		}
+		return nil, protocol_error("Received empty response from kitty")
+	}
+	self.echo('<', serialized_response)
+	return parse_response(serialized_response)
+}
+
+// Send sends the request to kitty and waits for the response. A nil error
+// does not mean the command succeeded, check Response.Ok for that.
+func (self *Client) Send(req *Request) (ans *Response, err error) {
+	if err = self.prepare(req); err != nil {
+		return
+	}
+	serialized_response, err := self.do_io(req, self.serializer)
+	self.finished(req)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) && req.Cmd.Async != "" {
+			self.cancel_async(req)
+		}
+		return nil, classify_error(err)
+	}
+	return self.response_for_request(req, serialized_response)
+}
+
+// cancel_async tells kitty to stop working on the async request req. Errors
+// are ignored as there is nothing useful to be done about them.
+func (self *Client) cancel_async(req *Request) {
+	req.Cmd.Payload = nil
+	req.Cmd.CancelAsync = true
+	req.Cmd.Stream = false
+	req.MultiplePayloadGenerator = nil
+	req.Cmd.NoResponse = true
+	req.chunks_done = false
+	self.do_io(req, self.serializer)
+	self.finished(req)
+}
+
+// finished is called when no more responses to req are expected
+func (self *Client) finished(req *Request) {
+	if self.psk != nil {
+		self.psk.finished(req.Cmd)
+	}
+}
+
+// Run is a convenience wrapper around Send for simple commands
+func (self *Client) Run(cmd string, payload any) (*Response, error) {
+	return self.Send(NewRequest(cmd, payload))
+}
@@ -0,0 +1,431 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kitty/tools/crypto"
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+// A fake kitty that responds to every command with its name, counting the
+// number of connections made to it
+func fake_kitty(t *testing.T) (addr string, num_of_connections *atomic.Int32) {
+	addr = filepath.Join(t.TempDir(), "sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	num_of_connections = &atomic.Int32{}
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+		p := wcswidth.EscapeCodeParser{}
+		p.HandleDCS = func(data []byte) error {
+			var cmd utils.RemoteControlCmd
+			if err := json.Unmarshal(data[len("@kitty-cmd"):], &cmd); err != nil {
+				return err
+			}
+			var response []byte
+			switch {
+			case cmd.CancelAsync:
+				return nil
+			case cmd.Cmd == "subscribe":
+				if err := WriteCommand(conn, []byte(`{"ok":true,"stream":true}`)); err != nil {
+					return err
+				}
+				for i := 0; i < 3; i++ {
+					response, _ = json.Marshal(map[string]any{"ok": true, "stream_id": cmd.StreamId, "data": map[string]any{"type": "focus", "window_id": i}})
+					if err := WriteCommand(conn, response); err != nil {
+						return err
+					}
+				}
+				return nil
+			case cmd.Stream && cmd.Payload.(map[string]any)["first"] == true:
+				response = []byte(`{"ok":true,"stream":true}`)
+			case cmd.NoResponse || (cmd.Stream && cmd.Payload.(map[string]any)["last"] != true):
+				return nil
+			case cmd.Cmd == "hang":
+				return nil
+			case cmd.Cmd == "garbage":
+				response = []byte(`not json`)
+			case cmd.Cmd == "fail":
+				response = []byte(`{"ok":false,"error":"failed","tb":"traceback"}`)
+			default:
+				response, _ = json.Marshal(map[string]any{"ok": true, "data": cmd.Cmd})
+			}
+			return WriteCommand(conn, response)
+		}
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			p.Parse(buf[:n])
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			num_of_connections.Add(1)
+			go handle(conn)
+		}
+	}()
+	return "unix:" + addr, num_of_connections
+}
+
+func TestClient(t *testing.T) {
+	addr, num_of_connections := fake_kitty(t)
+	client, err := NewClient(addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.KittyWindowId = 0
+
+	check := func(cmd, expected string) {
+		t.Helper()
+		r, err := client.Run(cmd, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Ok {
+			t.Fatalf("Command %s failed with error: %s", cmd, r.Error)
+		}
+		actual, is_string := r.DataAsText()
+		if !is_string || actual != expected {
+			t.Fatalf("Unexpected response for %s: %#v", cmd, actual)
+		}
+	}
+	check("ls", "ls")
+	check("set-font-size", "set-font-size")
+	if num_of_connections.Load() != 2 {
+		t.Fatalf("Unexpected number of connections: %d", num_of_connections.Load())
+	}
+
+	r, err := client.Run("fail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&Response{Ok: false, Error: "failed", Traceback: "traceback"}, r); diff != "" {
+		t.Fatalf("Unexpected failure response:\n%s", diff)
+	}
+
+	if err = client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	before := num_of_connections.Load()
+	check("ls", "ls")
+	check("launch", "launch")
+	req := NewRequest("set-window-logo", map[string]any{"first": true})
+	if err = req.MakeStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	chunks := []string{"a", "b", "c"}
+	is_first_call := true
+	req.MultiplePayloadGenerator = func() (bool, error) {
+		if is_first_call {
+			is_first_call = false
+			return false, nil
+		}
+		req.Cmd.Payload = map[string]any{"data": chunks[0], "last": len(chunks) == 1}
+		chunks = chunks[1:]
+		return len(chunks) == 0, nil
+	}
+	if r, err = client.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := r.DataAsText(); text != "set-window-logo" {
+		t.Fatalf("Unexpected response to streaming command: %#v", text)
+	}
+	req = NewRequest("send-text", nil)
+	req.Cmd.NoResponse = true
+	if r, err = client.Send(req); err != nil || !r.Ok {
+		t.Fatalf("Command with no response failed: %v", err)
+	}
+	check("ls", "ls")
+	if num_of_connections.Load() != before+1 {
+		t.Fatalf("Persistent connection was not re-used, %d connections made", num_of_connections.Load()-before)
+	}
+}
+
+func TestClientEcho(t *testing.T) {
+	addr, _ := fake_kitty(t)
+	client, err := NewClient(addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.KittyWindowId = 0
+	echo := strings.Builder{}
+	client.Echo = &echo
+	if _, err = client.Run("ls", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("> {\"cmd\":\"ls\",\"version\":[%d,%d,%d]}\n< {\"data\":\"ls\",\"ok\":true}\n", ProtocolVersion[0], ProtocolVersion[1], ProtocolVersion[2])
+	if diff := cmp.Diff(expected, echo.String()); diff != "" {
+		t.Fatalf("Unexpected echo:\n%s", diff)
+	}
+}
+
+func TestResponseDataAsText(t *testing.T) {
+	for data, expected := range map[string]string{
+		`"a\nb"`:  "a\nb",
+		`true`:    "True",
+		`false`:   "False",
+		`[1,2]`:   "[1,2]",
+		`null`:    "null",
+		``:        "",
+		`{"a":1}`: `{"a":1}`,
+	} {
+		r := Response{Data: json.RawMessage(data)}
+		if actual, _ := r.DataAsText(); actual != expected {
+			t.Fatalf("Unexpected text for %#v: %#v != %#v", data, expected, actual)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	addr, num_of_connections := fake_kitty(t)
+	client, err := NewClient(addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	pipeline, err := client.NewPipeline(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	record := func(r *Response, err error) {
+		if err != nil {
+			actual = append(actual, "failed: "+err.Error())
+			return
+		}
+		text, _ := r.DataAsText()
+		if !r.Ok {
+			text = "error: " + r.Error
+		}
+		actual = append(actual, text)
+	}
+	send := func(req *Request) {
+		if err := pipeline.Send(req, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cmd := range []string{"ls", "launch", "fail", "set-colors"} {
+		send(NewRequest(cmd, nil))
+	}
+	// a request that fails to be prepared is reported in order
+	t.Setenv("KITTY_PUBLIC_KEY", "invalid")
+	client.Password, client.Serializer, client.serializer = "secret", nil, nil
+	send(NewRequest("ls", nil))
+	client.Password, client.Serializer, client.serializer = "", nil, nil
+	req := NewRequest("send-text", nil)
+	req.Cmd.NoResponse = true
+	send(req)
+	req = NewRequest("set-window-logo", map[string]any{"first": true})
+	if err = req.MakeStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	is_first_call := true
+	req.MultiplePayloadGenerator = func() (bool, error) {
+		if is_first_call {
+			is_first_call = false
+			return false, nil
+		}
+		req.Cmd.Payload = map[string]any{"last": true}
+		return true, nil
+	}
+	send(req)
+	send(NewRequest("ls", nil))
+	if err = pipeline.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"ls", "launch", "error: failed", "set-colors", "failed: KITTY_PUBLIC_KEY environment variable does not have a : in it",
+		"", "set-window-logo", "ls"}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("Unexpected responses:\n%s", diff)
+	}
+	if num_of_connections.Load() != 1 {
+		t.Fatalf("Pipeline used %d connections", num_of_connections.Load())
+	}
+}
+
+func TestStreamResponses(t *testing.T) {
+	addr, _ := fake_kitty(t)
+	client, err := NewClient(addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewStreamingRequest("subscribe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []float64{}
+	stop := fmt.Errorf("stop")
+	err = client.StreamResponses(req, func(r *Response) error {
+		var event map[string]any
+		if err := r.UnmarshalData(&event); err != nil {
+			return err
+		}
+		actual = append(actual, event["window_id"].(float64))
+		if len(actual) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]float64{0, 1}, actual); diff != "" {
+		t.Fatalf("Unexpected events:\n%s", diff)
+	}
+	if !req.Cmd.CancelAsync {
+		t.Fatalf("Streaming was not cancelled")
+	}
+	if err = client.StreamResponses(NewRequest("subscribe", nil), nil); err == nil {
+		t.Fatalf("No error for request that does not stream responses")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	addr, _ := fake_kitty(t)
+	client, err := NewClient(addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := NewRequest("hang", nil)
+	req.Timeout = 10 * time.Millisecond
+	if _, err = client.Send(req); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Unexpected error for a command that timed out: %v", err)
+	}
+	var perr *ProtocolError
+	if _, err = client.Run("garbage", nil); !errors.As(err, &perr) {
+		t.Fatalf("Unexpected error for an invalid response: %v", err)
+	}
+
+	// kitty starts listening after a delay
+	addr = filepath.Join(t.TempDir(), "sock")
+	if client, err = NewClient("unix:"+addr, ""); err != nil {
+		t.Fatal(err)
+	}
+	var cerr *ConnectionError
+	if _, err = client.Run("ls", nil); !errors.As(err, &cerr) {
+		t.Fatalf("Unexpected error for a socket that does not exist: %v", err)
+	}
+	client.Retries, client.RetryDelay = 10, time.Millisecond
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			return
+		}
+		defer l.Close()
+		if conn, err := l.Accept(); err == nil {
+			defer conn.Close()
+			// respond only once the command has been received
+			received := []byte{}
+			buf := make([]byte, 1024)
+			for !bytes.HasSuffix(received, []byte(CmdEscapeCodeSuffix)) {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				received = append(received, buf[:n]...)
+			}
+			WriteCommand(conn, []byte(`{"ok":true}`))
+		}
+	}()
+	if r, err := client.Run("ls", nil); err != nil || !r.Ok {
+		t.Fatalf("Connecting to kitty was not retried: %#v %v", r, err)
+	}
+}
+
+func TestPSKSession(t *testing.T) {
+	psk := []byte("pre-shared key")
+	session := new_psk_session(psk)
+	serializer := session.sealing(SimpleSerializer)
+	req, other := NewRequest("ls", nil), NewRequest("ls", nil)
+	send := func(req *Request) (ans utils.SealedRemoteControlMessage) {
+		data, err := serializer(req.Cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal(data, &ans); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	sealed_req, sealed_other := send(req), send(other)
+	respond := func(key []byte, direction, in_reply_to string) []byte {
+		msg, err := crypto.Seal([]byte(`{"ok":true}`), key, direction, in_reply_to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ans, _ := json.Marshal(msg)
+		return ans
+	}
+	response := respond(psk, crypto.SealedResponse, sealed_req.IV)
+	if data, err := session.unseal(response, req); err != nil || string(data) != `{"ok":true}` {
+		t.Fatalf("Failed to unseal a response: %#v %v", string(data), err)
+	}
+	reflected, _ := json.Marshal(sealed_req)
+	for name, response := range map[string][]byte{
+		"replayed":                response,
+		"reflected":               reflected,
+		"for another request":     respond(psk, crypto.SealedResponse, sealed_other.IV),
+		"for an unknown request":  respond(psk, crypto.SealedResponse, "unknown"),
+		"sealed as a request":     respond(psk, crypto.SealedRequest, sealed_req.IV),
+		"sealed with another key": respond([]byte("other key"), crypto.SealedResponse, sealed_req.IV),
+	} {
+		var perr *ProtocolError
+		if _, err := session.unseal(response, req); !errors.As(err, &perr) {
+			t.Fatalf("A response %s was not rejected: %v", name, err)
+		}
+	}
+	// streaming requests get many responses
+	for i := 0; i < 2; i++ {
+		if _, err := session.unseal(respond(psk, crypto.SealedResponse, sealed_other.IV), other); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// streams can go on for longer than the IVs of plain requests are kept
+	stream, err := NewStreamingRequest("subscribe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed_stream := send(stream)
+	later := time.Now().Add(3 * crypto.MaxClockSkew)
+	session.now = func() time.Time { return later }
+	if _, err := session.unseal(respond(psk, crypto.SealedResponse, sealed_stream.IV), stream); err != nil {
+		t.Fatalf("A response to a stream was rejected after the clock skew: %v", err)
+	}
+	if _, err := session.unseal(respond(psk, crypto.SealedResponse, sealed_other.IV), other); err == nil {
+		t.Fatalf("A response to a plain request was accepted after the clock skew")
+	}
+	session.finished(stream.Cmd)
+	if _, err := session.unseal(respond(psk, crypto.SealedResponse, sealed_stream.IV), stream); err == nil {
+		t.Fatalf("A response to a finished stream was accepted")
+	}
+}
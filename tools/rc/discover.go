@@ -0,0 +1,89 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var _ = fmt.Print
+
+// DiscoverSockets returns the addresses of the UNIX sockets that kitty
+// instances belonging to the current user are listening on for remote control,
+// sorted, in the form accepted by kitty --listen-on. TCP sockets are not
+// discovered.
+func DiscoverSockets() (ans []string, err error) {
+	if ans, err = listening_kitty_sockets(); err != nil {
+		return nil, fmt.Errorf("Failed to find running kitty instances with error: %w", err)
+	}
+	slices.Sort(ans)
+	return slices.Compact(ans), nil
+}
+
+// MatchSockets returns the discovered sockets whose address matches the
+// shell glob pattern. The pattern is matched against the address both with
+// and without its unix: prefix.
+func MatchSockets(pattern string) (ans []string, err error) {
+	if _, err = filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid glob pattern: %#v", pattern)
+	}
+	sockets, err := DiscoverSockets()
+	if err != nil {
+		return
+	}
+	for _, addr := range sockets {
+		if matches_socket(pattern, addr) {
+			ans = append(ans, addr)
+		}
+	}
+	return
+}
+
+func matches_socket(pattern, addr string) bool {
+	if matched, _ := filepath.Match(pattern, addr); matched {
+		return true
+	}
+	matched, _ := filepath.Match(pattern, strings.TrimPrefix(addr, "unix:"))
+	return matched
+}
+
+// is_single_instance_socket returns true for the sockets that kitty
+// --single-instance listens on, named kitty-ipc-UID[-GROUP], which are not
+// remote control sockets, even though kitty accepts commands on them
+func is_single_instance_socket(path string) bool {
+	name := strings.TrimPrefix(filepath.Base(strings.TrimPrefix(path, "@")), ".")
+	return strings.HasPrefix(name, "kitty-ipc-")
+}
+
+// parse_proc_net_unix returns a map of inode number to path for all the
+// listening sockets in the contents of /proc/net/unix. Abstract sockets have
+// paths starting with @.
+func parse_proc_net_unix(src io.Reader) map[uint64]string {
+	// From include/linux/net.h
+	const SO_ACCEPTCON = 1 << 16
+	const SS_UNCONNECTED = 1
+	ans := make(map[uint64]string)
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		// Num RefCount Protocol Flags Type St Inode Path
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		flags, ferr := strconv.ParseUint(fields[3], 16, 32)
+		state, serr := strconv.ParseUint(fields[5], 16, 8)
+		inode, ierr := strconv.ParseUint(fields[6], 10, 64)
+		if ferr != nil || serr != nil || ierr != nil || flags&SO_ACCEPTCON == 0 || state != SS_UNCONNECTED {
+			continue
+		}
+		ans[inode] = fields[7]
+	}
+	return ans
+}
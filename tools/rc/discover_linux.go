@@ -0,0 +1,65 @@
+//go:build linux
+
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var _ = fmt.Print
+
+// listening_kitty_sockets finds the remote control sockets that are open in
+// the kitty processes of the current user. kitty listens on at most one
+// remote control socket, so only one socket is returned per process.
+func listening_kitty_sockets() (ans []string, err error) {
+	f, err := os.Open("/proc/net/unix")
+	if err != nil {
+		return
+	}
+	listening := parse_proc_net_unix(f)
+	f.Close()
+	if len(listening) == 0 {
+		return
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+	uid := uint32(os.Getuid())
+	for _, e := range entries {
+		if _, perr := strconv.Atoi(e.Name()); perr != nil || !e.IsDir() {
+			continue
+		}
+		if info, ierr := e.Info(); ierr != nil || info.Sys().(*syscall.Stat_t).Uid != uid {
+			continue
+		}
+		proc := filepath.Join("/proc", e.Name())
+		if comm, cerr := os.ReadFile(filepath.Join(proc, "comm")); cerr != nil || string(bytes.TrimSpace(comm)) != "kitty" {
+			continue
+		}
+		fds, ferr := os.ReadDir(filepath.Join(proc, "fd"))
+		if ferr != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, lerr := os.Readlink(filepath.Join(proc, "fd", fd.Name()))
+			if lerr != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			inode, ierr := strconv.ParseUint(strings.TrimSuffix(target[len("socket:["):], "]"), 10, 64)
+			if path, found := listening[inode]; ierr == nil && found && !is_single_instance_socket(path) {
+				ans = append(ans, "unix:"+path)
+				break
+			}
+		}
+	}
+	return
+}
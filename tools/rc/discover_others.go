@@ -0,0 +1,49 @@
+//go:build !linux
+
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+var _ = fmt.Print
+
+// listening_kitty_sockets finds the sockets of the current user with kitty in
+// their names in the temporary directories, as the sockets open in other
+// processes cannot be listed without privileges on these platforms
+func listening_kitty_sockets() (ans []string, err error) {
+	dirs := []string{os.TempDir(), "/tmp"}
+	if x := os.Getenv("XDG_RUNTIME_DIR"); x != "" {
+		dirs = append(dirs, x)
+	}
+	uid := uint32(os.Getuid())
+	seen := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		if dir = filepath.Clean(dir); seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		entries, rerr := os.ReadDir(dir)
+		if rerr != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.Type()&fs.ModeSocket == 0 || !strings.Contains(strings.ToLower(e.Name()), "kitty") || is_single_instance_socket(e.Name()) {
+				continue
+			}
+			if info, ierr := e.Info(); ierr == nil {
+				if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid == uid {
+					ans = append(ans, "unix:"+filepath.Join(dir, e.Name()))
+				}
+			}
+		}
+	}
+	return
+}
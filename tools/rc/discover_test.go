@@ -0,0 +1,43 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestDiscoverSockets(t *testing.T) {
+	proc_net_unix := `Num       RefCount Protocol Flags    Type St Inode Path
+00000000de3ca04a: 00000002 00000000 00010000 0001 01 50209 /tmp/kitty-123
+000000007f5bcc05: 00000003 00000000 00000000 0001 03 68838 /tmp/kitty-123
+0000000009398a21: 00000002 00000000 00010000 0001 01 68837 @mykitty-456
+0000000069a69468: 00000003 00000000 00000000 0001 03   659
+`
+	if diff := cmp.Diff(map[uint64]string{50209: "/tmp/kitty-123", 68837: "@mykitty-456"}, parse_proc_net_unix(strings.NewReader(proc_net_unix))); diff != "" {
+		t.Fatalf("Failed to parse /proc/net/unix:\n%s", diff)
+	}
+	for pattern, expected := range map[string]bool{
+		"unix:/tmp/kitty-*": true, "/tmp/kitty-*": true, "*": false, "/tmp/*": true, "unix:*": false, "/tmp/other-*": false,
+	} {
+		if actual := matches_socket(pattern, "unix:/tmp/kitty-123"); actual != expected {
+			t.Fatalf("Matching %#v returned %v", pattern, actual)
+		}
+	}
+	if !matches_socket("@mykitty-*", "unix:@mykitty-456") {
+		t.Fatalf("Abstract socket not matched")
+	}
+	for path, expected := range map[string]bool{
+		"@kitty-ipc-1000": true, "@kitty-ipc-1000-group": true, "/run/user/1000/kitty-ipc-1000.sock": true, "/home/u/.kitty-ipc-1000.sock": true,
+		"@mykitty-456": false, "/tmp/kitty-123": false, "/tmp/kitty-ipc/sock": false,
+	} {
+		if actual := is_single_instance_socket(path); actual != expected {
+			t.Fatalf("Single instance socket %#v not recognized correctly: %v", path, actual)
+		}
+	}
+}
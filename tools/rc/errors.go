@@ -0,0 +1,64 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+var _ = fmt.Print
+
+// ErrTimeout is returned when kitty does not respond in time
+var ErrTimeout = errors.New("Timed out waiting for a response from kitty")
+
+// ConnectionError is returned when connecting to kitty fails, after any
+// retries
+type ConnectionError struct {
+	Err error
+}
+
+func (self *ConnectionError) Error() string {
+	return fmt.Sprintf("Could not connect to kitty: %s", self.Err)
+}
+
+func (self *ConnectionError) Unwrap() error { return self.Err }
+
+// ProtocolError is returned when kitty sends something other than the
+// expected response
+type ProtocolError struct {
+	Err error
+}
+
+func (self *ProtocolError) Error() string { return self.Err.Error() }
+
+func (self *ProtocolError) Unwrap() error { return self.Err }
+
+func protocol_error(format string, args ...any) error {
+	return &ProtocolError{fmt.Errorf(format, args...)}
+}
+
+// is_retriable returns true for connection failures that can go away by
+// themselves, such as when kitty has not yet created its socket
+func is_retriable(err error) bool {
+	var nerr net.Error
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT) || (errors.As(err, &nerr) && nerr.Timeout())
+}
+
+// classify_error converts the low level errors from talking to kitty into
+// ErrTimeout and ProtocolError
+func classify_error(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, io.EOF):
+		return protocol_error("kitty closed the connection without sending a response")
+	}
+	return err
+}
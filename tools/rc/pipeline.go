@@ -0,0 +1,105 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"fmt"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+type pipelined_request struct {
+	req         *Request
+	on_response func(*Response, error)
+}
+
+// Pipeline sends requests over a persistent connection without waiting for
+// the response to one request before sending the next. kitty processes
+// commands from a connection in order, so responses are matched to requests
+// by position. Requests that need a dialog with kitty, such as streaming,
+// async or key event forwarding requests, are sent only after all pending
+// responses have been received.
+type Pipeline struct {
+	client        *Client
+	max_in_flight int
+	in_flight     []pipelined_request
+}
+
+// NewPipeline returns a pipeline that allows at most max_in_flight requests
+// to be pending a response at any time. If the client is not already
+// connected to kitty, a connection is made.
+func (self *Client) NewPipeline(max_in_flight int) (*Pipeline, error) {
+	if err := self.Connect(); err != nil {
+		return nil, err
+	}
+	return &Pipeline{client: self, max_in_flight: utils.Max(1, max_in_flight)}, nil
+}
+
+func can_be_pipelined(req *Request) bool {
+	return !req.Cmd.Stream && req.Cmd.Async == "" && req.OnKeyEvent == nil
+}
+
+func (self *Pipeline) read_one() error {
+	p := self.in_flight[0]
+	var serialized_response []byte
+	if !p.req.Cmd.NoResponse {
+		var err error
+		if serialized_response, err = self.client.reader.read(self.client.conn, p.req.Timeout, p.req); err != nil {
+			return classify_error(err)
+		}
+	}
+	self.in_flight = self.in_flight[1:]
+	p.on_response(self.client.response_for_request(p.req, serialized_response))
+	return nil
+}
+
+func (self *Pipeline) fail_pending(err error) error {
+	for _, p := range self.in_flight {
+		p.on_response(nil, err)
+	}
+	self.in_flight = self.in_flight[:0]
+	return err
+}
+
+// Send queues req, calling on_response once its response has been received.
+// on_response is called for requests in the order they were sent. The
+// returned error is only non-nil if the connection to kitty failed, in which
+// case on_response is called for all pending requests with that error.
+func (self *Pipeline) Send(req *Request, on_response func(*Response, error)) (err error) {
+	if perr := self.client.prepare(req); perr != nil {
+		// the failure must be reported after the responses to the
+		// requests sent before this one
+		err = self.Flush()
+		on_response(nil, perr)
+		return err
+	}
+	if !can_be_pipelined(req) {
+		if err = self.Flush(); err != nil {
+			return err
+		}
+		on_response(self.client.Send(req))
+		return nil
+	}
+	for len(self.in_flight) >= self.max_in_flight {
+		if err = self.read_one(); err != nil {
+			return self.fail_pending(err)
+		}
+	}
+	self.in_flight = append(self.in_flight, pipelined_request{req, on_response})
+	if _, err = write_request_to_conn(self.client.conn, self.client.reader, req, self.client.serializer); err != nil {
+		return self.fail_pending(err)
+	}
+	return nil
+}
+
+// Flush waits for the responses to all pending requests
+func (self *Pipeline) Flush() (err error) {
+	for len(self.in_flight) > 0 {
+		if err = self.read_one(); err != nil {
+			return self.fail_pending(err)
+		}
+	}
+	return
+}
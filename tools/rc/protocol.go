@@ -0,0 +1,244 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"kitty"
+	"kitty/tools/crypto"
+	"kitty/tools/utils"
+
+	"github.com/jamesruan/go-rfc1924/base85"
+)
+
+var _ = fmt.Print
+
+var ProtocolVersion [3]int = [3]int{0, 26, 0}
+
+const CmdEscapeCodePrefix = "\x1bP@kitty-cmd"
+const CmdEscapeCodeSuffix = "\x1b\\"
+
+// Returned by a multiple payload generator to indicate it is waiting for
+// key events from STDIN before it can produce the next chunk
+var ErrWaitingOnStdin = errors.New("wait for key events from STDIN")
+
+// Returned by a key event handler to indicate reading from STDIN is done
+var ErrEndReadingFromStdin = errors.New("end reading from STDIN")
+
+type Serializer func(rc *utils.RemoteControlCmd) ([]byte, error)
+
+func SimpleSerializer(rc *utils.RemoteControlCmd) (ans []byte, err error) {
+	return json.Marshal(rc)
+}
+
+// GetPubkey decodes a public key of the form version:base85 data, as found in
+// the KITTY_PUBLIC_KEY environment variable. If encoded_key is empty,
+// the environment variable is used.
+func GetPubkey(encoded_key string) (encryption_version string, pubkey []byte, err error) {
+	if encoded_key == "" {
+		encoded_key = os.Getenv("KITTY_PUBLIC_KEY")
+		if encoded_key == "" {
+			err = fmt.Errorf("Password usage requested but KITTY_PUBLIC_KEY environment variable is not available")
+			return
+		}
+	}
+	encryption_version, encoded_key, found := strings.Cut(encoded_key, ":")
+	if !found {
+		err = fmt.Errorf("KITTY_PUBLIC_KEY environment variable does not have a : in it")
+		return
+	}
+	if encryption_version != kitty.RC_ENCRYPTION_PROTOCOL_VERSION {
+		err = fmt.Errorf("KITTY_PUBLIC_KEY has unknown version, if you are running on a remote system, update kitty on this system")
+		return
+	}
+	pubkey = make([]byte, base85.DecodedLen(len(encoded_key)))
+	n, err := base85.Decode(pubkey, []byte(encoded_key))
+	if err == nil {
+		pubkey = pubkey[:n]
+	}
+	return
+}
+
+// NewSerializer returns a serializer that encrypts commands with password
+// when it is not empty, and sends them as plain JSON otherwise.
+func NewSerializer(password string, encoded_pubkey string) (Serializer, error) {
+	if password == "" {
+		return SimpleSerializer, nil
+	}
+	encryption_version, pubkey, err := GetPubkey(encoded_pubkey)
+	if err != nil {
+		return nil, err
+	}
+	return func(rc *utils.RemoteControlCmd) (ans []byte, err error) {
+		ec, err := crypto.Encrypt_cmd(rc, password, pubkey, encryption_version)
+		if err != nil {
+			return
+		}
+		return json.Marshal(ec)
+	}, nil
+}
+
+// The state needed to exchange messages sealed with a pre-shared key with
+// kitty, as required by kitty instances listening on TCP sockets with
+// remote_control_psk_file set. Responses are only accepted if they are sealed
+// as responses to a request sent in this session and have not been received
+// before, so that they cannot be reflected, swapped or replayed.
+type psk_session struct {
+	psk   []byte
+	mutex sync.Mutex
+	// the IVs of the sealed requests and the commands they were for
+	sent map[string]sealed_request
+	// the IVs of the received responses and when they were received
+	received map[string]time.Time
+	now      func() time.Time
+}
+
+type sealed_request struct {
+	cmd     *utils.RemoteControlCmd
+	sent_at time.Time
+	// streaming and async requests can receive responses long after they
+	// were sent, so their IVs are kept until they are finished
+	long_lived bool
+}
+
+func new_psk_session(psk []byte) *psk_session {
+	return &psk_session{psk: psk, sent: make(map[string]sealed_request), received: make(map[string]time.Time), now: time.Now}
+}
+
+// forget removes IVs that are too old to be used in a valid message
+func (self *psk_session) forget(now time.Time) {
+	for iv, r := range self.sent {
+		if !r.long_lived && now.Sub(r.sent_at) > 2*crypto.MaxClockSkew {
+			delete(self.sent, iv)
+		}
+	}
+	for iv, t := range self.received {
+		if now.Sub(t) > 2*crypto.MaxClockSkew {
+			delete(self.received, iv)
+		}
+	}
+}
+
+// sealing returns a serializer that seals the commands serialized by
+// serializer
+func (self *psk_session) sealing(serializer Serializer) Serializer {
+	return func(rc *utils.RemoteControlCmd) (ans []byte, err error) {
+		data, err := serializer(rc)
+		if err != nil {
+			return
+		}
+		sealed, err := crypto.Seal(data, self.psk, crypto.SealedRequest, "")
+		if err != nil {
+			return
+		}
+		self.mutex.Lock()
+		now := self.now()
+		self.forget(now)
+		self.sent[sealed.IV] = sealed_request{rc, now, rc.Async != "" || rc.StreamId != ""}
+		self.mutex.Unlock()
+		return json.Marshal(sealed)
+	}
+}
+
+// finished removes the IVs of the messages sent for cmd, once no more
+// responses to it are expected
+func (self *psk_session) finished(cmd *utils.RemoteControlCmd) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for iv, r := range self.sent {
+		if r.cmd == cmd {
+			delete(self.sent, iv)
+		}
+	}
+}
+
+// unseal returns the response to req in serialized_response
+func (self *psk_session) unseal(serialized_response []byte, req *Request) ([]byte, error) {
+	var msg utils.SealedRemoteControlMessage
+	if err := json.Unmarshal(serialized_response, &msg); err != nil || msg.Sealed == "" {
+		return nil, protocol_error("Received a response from kitty that is not sealed with the pre-shared key")
+	}
+	ans, err := crypto.Unseal(&msg, self.psk, crypto.SealedResponse)
+	if err != nil {
+		return nil, &ProtocolError{err}
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	now := self.now()
+	self.forget(now)
+	if _, found := self.received[msg.IV]; found {
+		return nil, protocol_error("Received a response from kitty that was already received, could be an attempt at a replay attack")
+	}
+	if r, found := self.sent[msg.InReplyTo]; !found || r.cmd != req.Cmd {
+		return nil, protocol_error("Received a response from kitty that is not for the command that was sent")
+	}
+	self.received[msg.IV] = now
+	return ans, nil
+}
+
+type Response struct {
+	Ok        bool            `json:"ok"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Traceback string          `json:"tb,omitempty"`
+}
+
+// DataAsText returns the data in the response formatted the way kitty
+// formats it for display, with booleans as True/False.
+func (self *Response) DataAsText() (text string, is_string bool) {
+	data := self.Data
+	if bytes.HasPrefix(data, []byte("\"")) {
+		if json.Unmarshal(data, &text) == nil {
+			is_string = true
+		}
+		return
+	}
+	if bytes.Equal(data, []byte("true")) {
+		text = "True"
+	} else if bytes.Equal(data, []byte("false")) {
+		text = "False"
+	} else {
+		text = string(data)
+	}
+	return
+}
+
+// UnmarshalData decodes the data in the response into v
+func (self *Response) UnmarshalData(v any) error {
+	if len(self.Data) == 0 {
+		return fmt.Errorf("The response from kitty contains no data")
+	}
+	return json.Unmarshal(self.Data, v)
+}
+
+func parse_response(serialized_response []byte) (ans *Response, err error) {
+	var response Response
+	err = json.Unmarshal(serialized_response, &response)
+	if err != nil {
+		err = protocol_error("Invalid response received from kitty, unmarshalling error: %w", err)
+		return
+	}
+	return &response, nil
+}
+
+type stream_response struct {
+	Ok     bool `json:"ok"`
+	Stream bool `json:"stream"`
+}
+
+func IsStreamResponse(serialized_response []byte) bool {
+	var response stream_response
+	if len(serialized_response) > 32 {
+		return false
+	}
+	err := json.Unmarshal(serialized_response, &response)
+	return err == nil && response.Stream
+}
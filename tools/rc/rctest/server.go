@@ -0,0 +1,322 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+// Package rctest provides a mock kitty instance that can be used to test
+// programs that control kitty via the remote control protocol, without
+// needing a running kitty.
+package rctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/exp/slices"
+
+	"kitty/tools/crypto"
+	"kitty/tools/rc"
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// A command received by the server
+type Command struct {
+	utils.RemoteControlCmd
+	// True if the command was encrypted with the public key of the server
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// A function that decides the response to a command. Returning nil means
+// no response is sent.
+type Handler func(cmd *Command) *rc.Response
+
+type Server struct {
+	// The address the server is listening on, in the form accepted by
+	// kitty --listen-on
+	ListenOn string
+	// The encoded public key to use for encrypting commands, in the form
+	// expected in the KITTY_PUBLIC_KEY environment variable
+	PublicKey string
+	// If not empty, commands must have this password or they are refused
+	Password string
+	// Called with every command received, in the order they are received,
+	// from the goroutine serving the connection
+	OnCommand func(cmd *Command)
+	// If not nil, messages must be sealed with this pre-shared key, like
+	// kitty does when remote_control_psk_file is set. Messages that are not
+	// are ignored.
+	PSK []byte
+
+	private_key []byte
+	listener    net.Listener
+	temp_dir    string
+	mutex       sync.Mutex
+	commands    []*Command
+	responses   map[string][]*rc.Response
+	handler     Handler
+	streams     map[string]bool
+	conns       map[net.Conn]bool
+	wg          sync.WaitGroup
+}
+
+// NewServer starts a server listening at the address listen_on, which is
+// in the same form as accepted by kitty --listen-on, for example,
+// unix:/tmp/sock or tcp:localhost:0. When listen_on is empty, a unix socket
+// in a temporary directory is used.
+func NewServer(listen_on string) (ans *Server, err error) {
+	ans = &Server{responses: make(map[string][]*rc.Response), streams: make(map[string]bool), conns: make(map[net.Conn]bool)}
+	if listen_on == "" {
+		if ans.temp_dir, err = os.MkdirTemp("", "kitty-mock-rc-server-"); err != nil {
+			return nil, err
+		}
+		listen_on = "unix:" + filepath.Join(ans.temp_dir, "sock")
+	}
+	network, address, err := utils.ParseSocketAddress(listen_on)
+	if err != nil {
+		ans.Close()
+		return nil, err
+	}
+	switch network {
+	case "ip", "ip4", "ip6":
+		// utils.ParseSocketAddress uses ip for tcp addresses with numeric hosts
+		network = "tcp" + network[2:]
+	}
+	if ans.listener, err = net.Listen(network, address); err != nil {
+		ans.Close()
+		return nil, err
+	}
+	switch network {
+	case "unix":
+		ans.ListenOn = "unix:" + address
+	default:
+		// use the actual port when an ephemeral port was requested
+		ans.ListenOn = network + ":" + ans.listener.Addr().String()
+	}
+	var pubkey []byte
+	if ans.private_key, pubkey, err = crypto.KeyPair("1"); err == nil {
+		ans.PublicKey, err = crypto.EncodePublicKey(pubkey, "1")
+	}
+	if err != nil {
+		ans.Close()
+		return nil, err
+	}
+	ans.wg.Add(1)
+	go ans.accept_loop()
+	return
+}
+
+// Respond sets the responses to send for the command named cmd, for example,
+// "ls". The responses are used in order, with the last one being used for all
+// subsequent commands. Commands without responses get the response
+// {"ok": true}.
+func (self *Server) Respond(cmd string, responses ...*rc.Response) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.responses[cmd] = responses
+}
+
+// RespondWith uses handler to decide the response for all commands, instead
+// of the responses set with Respond
+func (self *Server) RespondWith(handler Handler) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.handler = handler
+}
+
+// Commands returns all commands received so far
+func (self *Server) Commands() []*Command {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return slices.Clone(self.commands)
+}
+
+// Close stops the server, closing all open connections
+func (self *Server) Close() error {
+	var err error
+	if self.listener != nil {
+		err = self.listener.Close()
+		self.mutex.Lock()
+		for conn := range self.conns {
+			conn.Close()
+		}
+		self.mutex.Unlock()
+		self.wg.Wait()
+		self.listener = nil
+	}
+	if self.temp_dir != "" {
+		os.RemoveAll(self.temp_dir)
+		self.temp_dir = ""
+	}
+	return err
+}
+
+func (self *Server) accept_loop() {
+	defer self.wg.Done()
+	for {
+		conn, err := self.listener.Accept()
+		if err != nil {
+			return
+		}
+		self.mutex.Lock()
+		self.conns[conn] = true
+		self.mutex.Unlock()
+		self.wg.Add(1)
+		go self.serve(conn)
+	}
+}
+
+func (self *Server) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		self.mutex.Lock()
+		delete(self.conns, conn)
+		self.mutex.Unlock()
+		self.wg.Done()
+	}()
+	parser := wcswidth.EscapeCodeParser{}
+	parser.HandleDCS = func(data []byte) (err error) {
+		if !bytes.HasPrefix(data, []byte("@kitty-cmd")) {
+			return nil
+		}
+		data = data[len("@kitty-cmd"):]
+		// the IV of the sealed request, responses must be sealed as replies to it
+		var in_reply_to string
+		if self.PSK != nil {
+			var sealed utils.SealedRemoteControlMessage
+			if json.Unmarshal(data, &sealed) != nil {
+				return nil
+			}
+			if data, err = crypto.Unseal(&sealed, self.PSK, crypto.SealedRequest); err != nil {
+				return nil
+			}
+			in_reply_to = sealed.IV
+		}
+		response, err := self.handle_command(data)
+		if err != nil || response == nil {
+			return err
+		}
+		if self.PSK != nil {
+			sealed, err := crypto.Seal(response, self.PSK, crypto.SealedResponse, in_reply_to)
+			if err != nil {
+				return err
+			}
+			if response, err = json.Marshal(sealed); err != nil {
+				return err
+			}
+		}
+		return rc.WriteCommand(conn, response)
+	}
+	buf := make([]byte, utils.DEFAULT_IO_BUFFER_SIZE)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if err = parser.Parse(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func error_response(msg string, args ...any) ([]byte, error) {
+	return json.Marshal(rc.Response{Ok: false, Error: fmt.Sprintf(msg, args...)})
+}
+
+func (self *Server) parse_command(data []byte) (cmd *Command, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return
+	}
+	cmd = &Command{}
+	if _, is_encrypted := fields["encrypted"]; !is_encrypted {
+		if err = json.Unmarshal(data, &cmd.RemoteControlCmd); err != nil {
+			return nil, err
+		}
+		return
+	}
+	var encrypted utils.EncryptedRemoteControlCmd
+	if err = json.Unmarshal(data, &encrypted); err != nil {
+		return nil, err
+	}
+	if cmd.RemoteControlCmd, err = crypto.Decrypt_cmd(&encrypted, self.private_key); err != nil {
+		return nil, err
+	}
+	cmd.Encrypted = true
+	return
+}
+
+// handle_command returns the serialized response to the serialized command,
+// following the same rules as kitty for which commands get a response
+func (self *Server) handle_command(data []byte) ([]byte, error) {
+	cmd, err := self.parse_command(data)
+	if err != nil {
+		return error_response("Failed to parse remote control command: %s", err)
+	}
+	if cmd.GetPublicKey {
+		return json.Marshal(map[string]any{"ok": true, "data": self.PublicKey})
+	}
+	self.mutex.Lock()
+	self.commands = append(self.commands, cmd)
+	on_command := self.OnCommand
+	is_continuation := cmd.StreamId != "" && self.streams[cmd.StreamId]
+	if cmd.StreamId != "" {
+		self.streams[cmd.StreamId] = true
+	}
+	self.mutex.Unlock()
+	if on_command != nil {
+		on_command(cmd)
+	}
+	if cmd.CancelAsync {
+		return nil, nil
+	}
+	if self.Password != "" && cmd.Password != self.Password && !is_continuation {
+		if cmd.NoResponse {
+			return nil, nil
+		}
+		return error_response("The user rejected this password or it is disallowed by remote_control_password in kitty.conf")
+	}
+	if cmd.Stream && !is_continuation {
+		return []byte(`{"ok":true,"stream":true}`), nil
+	}
+	if is_continuation && !is_last_chunk(cmd) {
+		return nil, nil
+	}
+	response := self.response_for(cmd)
+	if cmd.NoResponse || response == nil {
+		return nil, nil
+	}
+	return json.Marshal(response)
+}
+
+func is_last_chunk(cmd *Command) bool {
+	if p, ok := cmd.Payload.(map[string]any); ok {
+		data, _ := p["data"].(string)
+		return data == ""
+	}
+	return true
+}
+
+func (self *Server) response_for(cmd *Command) *rc.Response {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if handler := self.handler; handler != nil {
+		// allow the handler to use the methods of the server
+		self.mutex.Unlock()
+		defer self.mutex.Lock()
+		return handler(cmd)
+	}
+	responses := self.responses[cmd.Cmd]
+	switch len(responses) {
+	case 0:
+		return &rc.Response{Ok: true}
+	case 1:
+		return responses[0]
+	}
+	self.responses[cmd.Cmd] = responses[1:]
+	return responses[0]
+}
@@ -0,0 +1,199 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rctest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"kitty/tools/rc"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func new_server(t *testing.T, listen_on string) *Server {
+	s, err := NewServer(listen_on)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func new_client(t *testing.T, s *Server, password string) *rc.Client {
+	c, err := rc.NewClient(s.ListenOn, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PublicKey = s.PublicKey
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestMockServer(t *testing.T) {
+	for _, listen_on := range []string{"", "tcp:localhost:0", "tcp:127.0.0.1:0"} {
+		s := new_server(t, listen_on)
+		c := new_client(t, s, "")
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		s.Respond("ls", &rc.Response{Ok: true, Data: []byte(`"one"`)}, &rc.Response{Ok: true, Data: []byte(`"two"`)})
+		for _, expected := range []string{`"one"`, `"two"`, `"two"`} {
+			r, err := c.Run("ls", map[string]any{"all_env_vars": true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(r.Data) != expected {
+				t.Fatalf("Unexpected response for %s: %#v", listen_on, r)
+			}
+		}
+		req := rc.NewRequest("send-text", map[string]any{"data": "text:x"})
+		req.Cmd.NoResponse = true
+		if r, err := c.Send(req); err != nil || !r.Ok || r.Data != nil {
+			t.Fatalf("Unexpected response for a command with no_response: %#v %v", r, err)
+		}
+		// the response to this command ensures the previous one has been received
+		if r, err := c.Run("close-window", nil); err != nil || !r.Ok {
+			t.Fatalf("Unexpected default response: %#v %v", r, err)
+		}
+		cmds := s.Commands()
+		if diff := cmp.Diff([]string{"ls", "ls", "ls", "send-text", "close-window"}, names(cmds)); diff != "" {
+			t.Fatalf("Unexpected commands recorded:\n%s", diff)
+		}
+		if cmds[0].Encrypted || cmds[0].Payload.(map[string]any)["all_env_vars"] != true {
+			t.Fatalf("Command not recorded correctly: %#v", cmds[0])
+		}
+	}
+}
+
+func TestMockServerPassword(t *testing.T) {
+	s := new_server(t, "")
+	s.Password = "secret"
+	r, err := new_client(t, s, "secret").Run("ls", nil)
+	if err != nil || !r.Ok {
+		t.Fatalf("Command with correct password failed: %#v %v", r, err)
+	}
+	r, err = new_client(t, s, "wrong").Run("ls", nil)
+	if err != nil || r.Ok {
+		t.Fatalf("Command with incorrect password succeeded: %#v %v", r, err)
+	}
+	cmds := s.Commands()
+	if len(cmds) != 2 || !cmds[0].Encrypted || cmds[0].Password != "secret" || cmds[1].Password != "wrong" {
+		t.Fatalf("Encrypted commands not decrypted correctly: %#v", cmds)
+	}
+	if time.Since(time.Unix(0, cmds[0].Timestamp)) > time.Minute {
+		t.Fatalf("Incorrect timestamp for decrypted command: %d", cmds[0].Timestamp)
+	}
+}
+
+func TestMockServerHandler(t *testing.T) {
+	s := new_server(t, "")
+	s.RespondWith(func(cmd *Command) *rc.Response {
+		if cmd.Cmd == "ignored" {
+			return nil
+		}
+		return &rc.Response{Ok: true, Data: []byte(fmt.Sprint(len(s.Commands())))}
+	})
+	c := new_client(t, s, "")
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"1", "2"} {
+		if r, err := c.Run("ls", nil); err != nil || string(r.Data) != expected {
+			t.Fatalf("Unexpected response from handler: %#v %v", r, err)
+		}
+	}
+	req := rc.NewRequest("ignored", nil)
+	req.Timeout = 50 * time.Millisecond
+	if _, err := c.Send(req); err == nil {
+		t.Fatalf("Did not time out waiting for a response the handler did not send")
+	}
+}
+
+func TestMockServerStreaming(t *testing.T) {
+	s := new_server(t, "")
+	c := new_client(t, s, "")
+	chunks := []string{"a", "b", ""}
+	req := rc.NewRequest("set-background-image", nil)
+	if err := req.MakeStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	req.MultiplePayloadGenerator = func() (bool, error) {
+		req.Cmd.Payload = map[string]any{"data": chunks[0]}
+		chunks = chunks[1:]
+		return len(chunks) == 0, nil
+	}
+	if r, err := c.Send(req); err != nil || !r.Ok {
+		t.Fatalf("Streaming command failed: %#v %v", r, err)
+	}
+	if n := len(s.Commands()); n != 3 {
+		t.Fatalf("Unexpected number of chunks recorded: %d", n)
+	}
+}
+
+func names(cmds []*Command) (ans []string) {
+	for _, c := range cmds {
+		ans = append(ans, c.Cmd)
+	}
+	return
+}
+
+func TestMockServerPSK(t *testing.T) {
+	t.Setenv("KITTY_PUBLIC_KEY", "")
+	s := new_server(t, "tcp:127.0.0.1:0")
+	s.PSK = []byte("pre-shared key")
+	c := new_client(t, s, "secret")
+	c.PublicKey = ""
+	c.PSK = s.PSK
+	// the public key is fetched over the connection as it is not in the environment
+	r, err := c.Run("ls", nil)
+	if err != nil || !r.Ok {
+		t.Fatalf("Command with pre-shared key failed: %#v %v", r, err)
+	}
+	if c.PublicKey != s.PublicKey {
+		t.Fatalf("Public key not fetched over the connection: %#v != %#v", c.PublicKey, s.PublicKey)
+	}
+	cmds := s.Commands()
+	if len(cmds) != 1 || !cmds[0].Encrypted || cmds[0].Password != "secret" {
+		t.Fatalf("Command not received correctly: %#v", cmds)
+	}
+
+	for _, psk := range []string{"", "incorrect key"} {
+		c = new_client(t, s, "")
+		if psk != "" {
+			c.PSK = []byte(psk)
+		}
+		req := rc.NewRequest("ls", nil)
+		req.Timeout = 50 * time.Millisecond
+		if r, err := c.Send(req); err == nil {
+			t.Fatalf("Command with pre-shared key %#v did not fail: %#v", psk, r)
+		}
+	}
+	if n := len(s.Commands()); n != 1 {
+		t.Fatalf("Commands without the correct pre-shared key were not ignored: %d", n)
+	}
+
+	// sealed messages are echoed as sent and responses are labelled as unsealed
+	c = new_client(t, s, "")
+	c.PSK = s.PSK
+	echo := strings.Builder{}
+	c.Echo = &echo
+	if _, err = c.Run("ls", nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(echo.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `> {"timestamp":`) || !strings.Contains(lines[0], `"sealed":`) || !strings.HasPrefix(lines[1], `< (unsealed) {"ok":true`) {
+		t.Fatalf("Unexpected echo: %#v", lines)
+	}
+
+	// kitty only sends public keys over trusted connections
+	c = new_client(t, s, "secret")
+	c.PublicKey = ""
+	if _, err = c.Run("ls", nil); err == nil || !strings.Contains(err.Error(), "KITTY_PUBLIC_KEY") {
+		t.Fatalf("Public key fetched over an untrusted connection: %v", err)
+	}
+}
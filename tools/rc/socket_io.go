@@ -0,0 +1,178 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"kitty/tools/tui/loop"
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+func write_all_to_conn(conn net.Conn, data []byte) error {
+	for len(data) > 0 {
+		n, err := conn.Write(data)
+		if err != nil && errors.Is(err, io.ErrShortWrite) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func write_many_to_conn(conn net.Conn, datums ...[]byte) error {
+	for len(datums) > 0 {
+		err := write_all_to_conn(conn, datums[0])
+		if err != nil {
+			return err
+		}
+		datums = datums[1:]
+	}
+	return nil
+}
+
+// WriteCommand writes a single serialized command to conn wrapped in the
+// escape code kitty expects
+func WriteCommand(conn net.Conn, chunk []byte) error {
+	return write_many_to_conn(conn, []byte(CmdEscapeCodePrefix), chunk, []byte(CmdEscapeCodeSuffix))
+}
+
+// Reads responses from a connection. Since a connection can carry many
+// responses, any data read beyond the end of a response is kept for the
+// next call.
+type response_reader struct {
+	parser  wcswidth.EscapeCodeParser
+	pending [][]byte
+	buf     []byte
+	// when not nil, responses must be sealed with its pre-shared key
+	psk *psk_session
+}
+
+func new_response_reader(psk *psk_session) *response_reader {
+	ans := response_reader{buf: make([]byte, utils.DEFAULT_IO_BUFFER_SIZE), psk: psk}
+	ans.parser.HandleDCS = func(data []byte) error {
+		if bytes.HasPrefix(data, []byte("@kitty-cmd")) {
+			ans.pending = append(ans.pending, bytes.Clone(data[len("@kitty-cmd"):]))
+		}
+		return nil
+	}
+	return &ans
+}
+
+// read returns the next response, which must be for req, waiting at most
+// timeout for data to arrive. A timeout <= 0 leaves the current deadline of
+// the connection unchanged.
+func (self *response_reader) read(conn net.Conn, timeout time.Duration, req *Request) (serialized_response []byte, err error) {
+	for len(self.pending) == 0 {
+		var n int
+		if timeout > 0 {
+			conn.SetDeadline(time.Now().Add(timeout))
+		}
+		n, err = conn.Read(self.buf)
+		if err != nil {
+			return
+		}
+		self.parser.Parse(self.buf[:n])
+	}
+	serialized_response = self.pending[0]
+	self.pending = self.pending[1:]
+	if self.psk != nil {
+		serialized_response, err = self.psk.unseal(serialized_response, req)
+	}
+	return
+}
+
+func run_stdin_echo_loop(conn net.Conn, req *Request, serializer Serializer) (err error) {
+	lp, err := loop.New(loop.NoAlternateScreen, loop.NoRestoreColors)
+	if err != nil {
+		return
+	}
+	lp.OnKeyEvent = func(event *loop.KeyEvent) error {
+		event.Handled = true
+		err = req.OnKeyEvent(lp, event)
+		if err != nil {
+			if err == ErrEndReadingFromStdin {
+				lp.Quit(0)
+				return nil
+			}
+			return err
+		}
+		chunk, err := req.next_chunk(serializer)
+		if err != nil {
+			if err == ErrWaitingOnStdin {
+				return nil
+			}
+			return err
+		}
+		return WriteCommand(conn, chunk)
+	}
+	err = lp.Run()
+	if err == nil {
+		lp.KillIfSignalled()
+	}
+	return err
+}
+
+// write_request_to_conn sends all the chunks of the request. stdin_was_read is
+// true if the last chunks were generated from key events read from STDIN, in
+// which case no response is sent by kitty.
+func write_request_to_conn(conn net.Conn, reader *response_reader, req *Request, serializer Serializer) (stdin_was_read bool, err error) {
+	const (
+		BEFORE_FIRST_ESCAPE_CODE_SENT = iota
+		SENDING
+	)
+	state := BEFORE_FIRST_ESCAPE_CODE_SENT
+
+	wants_streaming := req.Cmd.Stream
+	for {
+		var chunk []byte
+		chunk, err = req.next_chunk(serializer)
+		if err != nil {
+			if err == ErrWaitingOnStdin {
+				return true, run_stdin_echo_loop(conn, req, serializer)
+			}
+			return
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		err = WriteCommand(conn, chunk)
+		if err != nil {
+			return
+		}
+		if state == BEFORE_FIRST_ESCAPE_CODE_SENT {
+			if wants_streaming {
+				var streaming_response []byte
+				streaming_response, err = reader.read(conn, req.Timeout, req)
+				if err != nil {
+					return
+				}
+				if !IsStreamResponse(streaming_response) {
+					err = protocol_error("Did not receive expected streaming response")
+					return
+				}
+			}
+			state = SENDING
+		}
+	}
+	return
+}
+
+func do_socket_io(conn net.Conn, reader *response_reader, req *Request, serializer Serializer) (serialized_response []byte, err error) {
+	stdin_was_read, err := write_request_to_conn(conn, reader, req, serializer)
+	if err != nil || stdin_was_read || req.Cmd.NoResponse {
+		return
+	}
+	return reader.read(conn, req.Timeout, req)
+}
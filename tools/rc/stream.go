@@ -0,0 +1,169 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package rc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"kitty/tools/tui/loop"
+)
+
+var _ = fmt.Print
+
+// NewStreamingRequest creates a request for a command such as subscribe that
+// responds with a stream of responses instead of a single one. Use it with
+// Client.StreamResponses.
+func NewStreamingRequest(cmd string, payload any) (ans *Request, err error) {
+	ans = NewRequest(cmd, payload)
+	if err = ans.MakeAsync(); err == nil {
+		err = ans.MakeStreaming()
+	}
+	return
+}
+
+func do_socket_stream_io(conn net.Conn, reader *response_reader, req *Request, serializer Serializer, on_response func(*Response) error) (err error) {
+	chunk, err := req.next_chunk(serializer)
+	if err != nil {
+		return
+	}
+	if err = WriteCommand(conn, chunk); err != nil {
+		return
+	}
+	interrupted := atomic.Bool{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGINT, unix.SIGTERM)
+	defer signal.Stop(signals)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-signals:
+			interrupted.Store(true)
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	timeout := req.Timeout
+	for {
+		serialized_response, err := reader.read(conn, timeout, req)
+		if err != nil {
+			if interrupted.Load() {
+				return nil
+			}
+			return err
+		}
+		if timeout > 0 {
+			// responses can now take arbitrarily long to arrive
+			timeout = 0
+			conn.SetDeadline(time.Time{})
+			if interrupted.Load() {
+				return nil
+			}
+			if IsStreamResponse(serialized_response) {
+				continue
+			}
+		}
+		r, err := parse_response(serialized_response)
+		if err != nil {
+			return err
+		}
+		if err = on_response(r); err != nil || !r.Ok {
+			return err
+		}
+	}
+}
+
+func do_tty_stream_io(req *Request, serializer Serializer, on_response func(*Response) error) (err error) {
+	lp, err := loop.New(loop.NoAlternateScreen, loop.NoRestoreColors, loop.OnlyDisambiguateKeys)
+	if err != nil {
+		return
+	}
+	acknowledged := false
+
+	lp.OnInitialize = func() (string, error) {
+		chunk, err := req.next_chunk(serializer)
+		if err != nil {
+			return "", err
+		}
+		lp.QueueWriteString(CmdEscapeCodePrefix)
+		lp.UnsafeQueueWriteBytes(chunk)
+		lp.QueueWriteString(CmdEscapeCodeSuffix)
+		lp.AddTimer(req.Timeout, false, func(loop.IdType) error {
+			if !acknowledged {
+				return os.ErrDeadlineExceeded
+			}
+			return nil
+		})
+		return "", nil
+	}
+
+	lp.OnRCResponse = func(raw []byte) error {
+		if !acknowledged {
+			acknowledged = true
+			if IsStreamResponse(raw) {
+				return nil
+			}
+		}
+		r, err := parse_response(raw)
+		if err != nil {
+			return err
+		}
+		if err = on_response(r); err != nil {
+			return err
+		}
+		if !r.Ok {
+			lp.Quit(0)
+		}
+		return nil
+	}
+
+	// Ctrl+C and SIGINT make the loop quit without an error which is how
+	// streaming is normally ended
+	return lp.Run()
+}
+
+// StreamResponses sends req, which must have been created by
+// NewStreamingRequest, and calls on_response with every response kitty sends
+// until on_response returns an error, kitty sends a response that is not Ok
+// or the user interrupts the program with Ctrl+C or SIGINT/SIGTERM. When
+// streaming ends, kitty is told to stop sending responses.
+func (self *Client) StreamResponses(req *Request, on_response func(*Response) error) (err error) {
+	if !req.Cmd.Stream || req.Cmd.Async == "" {
+		return fmt.Errorf("StreamResponses can only be used with requests created by NewStreamingRequest")
+	}
+	if err = self.prepare(req); err != nil {
+		return
+	}
+	if self.Echo != nil {
+		callback := on_response
+		on_response = func(r *Response) error {
+			if serialized, err := json.Marshal(r); err == nil {
+				self.echo('<', serialized)
+			}
+			return callback(r)
+		}
+	}
+	switch {
+	case self.Network == "":
+		err = do_tty_stream_io(req, self.serializer, on_response)
+	case self.conn != nil:
+		err = do_socket_stream_io(self.conn, self.reader, req, self.serializer, on_response)
+	default:
+		conn, derr := self.dial()
+		if derr != nil {
+			return derr
+		}
+		defer conn.Close()
+		err = do_socket_stream_io(conn, new_response_reader(self.sealing_session()), req, self.serializer, on_response)
+	}
+	self.cancel_async(req)
+	return classify_error(err)
+}
@@ -1,38 +1,23 @@
-// License: GPLv3 Copyright: 2022, Kovid Goyal, <kovid at kovidgoyal.net>
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
 
-package at
+package rc
 
 import (
-	"encoding/json"
 	"os"
 	"time"
 
 	"kitty/tools/tui/loop"
 )
 
-type stream_response struct {
-	Ok     bool `json:"ok"`
-	Stream bool `json:"stream"`
-}
-
-func is_stream_response(serialized_response []byte) bool {
-	var response stream_response
-	if len(serialized_response) > 32 {
-		return false
-	}
-	err := json.Unmarshal(serialized_response, &response)
-	return err == nil && response.Stream
-}
-
-func do_chunked_io(io_data *rc_io_data) (serialized_response []byte, err error) {
+func do_tty_io(req *Request, serializer Serializer) (serialized_response []byte, err error) {
 	serialized_response = make([]byte, 0)
 	lp, err := loop.New(loop.NoAlternateScreen, loop.NoRestoreColors, loop.OnlyDisambiguateKeys)
-	if io_data.on_key_event != nil {
-		lp.FullKeyboardProtocol()
-	}
 	if err != nil {
 		return
 	}
+	if req.OnKeyEvent != nil {
+		lp.FullKeyboardProtocol()
+	}
 
 	const (
 		BEFORE_FIRST_ESCAPE_CODE_SENT = iota
@@ -49,23 +34,23 @@ func do_chunked_io(io_data *rc_io_data) (serialized_response []byte, err error)
 		if state != WAITING_FOR_RESPONSE && state != WAITING_FOR_STREAMING_RESPONSE {
 			return nil
 		}
-		if io_data.on_key_event != nil {
+		if req.OnKeyEvent != nil {
 			return nil
 		}
 		time_since_last_received_data := time.Now().Sub(last_received_data_at)
-		if time_since_last_received_data >= io_data.timeout {
+		if time_since_last_received_data >= req.Timeout {
 			return os.ErrDeadlineExceeded
 		}
-		lp.AddTimer(io_data.timeout-time_since_last_received_data, false, check_for_timeout)
+		lp.AddTimer(req.Timeout-time_since_last_received_data, false, check_for_timeout)
 		return nil
 	}
 
 	transition_to_read := func() {
-		if state == WAITING_FOR_RESPONSE && io_data.rc.NoResponse {
+		if state == WAITING_FOR_RESPONSE && req.Cmd.NoResponse {
 			lp.Quit(0)
 		}
 		last_received_data_at = time.Now()
-		lp.AddTimer(io_data.timeout, false, check_for_timeout)
+		lp.AddTimer(req.Timeout, false, check_for_timeout)
 	}
 
 	lp.OnReceivedData = func(data []byte) error {
@@ -74,16 +59,16 @@ func do_chunked_io(io_data *rc_io_data) (serialized_response []byte, err error)
 	}
 
 	queue_escape_code := func(data []byte) {
-		lp.QueueWriteString(cmd_escape_code_prefix)
+		lp.QueueWriteString(CmdEscapeCodePrefix)
 		lp.UnsafeQueueWriteBytes(data)
-		lp.QueueWriteString(cmd_escape_code_suffix)
+		lp.QueueWriteString(CmdEscapeCodeSuffix)
 	}
 
 	lp.OnInitialize = func() (string, error) {
-		chunk, err := io_data.next_chunk()
-		wants_streaming = io_data.rc.Stream
+		chunk, err := req.next_chunk(serializer)
+		wants_streaming = req.Cmd.Stream
 		if err != nil {
-			if err == waiting_on_stdin {
+			if err == ErrWaitingOnStdin {
 				return "", nil
 			}
 			return "", err
@@ -101,9 +86,9 @@ func do_chunked_io(io_data *rc_io_data) (serialized_response []byte, err error)
 		if state == WAITING_FOR_STREAMING_RESPONSE || state == WAITING_FOR_RESPONSE {
 			return nil
 		}
-		chunk, err := io_data.next_chunk()
+		chunk, err := req.next_chunk(serializer)
 		if err != nil {
-			if err == waiting_on_stdin {
+			if err == ErrWaitingOnStdin {
 				return nil
 			}
 			return err
@@ -126,20 +111,20 @@ func do_chunked_io(io_data *rc_io_data) (serialized_response []byte, err error)
 	}
 
 	lp.OnKeyEvent = func(event *loop.KeyEvent) error {
-		if io_data.on_key_event == nil {
+		if req.OnKeyEvent == nil {
 			return nil
 		}
-		err := io_data.on_key_event(lp, event)
-		if err == end_reading_from_stdin {
+		err := req.OnKeyEvent(lp, event)
+		if err == ErrEndReadingFromStdin {
 			lp.Quit(0)
 			return nil
 		}
 		if err != nil {
 			return err
 		}
-		chunk, err := io_data.next_chunk()
+		chunk, err := req.next_chunk(serializer)
 		if err != nil {
-			if err == waiting_on_stdin {
+			if err == ErrWaitingOnStdin {
 				return nil
 			}
 			return err
@@ -149,7 +134,7 @@ func do_chunked_io(io_data *rc_io_data) (serialized_response []byte, err error)
 	}
 
 	lp.OnRCResponse = func(raw []byte) error {
-		if state == WAITING_FOR_STREAMING_RESPONSE && is_stream_response(raw) {
+		if state == WAITING_FOR_STREAMING_RESPONSE && IsStreamResponse(raw) {
 			state = SENDING
 			return lp.OnWriteComplete(0)
 		}
@@ -165,7 +150,3 @@ func do_chunked_io(io_data *rc_io_data) (serialized_response []byte, err error)
 	return
 
 }
-
-func do_tty_io(io_data *rc_io_data) (serialized_response []byte, err error) {
-	return do_chunked_io(io_data)
-}
@@ -118,6 +118,33 @@ func (self *ImageCollection) AddPaths(paths ...string) {
 	}
 }
 
+// SourceImage returns the full size image data for key or nil if the image
+// has not been loaded or failed to load
+func (self *ImageCollection) SourceImage(key string) *images.ImageData {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	img := self.images[key]
+	if img == nil || !img.src.loaded || img.err != nil {
+		return nil
+	}
+	return img.src.data
+}
+
+// AddImage adds already loaded image data to the collection, unless an image
+// with the same key is already present
+func (self *ImageCollection) AddImage(key string, data *images.ImageData) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.images[key] == nil {
+		i := NewImage()
+		i.src.path = key
+		i.src.data = data
+		i.src.size = Size{data.Width, data.Height}
+		i.src.loaded = true
+		self.images[key] = i
+	}
+}
+
 func (self *Image) ResizeForPageSize(width, height int) {
 	sz := Size{width, height}
 	if self.renderings[sz] != nil {
@@ -218,18 +245,35 @@ func (self *ImageCollection) Finalize(lp *loop.Loop) {
 		tr.remove()
 	}
 	for _, img := range self.images {
-		for _, r := range img.renderings {
-			if r.image_id > 0 {
-				g := self.new_graphics_command()
-				g.SetAction(GRT_action_delete).SetDelete(GRT_free_by_id).SetImageId(r.image_id)
-				g.WriteWithPayloadToLoop(lp, nil)
-			}
-		}
-		img.renderings = nil
+		self.free_renderings(lp, img)
 	}
 	self.images = nil
 }
 
+func (self *ImageCollection) free_renderings(lp *loop.Loop, img *Image) {
+	for _, r := range img.renderings {
+		if r.image_id > 0 {
+			g := self.new_graphics_command()
+			g.SetAction(GRT_action_delete).SetDelete(GRT_free_by_id).SetImageId(r.image_id)
+			g.WriteWithPayloadToLoop(lp, nil)
+		}
+	}
+	img.renderings = nil
+}
+
+// RemoveImages removes the images from the collection, freeing the ones that
+// have been transmitted to the terminal
+func (self *ImageCollection) RemoveImages(lp *loop.Loop, keys ...string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for _, key := range keys {
+		if img := self.images[key]; img != nil {
+			self.free_renderings(lp, img)
+			delete(self.images, key)
+		}
+	}
+}
+
 func (self *ImageCollection) mark_img_as_needing_transmission(id uint32) bool {
 	self.mutex.Lock()
 	defer self.mutex.Unlock()
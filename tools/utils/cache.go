@@ -70,3 +70,18 @@ func (self *LRUCache[K, V]) MustGetOrCreate(key K, create func(key K) V) V {
 	self.lock.Unlock()
 	return ans
 }
+
+func (self *LRUCache[K, V]) Delete(key K) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, found := self.data[key]; !found {
+		return
+	}
+	delete(self.data, key)
+	for e := self.lru.Front(); e != nil; e = e.Next() {
+		if e.Value.(K) == key {
+			self.lru.Remove(e)
+			break
+		}
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"golang.org/x/sys/unix"
 )
 
 var _ = fmt.Print
@@ -44,10 +46,44 @@ func ExtractAllFromTar(tr *tar.Reader, dest_path string, optss ...TarExtractOpti
 		return
 	}
 
+	// the paths of entries, and the targets of links, must be inside
+	// dest_path, and entries must not be written through symlinks, as the
+	// tar file may come from an untrusted source
+	is_inside := func(path string) bool {
+		rel, err := filepath.Rel(dest_path, path)
+		return err == nil && filepath.IsLocal(rel)
+	}
+	check_parents := func(dest string) error {
+		rel, err := filepath.Rel(dest_path, filepath.Dir(dest))
+		if err != nil {
+			return err
+		}
+		q := dest_path
+		for _, x := range strings.Split(rel, string(os.PathSeparator)) {
+			if x == "." {
+				continue
+			}
+			q = filepath.Join(q, x)
+			if s, err := os.Lstat(q); err == nil && s.Mode()&fs.ModeSymlink != 0 {
+				return fmt.Errorf("The tar file entry %s is inside the symlink %s", dest, q)
+			}
+		}
+		return nil
+	}
+	// remove_existing removes whatever is at dest unless it is a directory, so
+	// that it is replaced rather than written through
+	remove_existing := func(dest string) error {
+		if s, err := os.Lstat(dest); err == nil && !s.IsDir() {
+			return os.Remove(dest)
+		}
+		return nil
+	}
+
 	for {
 		var hdr *tar.Header
 		hdr, err = tr.Next()
 		if errors.Is(err, io.EOF) {
+			err = nil
 			break
 		}
 		if err != nil {
@@ -59,8 +95,16 @@ func ExtractAllFromTar(tr *tar.Reader, dest_path string, optss ...TarExtractOpti
 			continue
 		}
 		dest = filepath.Join(dest_path, dest)
+		if err = check_parents(dest); err != nil {
+			return
+		}
 		switch hdr.Typeflag {
 		case tar.TypeDir:
+			if s, serr := os.Lstat(dest); serr == nil && s.Mode()&fs.ModeSymlink != 0 {
+				if err = os.Remove(dest); err != nil {
+					return
+				}
+			}
 			err = os.MkdirAll(dest, 0o700)
 			if err != nil {
 				return
@@ -69,8 +113,11 @@ func ExtractAllFromTar(tr *tar.Reader, dest_path string, optss ...TarExtractOpti
 				return
 			}
 		case tar.TypeReg, tar.TypeRegA:
+			if err = remove_existing(dest); err != nil {
+				return
+			}
 			var d *os.File
-			if d, err = os.Create(dest); err != nil {
+			if d, err = os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, 0o600); err != nil {
 				return
 			}
 			err = set_metadata(d.Chmod, hdr)
@@ -82,13 +129,42 @@ func ExtractAllFromTar(tr *tar.Reader, dest_path string, optss ...TarExtractOpti
 				return
 			}
 		case tar.TypeLink:
-			if err = os.Link(hdr.Linkname, dest); err != nil {
+			// the target of a hard link is the name of an earlier entry
+			target := strings.TrimLeft(hdr.Linkname, "/")
+			if !filepath.IsLocal(target) {
+				return count, fmt.Errorf("The tar file entry %s is a hard link to %s outside the destination", hdr.Name, hdr.Linkname)
+			}
+			target = filepath.Join(dest_path, target)
+			if err = check_parents(target); err != nil {
+				return
+			}
+			if s, serr := os.Lstat(target); serr != nil || !s.Mode().IsRegular() {
+				return count, fmt.Errorf("The tar file entry %s is a hard link to %s which is not a regular file", hdr.Name, hdr.Linkname)
+			}
+			if err = remove_existing(dest); err != nil {
+				return
+			}
+			if err = os.Link(target, dest); err != nil {
 				return
 			}
 			if err = set_metadata(func(m fs.FileMode) error { return os.Chmod(dest, m) }, hdr); err != nil {
 				return
 			}
 		case tar.TypeSymlink:
+			// .. is only allowed at the start of the target, after that it
+			// could be applied to a symlink, escaping the destination.
+			// Symlinks to outside the destination, such as the absolute ones
+			// common in /etc, are skipped rather than failing the extraction.
+			target := filepath.FromSlash(hdr.Linkname)
+			for strings.HasPrefix(target, "."+string(os.PathSeparator)) {
+				target = strings.TrimLeft(target[1:], string(os.PathSeparator))
+			}
+			if filepath.IsAbs(target) || target != filepath.Clean(target) || !is_inside(filepath.Join(filepath.Dir(dest), target)) {
+				continue
+			}
+			if err = remove_existing(dest); err != nil {
+				return
+			}
 			if err = os.Symlink(hdr.Linkname, dest); err != nil {
 				return
 			}
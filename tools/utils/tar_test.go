@@ -0,0 +1,113 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestExtractAllFromTar(t *testing.T) {
+	tdir := t.TempDir()
+	outside := filepath.Join(tdir, "outside")
+	os.WriteFile(outside, []byte("outside"), 0o600)
+	extract := func(entries ...tar.Header) (string, error) {
+		buf := bytes.Buffer{}
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range entries {
+			hdr.Mode = 0o644
+			data := []byte(hdr.Name)
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = int64(len(data))
+			}
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				tw.Write(data)
+			}
+		}
+		tw.Close()
+		dest, err := os.MkdirTemp(tdir, "dest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ExtractAllFromTar(tar.NewReader(&buf), dest)
+		return dest, err
+	}
+	dir := func(name string) tar.Header { return tar.Header{Name: name, Typeflag: tar.TypeDir} }
+	file := func(name string) tar.Header { return tar.Header{Name: name, Typeflag: tar.TypeReg} }
+	link := func(name, target string, typ byte) tar.Header {
+		return tar.Header{Name: name, Linkname: target, Typeflag: typ}
+	}
+	check_outside := func() {
+		if data, _ := os.ReadFile(outside); string(data) != "outside" {
+			t.Fatalf("A file outside the destination was changed: %#v", string(data))
+		}
+	}
+
+	dest, err := extract(
+		dir("d"), file("d/f"), link("s", "d/f", tar.TypeSymlink), link("d/s", "../s", tar.TypeSymlink), link("h", "d/f", tar.TypeLink),
+		link("d/dot", "./f", tar.TypeSymlink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"d/f", "s", "d/s", "h", "d/dot"} {
+		if data, err := os.ReadFile(filepath.Join(dest, name)); err != nil || string(data) != "d/f" {
+			t.Fatalf("Incorrect contents for %s: %#v %v", name, string(data), err)
+		}
+	}
+
+	// symlinks to outside the destination are skipped, as in a copy of /etc
+	dest, err = extract(
+		dir("etc"), file("etc/hostname"), link("etc/localtime", "/usr/share/zoneinfo/UTC", tar.TypeSymlink),
+		link("etc/s", "../../outside", tar.TypeSymlink), link("l", ".", tar.TypeSymlink), link("etc/e", "../l/../../outside", tar.TypeSymlink),
+		file("etc/passwd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_outside()
+	for _, name := range []string{"etc/localtime", "etc/s", "etc/e"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); err == nil {
+			t.Fatalf("The symlink to outside the destination %s was extracted", name)
+		}
+	}
+	for _, name := range []string{"etc/hostname", "etc/passwd"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); err != nil {
+			t.Fatalf("The entry %s after a skipped symlink was not extracted: %v", name, err)
+		}
+	}
+
+	for _, entries := range [][]tar.Header{
+		{link("l", ".", tar.TypeSymlink), file("l/f")},
+		{link("h", "../outside", tar.TypeLink)},
+		{link("s", "f", tar.TypeSymlink), link("h", "s", tar.TypeLink)},
+	} {
+		if _, err := extract(entries...); err == nil {
+			t.Fatalf("No error extracting the unsafe entries: %v", entries)
+		}
+		check_outside()
+	}
+
+	// existing symlinks are replaced, not written through
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "f", Typeflag: tar.TypeReg, Mode: 0o600, Size: 1})
+	tw.Write([]byte("f"))
+	tw.Close()
+	dest = t.TempDir()
+	os.Symlink(outside, filepath.Join(dest, "f"))
+	if _, err = ExtractAllFromTar(tar.NewReader(&buf), dest); err != nil {
+		t.Fatal(err)
+	}
+	check_outside()
+	if s, err := os.Lstat(filepath.Join(dest, "f")); err != nil || !s.Mode().IsRegular() {
+		t.Fatalf("The existing symlink was not replaced: %v", err)
+	}
+}
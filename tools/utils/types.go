@@ -13,6 +13,7 @@ type RemoteControlCmd struct {
 	Stream        bool   `json:"stream,omitempty"`
 	StreamId      string `json:"stream_id,omitempty"`
 	KittyWindowId uint   `json:"kitty_window_id,omitempty"`
+	GetPublicKey  bool   `json:"get_public_key,omitempty"`
 	Payload       any    `json:"payload,omitempty"`
 }
 
@@ -24,3 +25,14 @@ type EncryptedRemoteControlCmd struct {
 	Encrypted string `json:"encrypted"`
 	EncProto  string `json:"enc_proto,omitempty"`
 }
+
+// A message sealed with a pre-shared key, used for both commands and
+// responses when talking to kitty over TCP
+type SealedRemoteControlMessage struct {
+	Timestamp int64  `json:"timestamp"`
+	IV        string `json:"iv"`
+	Tag       string `json:"tag"`
+	Sealed    string `json:"sealed"`
+	// The IV of the request a response is for
+	InReplyTo string `json:"in_reply_to,omitempty"`
+}